@@ -13,10 +13,10 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/kevwan/radix.v2/pool"
@@ -26,6 +26,9 @@ import (
 const (
 	defaultPoolSize  = 5
 	defaultMaxActive = 50
+
+	// the most nodes Reset will try calling CLUSTER SLOTS on
+	maxResetTries = 3
 )
 
 type mapping [NumSlots]string
@@ -81,6 +84,11 @@ type Opts struct {
 	// default. This will be ignored if the Dialer field is set.
 	Timeout time.Duration
 
+	// Limits on the size of the replies which will be read by the individual
+	// redis clients, see redis.ReadLimits. This will be ignored if the Dialer
+	// field is set, use the ReadLimits field on redis.DialOpts instead.
+	ReadLimits redis.ReadLimits
+
 	// The size of the connection pool to use for each host
 	PoolSize int
 
@@ -93,12 +101,14 @@ type Opts struct {
 	PoolThrottle time.Duration
 
 	// The time which must elapse between subsequent calls to Reset(). The
-	// default is 500 milliseconds
+	// default is 500 milliseconds. A negative value disables the throttle,
+	// which is mostly useful in tests.
 	ResetThrottle time.Duration
 
 	// The function which will be used to create connections within the pool for
 	// each redis cluster instance. The common use-case is to do authentication
-	// for new connections. Defaults to using redis.DialTimeout if not set.
+	// for new connections, which can be done using the DialFunc method on
+	// redis.DialOpts. Defaults to using redis.DialTimeout if not set.
 	Dialer DialFunc
 }
 
@@ -138,7 +148,12 @@ func NewWithOpts(o Opts) (*Cluster, error) {
 	}
 	if o.Dialer == nil {
 		o.Dialer = func(_, addr string) (*redis.Client, error) {
-			return redis.DialTimeout("tcp", addr, o.Timeout)
+			c, err := redis.DialTimeout("tcp", addr, o.Timeout)
+			if err != nil {
+				return nil, err
+			}
+			c.SetReadLimits(o.ReadLimits)
+			return c, nil
 		}
 	}
 
@@ -206,8 +221,16 @@ func (c *Cluster) spin() {
 // is set. If the given pool couldn't be used a connection from a random pool
 // will (attempt) to be returned
 func (c *Cluster) getConn(key, addr string) (*redis.Client, error) {
+	return c.getConnContext(context.Background(), key, addr)
+}
+
+func (c *Cluster) getConnContext(
+	ctx context.Context, key, addr string,
+) (
+	*redis.Client, error,
+) {
 	respCh := make(chan clusterPool)
-	c.callCh <- func(c *Cluster) {
+	f := func(c *Cluster) {
 		if key != "" {
 			addr = keyToAddr(key, &c.mapping)
 		}
@@ -224,7 +247,12 @@ func (c *Cluster) getConn(key, addr string) (*redis.Client, error) {
 		respCh <- p
 	}
 
-	return (<-respCh).Get()
+	select {
+	case c.callCh <- f:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return (<-respCh).GetContext(ctx)
 }
 
 // Put putss the connection back in its pool. To be used alongside any of the
@@ -250,8 +278,10 @@ func (c *Cluster) getRandomPoolInner() clusterPool {
 
 // Reset will re-retrieve the cluster topology and set up/teardown connections
 // as necessary. It begins by calling CLUSTER SLOTS on a random known
-// connection. The return from that is used to re-create the topology, create
-// any missing clients, and close any clients which are no longer needed.
+// connection, moving on to up to two others if that fails, so a Reset may take
+// up to three times the Timeout if nodes are unreachable. The return from that
+// is used to re-create the topology, create any missing clients, and close any
+// clients which are no longer needed.
 //
 // This call is inherently throttled, so that multiple clients can call it at
 // the same time and it will only actually occur once (subsequent clients will
@@ -267,23 +297,41 @@ func (c *Cluster) Reset() error {
 func (c *Cluster) resetInner() error {
 	// Throttle resetting so a bunch of routines can call Reset at once and the
 	// server won't be spammed. We don't a throttle until the second Reset is
-	// called, so the initial call inside New goes through correctly
-	if c.resetThrottle != nil {
-		select {
-		case <-c.resetThrottle.C:
-		default:
-			return nil
+	// called, so the initial call inside New goes through correctly. A
+	// negative ResetThrottle turns this off.
+	if c.o.ResetThrottle > 0 {
+		if c.resetThrottle != nil {
+			select {
+			case <-c.resetThrottle.C:
+			default:
+				return nil
+			}
+		} else {
+			c.resetThrottle = time.NewTicker(c.o.ResetThrottle)
 		}
-	} else {
-		c.resetThrottle = time.NewTicker(c.o.ResetThrottle)
 	}
 
-	p := c.getRandomPoolInner()
-	if p.Pool == nil {
+	if len(c.pools) == 0 {
 		return fmt.Errorf("no available nodes to call CLUSTER SLOTS on")
 	}
 
-	return c.resetInnerUsingPool(p)
+	// The node picked first may well be one which has gone away, which is
+	// often why Reset is being called, so a few others are tried after it.
+	// This all happens on the spin go-routine, which blocks every other
+	// command, so the number tried is limited.
+	first := c.getRandomPoolInner()
+	err := c.resetInnerUsingPool(first)
+	tries := 1
+	for addr, p := range c.pools {
+		if err == nil || tries >= maxResetTries {
+			break
+		} else if addr == first.Addr {
+			continue
+		}
+		err = c.resetInnerUsingPool(p)
+		tries++
+	}
+	return err
 }
 
 func (c *Cluster) resetInnerUsingPool(p clusterPool) error {
@@ -403,6 +451,16 @@ func (c *Cluster) resetInnerUsingPool(p clusterPool) error {
 // functions in the util package. They properly handle the cluster client being
 // used.
 func (c *Cluster) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	return c.CmdContext(context.Background(), cmd, args...)
+}
+
+// CmdContext is like Cmd, but the command is bound by the given context. The
+// context is passed to every node the command is tried on (see the CmdContext
+// method on redis.Client), and redirects are no longer followed once it has
+// expired.
+func (c *Cluster) CmdContext(
+	ctx context.Context, cmd string, args ...interface{},
+) *redis.Resp {
 	if len(args) < 1 {
 		return errorResp(ErrBadCmdNoKey)
 	}
@@ -412,12 +470,12 @@ func (c *Cluster) Cmd(cmd string, args ...interface{}) *redis.Resp {
 		return errorResp(err)
 	}
 
-	client, err := c.getConn(key, "")
+	client, err := c.getConnContext(ctx, key, "")
 	if err != nil {
 		return errorResp(err)
 	}
 
-	return c.clientCmd(client, cmd, args, false, nil, false)
+	return c.clientCmd(ctx, client, cmd, args, false, nil, false)
 }
 
 func haveTried(tried map[string]bool, addr string) bool {
@@ -436,15 +494,21 @@ func justTried(tried map[string]bool, addr string) map[string]bool {
 }
 
 func (c *Cluster) clientCmd(
-	client *redis.Client, cmd string, args []interface{}, ask bool,
-	tried map[string]bool, haveReset bool,
+	ctx context.Context, client *redis.Client, cmd string, args []interface{},
+	ask bool, tried map[string]bool, haveReset bool,
 ) *redis.Resp {
 	var err error
 	var r *redis.Resp
 	defer c.Put(client)
 
+	// Every redirect comes back through here, so this is where we give up if
+	// the context has expired
+	if err = ctx.Err(); err != nil {
+		return redis.NewRespIOErr(err)
+	}
+
 	if ask {
-		r = client.Cmd("ASKING")
+		r = client.CmdContext(ctx, "ASKING")
 		ask = false
 	}
 
@@ -452,7 +516,7 @@ func (c *Cluster) clientCmd(
 	// would normally do. If we didn't ask or the ask succeeded we do the
 	// command normally, and see how that goes
 	if r == nil || r.Err == nil {
-		r = client.Cmd(cmd, args...)
+		r = client.CmdContext(ctx, cmd, args...)
 	}
 
 	if err = r.Err; err == nil {
@@ -466,12 +530,17 @@ func (c *Cluster) clientCmd(
 	haveTriedBefore := haveTried(tried, client.Addr)
 	tried = justTried(tried, client.Addr)
 
-	// Deal with network error
+	// Deal with network error. If it was caused by the context expiring
+	// there's no point in trying again
 	if r.IsType(redis.IOErr) {
+		if ctx.Err() != nil {
+			return r
+		}
 		// If this is the first time trying this node, try it again
 		if !haveTriedBefore {
-			if client, try2err := c.getConn("", client.Addr); try2err == nil {
-				return c.clientCmd(client, cmd, args, false, tried, haveReset)
+			client, try2err := c.getConnContext(ctx, "", client.Addr)
+			if try2err == nil {
+				return c.clientCmd(ctx, client, cmd, args, false, tried, haveReset)
 			}
 		}
 		// Otherwise try calling Reset() and getting a random client
@@ -479,22 +548,22 @@ func (c *Cluster) clientCmd(
 			if resetErr := c.Reset(); resetErr != nil {
 				return errorRespf("Could not get cluster info: %s", resetErr)
 			}
-			client, getErr := c.getConn("", "")
+			client, getErr := c.getConnContext(ctx, "", "")
 			if getErr != nil {
 				return errorResp(getErr)
 			}
-			return c.clientCmd(client, cmd, args, false, tried, true)
+			return c.clientCmd(ctx, client, cmd, args, false, tried, true)
 		}
 		// Otherwise give up and return the most recent error
 		return r
 	}
 
-	// Here we deal with application errors that are either MOVED or ASK
-	msg := err.Error()
-	moved := strings.HasPrefix(msg, "MOVED ")
-	ask = strings.HasPrefix(msg, "ASK ")
-	if moved || ask {
-		_, addr := redirectInfo(msg)
+	// Here we deal with application errors that are either MOVED or ASK. A
+	// redirect which can't be parsed is returned like any other error
+	var rerr *redis.Error
+	if errors.As(err, &rerr) && rerr.IsRedirect() {
+		ask = rerr.Prefix == "ASK"
+		addr := rerr.Addr
 		c.callCh <- func(c *Cluster) {
 			select {
 			case c.MissCh <- struct{}{}:
@@ -519,11 +588,11 @@ func (c *Cluster) clientCmd(
 		// regardless of if it actually was or not
 		tried = justTried(tried, addr)
 
-		client, getErr := c.getConn("", addr)
+		client, getErr := c.getConnContext(ctx, "", addr)
 		if getErr != nil {
 			return errorResp(getErr)
 		}
-		return c.clientCmd(client, cmd, args, ask, tried, haveReset)
+		return c.clientCmd(ctx, client, cmd, args, ask, tried, haveReset)
 	}
 
 	// It's a normal application error (like WRONG KEY TYPE or whatever), return
@@ -531,18 +600,6 @@ func (c *Cluster) clientCmd(
 	return r
 }
 
-func redirectInfo(msg string) (int, string) {
-	parts := strings.Split(msg, " ")
-	slotStr := parts[1]
-	slot, err := strconv.Atoi(slotStr)
-	if err != nil {
-		// if redis is returning bad integers, we have problems
-		panic(err)
-	}
-	addr := parts[2]
-	return slot, addr
-}
-
 func keyToAddr(key string, mapping *mapping) string {
 	return mapping[Slot(key)]
 }
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"strings"
@@ -121,7 +122,7 @@ func TestCmdMiss(t *T) {
 	assert.Nil(t, err)
 
 	args := []interface{}{key}
-	r := cluster.clientCmd(client, "GET", args, false, nil, false)
+	r := cluster.clientCmd(context.Background(), client, "GET", args, false, nil, false)
 	s, err := r.Str()
 	assert.Nil(t, err)
 	assert.Equal(t, "baz", s)
@@ -0,0 +1,214 @@
+package cluster_test
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/kevwan/radix.v2/cluster"
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/kevwan/radix.v2/redistest"
+	"github.com/kevwan/radix.v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests run against a redistest.Cluster rather than a real cluster, so
+// that its topology can be changed under the client. They're in their own
+// package since redistest imports this one.
+
+func fakeCluster(t *T, n int) (*redistest.Cluster, *cluster.Cluster) {
+	fc, err := redistest.StartCluster(n)
+	require.Nil(t, err)
+	t.Cleanup(func() { fc.Close() })
+
+	// Reset isn't throttled, so that it always sees changes the tests make to
+	// the cluster straight away
+	c, err := cluster.NewWithOpts(cluster.Opts{
+		Addr:          fc.Addr(),
+		ResetThrottle: -1,
+	})
+	require.Nil(t, err)
+	t.Cleanup(c.Close)
+	return fc, c
+}
+
+// keyForSlot returns a key which hashes to the given slot
+func keyForSlot(slot uint16) string {
+	for i := 0; ; i++ {
+		if k := "key" + strconv.Itoa(i); cluster.Slot(k) == slot {
+			return k
+		}
+	}
+}
+
+func TestFakeCmd(t *T) {
+	fc, c := fakeCluster(t, 3)
+	for _, slot := range []uint16{0, 6000, cluster.NumSlots - 1} {
+		k := keyForSlot(slot)
+		assert.Equal(t, fc.AddrForSlot(slot), c.GetAddrForKey(k))
+		require.Nil(t, c.Cmd("SET", k, k).Err)
+		v, err := c.Cmd("GET", k).Str()
+		require.Nil(t, err)
+		assert.Equal(t, k, v)
+	}
+	assert.Len(t, c.GetEveryAvail(), 3)
+}
+
+func TestFakeMoved(t *T) {
+	fc, c := fakeCluster(t, 2)
+	addrs := fc.Addrs()
+	k := keyForSlot(0)
+	require.Nil(t, c.Cmd("SET", k, "foo").Err)
+
+	missCh := make(chan struct{})
+	go func() {
+		<-c.MissCh
+		close(missCh)
+	}()
+
+	require.Nil(t, fc.MoveSlots(0, 0, addrs[1]))
+	v, err := c.Cmd("GET", k).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", v)
+	assert.Equal(t, addrs[1], c.GetAddrForKey(k))
+
+	select {
+	case <-missCh:
+	case <-time.After(time.Second):
+		t.Fatal("no miss was reported")
+	}
+}
+
+func TestFakeAsk(t *T) {
+	fc, c := fakeCluster(t, 2)
+	addrs := fc.Addrs()
+	k := keyForSlot(0)
+	require.Nil(t, c.Cmd("SET", k, "foo").Err)
+
+	require.Nil(t, fc.StartMigration(0, addrs[1]))
+	require.Nil(t, fc.MigrateKey(k))
+	v, err := c.Cmd("GET", k).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", v)
+
+	// the slot hasn't moved yet, so the client shouldn't think it has
+	assert.Equal(t, addrs[0], c.GetAddrForKey(k))
+}
+
+func TestFakeNodeDown(t *T) {
+	fc, c := fakeCluster(t, 2)
+	addrs := fc.Addrs()
+	k := keyForSlot(cluster.NumSlots - 1)
+	require.Nil(t, c.Cmd("SET", k, "foo").Err)
+	assert.Equal(t, addrs[1], c.GetAddrForKey(k))
+
+	// the client will get an IOErr from the dead node, and must call Reset
+	// to find out where its slots have gone
+	require.Nil(t, fc.KillNode(addrs[1]))
+	require.Nil(t, fc.MoveSlots(0, cluster.NumSlots-1, addrs[0]))
+	v, err := c.Cmd("GET", k).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", v)
+	assert.Equal(t, addrs[0], c.GetAddrForKey(k))
+	assert.Len(t, c.GetEveryAvail(), 1)
+}
+
+func TestFakeResetDeadNodes(t *T) {
+	fc, c := fakeCluster(t, 3)
+	addrs := fc.Addrs()
+	require.Nil(t, fc.KillNode(addrs[0]))
+	require.Nil(t, fc.KillNode(addrs[1]))
+
+	// Whichever node Reset picks first it moves on to the others, and finds
+	// the one which is still alive
+	for i := 0; i < 10; i++ {
+		require.Nil(t, c.Reset())
+	}
+
+	// Once every node is dead it gives up
+	require.Nil(t, fc.KillNode(addrs[2]))
+	assert.NotNil(t, c.Reset())
+}
+
+func TestFakeAddNode(t *T) {
+	fc, c := fakeCluster(t, 1)
+	k := keyForSlot(cluster.NumSlots - 1)
+	require.Nil(t, c.Cmd("SET", k, "foo").Err)
+
+	changeCh := make(chan struct{})
+	go func() {
+		<-c.ChangeCh
+		close(changeCh)
+	}()
+
+	f, err := fc.AddNode()
+	require.Nil(t, err)
+	require.Nil(t, fc.MoveSlots(cluster.NumSlots/2, cluster.NumSlots-1, f.Addr()))
+	v, err := c.Cmd("GET", k).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", v)
+	assert.Equal(t, f.Addr(), c.GetAddrForKey(k))
+	assert.Len(t, c.GetEveryAvail(), 2)
+
+	select {
+	case <-changeCh:
+	case <-time.After(time.Second):
+		t.Fatal("no change was reported")
+	}
+}
+
+func TestFakeMovedLoop(t *T) {
+	// Two nodes which both claim, in CLUSTER SLOTS, that the first owns every
+	// slot, but which redirect every other command to each other
+	var ls [2]net.Listener
+	for i := range ls {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.Nil(t, err)
+		ls[i] = l
+	}
+	host, portStr, _ := net.SplitHostPort(ls[0].Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	var l sync.Mutex
+	gets := map[string]int{}
+	for i := range ls {
+		addr, other := ls[i].Addr().String(), ls[1-i].Addr().String()
+		s := &server.Server{Handler: server.HandlerFunc(func(w *redis.RespWriter, r *server.Request) {
+			if r.Cmd == "CLUSTER" {
+				w.WriteValue([]interface{}{
+					[]interface{}{0, cluster.NumSlots - 1, []interface{}{host, port}},
+				})
+				return
+			}
+			l.Lock()
+			gets[addr]++
+			l.Unlock()
+			w.WriteError(errors.New("MOVED 0 " + other))
+		})}
+		go s.Serve(ls[i])
+		t.Cleanup(func() { s.Close() })
+	}
+
+	c, err := cluster.NewWithOpts(cluster.Opts{
+		Addr:          ls[0].Addr().String(),
+		ResetThrottle: -1,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	// Each node is tried once, following the redirects, before the client
+	// gives up rather than going round in circles
+	r := c.Cmd("GET", keyForSlot(0))
+	require.NotNil(t, r.Err)
+	assert.Contains(t, r.Err.Error(), "doesn't make sense")
+	l.Lock()
+	defer l.Unlock()
+	assert.Equal(t, map[string]int{
+		ls[0].Addr().String(): 1,
+		ls[1].Addr().String(): 1,
+	}, gets)
+}
@@ -57,19 +57,52 @@
 // Custom connections
 //
 // Sometimes it's necessary to run some code on each connection in a pool upon
-// its creation, for example in the case of AUTH. This can be done with
-// NewCustom, like so
+// its creation, for example in the case of AUTH. The common cases (AUTH,
+// SELECT, CLIENT SETNAME and TLS) are covered by redis.DialOpts, which can be
+// used with NewCustom like so
+//
+//	df := redis.DialOpts{
+//		Timeout:    10*time.Second,
+//		Password:   "SUPERSECRET",
+//		DB:         2,
+//		ClientName: "my-service",
+//	}.DialFunc()
+//	p, err := pool.NewCustom("tcp", "127.0.0.1:6379", 10, 100, df)
+//
+// redis.DialOpts is also where limits on the size of replies are set, which
+// is worth doing when connecting to a server which isn't fully trusted. Pool
+// has no option of its own for them; a DialFunc like this one, passed to
+// NewCustom, is the way to give every connection in the pool the same limits:
+//
+//	df := redis.DialOpts{
+//		ReadLimits: redis.ReadLimits{
+//			MaxBulkLen:  64 << 20,
+//			MaxArrayLen: 1 << 20,
+//			MaxDepth:    16,
+//		},
+//	}.DialFunc()
+//	p, err := pool.NewCustom("tcp", "127.0.0.1:6379", 10, 100, df)
+//
+// Anything else can be done by writing the DialFunc by hand
 //
 //	df := func(network, addr string) (*redis.Client, error) {
 //		client, err := redis.Dial(network, addr)
 //		if err != nil {
 //			return nil, err
 //		}
-//		if err = client.Cmd("AUTH", "SUPERSECRET").Err; err != nil {
+//		if err = client.Cmd("CLIENT", "TRACKING", "ON").Err; err != nil {
 //			client.Close()
 //			return nil, err
 //		}
 //		return client, nil
 //	}
-//	p, err := pool.NewCustom("tcp", "127.0.0.1:6379", 10, df)
+//	p, err := pool.NewCustom("tcp", "127.0.0.1:6379", 10, 100, df)
+//
+// The same goes for connecting over TLS, either using the TLSConfig field on
+// redis.DialOpts or redis.DialTLS directly:
+//
+//	df := func(network, addr string) (*redis.Client, error) {
+//		return redis.DialTLS(network, addr, 10*time.Second, tlsConfig)
+//	}
+//	p, err := pool.NewCustom("tcp", "redis.example.com:6380", 10, 100, df)
 package pool
@@ -1,6 +1,7 @@
 package pool
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -129,35 +130,85 @@ func New(network, addr string, size, maxActive int) (*Pool, error) {
 // Get retrieves an available redis client. If there are none available it will
 // create a new one on the fly
 func (p *Pool) Get() (*redis.Client, error) {
-	select {
-	case conn := <-p.pool:
+	if conn, ok := p.getAvailable(); ok {
 		return conn, nil
-	default:
-		select {
-		case conn := <-p.secondaryPool:
-			p.secondaryActive.Store(time.Now())
-			return conn, nil
-		default:
-			for {
-				active := atomic.LoadInt32(&p.active)
-				if active < p.maxActive {
-					if atomic.CompareAndSwapInt32(&p.active, active, active+1) {
-						conn, err := p.df(p.Network, p.Addr)
-						if err != nil {
-							atomic.AddInt32(&p.active, -1)
-							return nil, err
-						}
-
-						return conn, nil
-					}
-				} else {
-					return nil, ErrPoolExhausted
+	}
+
+	for {
+		active := atomic.LoadInt32(&p.active)
+		if active < p.maxActive {
+			if atomic.CompareAndSwapInt32(&p.active, active, active+1) {
+				conn, err := p.df(p.Network, p.Addr)
+				if err != nil {
+					atomic.AddInt32(&p.active, -1)
+					return nil, err
 				}
+
+				return conn, nil
 			}
+		} else {
+			return nil, ErrPoolExhausted
 		}
 	}
 }
 
+// getAvailable returns a client from the pool, or from the secondary pool if
+// the pool is empty, without blocking or creating a new one
+func (p *Pool) getAvailable() (*redis.Client, bool) {
+	select {
+	case conn := <-p.pool:
+		return conn, true
+	default:
+	}
+
+	select {
+	case conn := <-p.secondaryPool:
+		p.secondaryActive.Store(time.Now())
+		return conn, true
+	default:
+		return nil, false
+	}
+}
+
+// GetContext is like Get, but will stop waiting on a new connection to be
+// created once the context is cancelled, returning the context's error. If the
+// connection is created anyway it will be put back in the pool.
+func (p *Pool) GetContext(ctx context.Context) (*redis.Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Get only blocks if it has to create a new connection, so if there's one
+	// available we can skip the go-routine
+	if conn, ok := p.getAvailable(); ok {
+		return conn, nil
+	} else if ctx.Done() == nil {
+		return p.Get()
+	}
+
+	type getRet struct {
+		conn *redis.Client
+		err  error
+	}
+	retCh := make(chan getRet, 1)
+	go func() {
+		conn, err := p.Get()
+		retCh <- getRet{conn, err}
+	}()
+
+	select {
+	case ret := <-retCh:
+		return ret.conn, ret.err
+	case <-ctx.Done():
+		go func() {
+			if ret := <-retCh; ret.err == nil {
+				p.Put(ret.conn)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
 // Put returns a client back to the pool. If the pool is full the client is
 // closed instead. If the client is already closed (due to connection failure or
 // what-have-you) it will not be put back in the pool
@@ -201,6 +252,33 @@ func (p *Pool) Cmd(cmd string, args ...interface{}) *redis.Resp {
 	return c.Cmd(cmd, args...)
 }
 
+// CmdContext is like Cmd, but the context is used both while retrieving a
+// client from the pool and while executing the command. See the CmdContext
+// method on redis.Client for more on how the context is used.
+func (p *Pool) CmdContext(ctx context.Context, cmd string, args ...interface{}) *redis.Resp {
+	c, err := p.GetContext(ctx)
+	if err != nil {
+		return redis.NewResp(err)
+	}
+	defer p.Put(c)
+
+	return c.CmdContext(ctx, cmd, args...)
+}
+
+// Transaction gets a connection from the pool and runs a transaction on it,
+// see the Transaction method on redis.Client for how it works. The connection
+// is put back in the pool afterwards, unless something went wrong which left
+// it in an unknown state, in which case it's closed instead.
+func (p *Pool) Transaction(keys []string, fn func(*redis.Tx) error) error {
+	c, err := p.Get()
+	if err != nil {
+		return err
+	}
+	defer p.Put(c)
+
+	return c.Transaction(keys, fn)
+}
+
 // Empty removes and calls Close() on all the connections currently in the pool.
 // Assuming there are no other connections waiting to be Put back this method
 // effectively closes and cleans up the pool.
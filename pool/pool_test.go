@@ -1,9 +1,15 @@
 package pool
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
 	. "testing"
+	"time"
 
+	"github.com/kevwan/radix.v2/redis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -73,3 +79,101 @@ func TestPut(t *T) {
 	// network error
 	assert.Equal(t, 9, len(pool.pool))
 }
+
+func TestCmdContext(t *T) {
+	pool, err := New("tcp", "localhost:6379", 10, 100)
+	require.Nil(t, err)
+	<-pool.initDoneCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, pool.CmdContext(ctx, "ECHO", "HI").Err)
+	assert.Equal(t, 10, len(pool.pool))
+
+	cancel()
+	r := pool.CmdContext(ctx, "ECHO", "HI")
+	assert.Equal(t, context.Canceled, r.Err)
+	assert.Equal(t, 10, len(pool.pool))
+}
+
+func TestGetContextAvailable(t *T) {
+	// Clients are never used to send anything, so they don't need a server
+	var dials int32
+	df := func(network, addr string) (*redis.Client, error) {
+		atomic.AddInt32(&dials, 1)
+		c, _ := net.Pipe()
+		return redis.NewClient(c), nil
+	}
+	pool, err := NewCustom("tcp", "", 1, 3, df)
+	require.Nil(t, err)
+	defer pool.Empty()
+	<-pool.initDoneCh
+
+	// Two more clients than the pool holds, which end up in the secondary
+	// pool when they're put back
+	var conns []*redis.Client
+	for i := 0; i < 3; i++ {
+		conn, err := pool.Get()
+		require.Nil(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&dials))
+	assert.Len(t, pool.secondaryPool, 2)
+
+	// GetContext takes from both pools before creating anything new
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		conn, err := pool.GetContext(ctx)
+		require.Nil(t, err)
+		assert.Contains(t, conns, conn)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&dials))
+	assert.Len(t, pool.secondaryPool, 0)
+}
+
+func TestTransaction(t *T) {
+	pool, err := New("tcp", "localhost:6379", 10, 100)
+	require.Nil(t, err)
+	<-pool.initDoneCh
+
+	key := fmt.Sprintf("pool-tx-%d", time.Now().UnixNano())
+	incr := func() error {
+		return pool.Transaction([]string{key}, func(tx *redis.Tx) error {
+			n, err := tx.Cmd("GET", key).Int()
+			if err != nil && err != redis.ErrRespNil {
+				return err
+			}
+			tx.Queue("SET", key, n+1)
+			return nil
+		})
+	}
+
+	// Concurrent transactions on the same key must not lose any increments
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.Nil(t, incr())
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := pool.Cmd("GET", key).Int()
+	require.Nil(t, err)
+	assert.Equal(t, 40, n)
+	assert.Equal(t, 10, len(pool.pool))
+
+	// A panicking transaction doesn't put its connection back
+	assert.Panics(t, func() {
+		pool.Transaction([]string{key}, func(tx *redis.Tx) error {
+			panic("oh no")
+		})
+	})
+	assert.Equal(t, 9, len(pool.pool))
+}
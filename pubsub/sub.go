@@ -122,7 +122,8 @@ func (c *SubClient) parseResp(resp *redis.Resp) *SubResp {
 	var elems []*redis.Resp
 
 	switch {
-	case resp.IsType(redis.Array):
+	// On RESP3 connections subscription messages come in as Push messages
+	case resp.IsType(redis.Array | redis.Push):
 		elems, _ = resp.Array()
 		if len(elems) < 2 {
 			sr.Err = errors.New("resp is not formatted as a subscription resp")
@@ -0,0 +1,220 @@
+package redis
+
+import "sync"
+
+// Limits on how much memory a respArena may hold on to when it's put back in
+// the pool. Anything bigger than this was most likely for an unusually large
+// reply, and is left for the garbage collector
+const (
+	maxArenaBytes = 64 * 1024
+	maxArenaElems = 1024
+)
+
+// respArena holds all the memory needed for a single reply read by
+// ReadPooled. Rather than every bulk string and array in the reply getting an
+// allocation of its own they're all sliced out of a few larger chunks, which
+// are reused once the reply is released.
+//
+// Storing a []byte or []Resp in an interface{} (i.e. a Resp's val) also
+// requires an allocation, so for Resps in an arena val holds a pointer into
+// strs or lists instead, see bytesVal and arrayVal on Resp.
+//
+// All methods can be called on a nil *respArena, in which case they allocate
+// normally.
+type respArena struct {
+	// the Resp returned from ReadPooled, which is the only one which can
+	// release the arena
+	root  *Resp
+	buf   []byte
+	strs  [][]byte
+	elems []Resp
+	lists [][]Resp
+}
+
+var arenaPool = sync.Pool{
+	New: func() interface{} { return new(respArena) },
+}
+
+// bytes returns a byte slice of length n, whose contents the caller is expected
+// to fill in. Appending to it will not overwrite anything else in the arena
+func (a *respArena) bytes(n int) []byte {
+	if a == nil {
+		return make([]byte, n)
+	}
+	if cap(a.buf)-len(a.buf) < n {
+		// Rather than growing the current chunk, which would mean copying it
+		// and leaving everything already sliced out of it pointing at the old
+		// one, a new chunk is started. The old one is garbage collected along
+		// with whatever is using it.
+		a.buf = make([]byte, 0, chunkSize(cap(a.buf), n, 512))
+	}
+	l := len(a.buf)
+	a.buf = a.buf[:l+n]
+	return a.buf[l : l+n : l+n]
+}
+
+// bytesVal returns the value to be used as the val of a Resp holding b
+func (a *respArena) bytesVal(b []byte) interface{} {
+	if a == nil {
+		return b
+	}
+	if len(a.strs) == cap(a.strs) {
+		a.strs = make([][]byte, 0, chunkSize(cap(a.strs), 1, 16))
+	}
+	a.strs = append(a.strs, b)
+	return &a.strs[len(a.strs)-1]
+}
+
+// resps returns a slice of n Resps, which the caller is expected to fill in
+func (a *respArena) resps(n int) []Resp {
+	if a == nil {
+		return make([]Resp, n)
+	}
+	if cap(a.elems)-len(a.elems) < n {
+		a.elems = make([]Resp, 0, chunkSize(cap(a.elems), n, 16))
+	}
+	l := len(a.elems)
+	a.elems = a.elems[:l+n]
+	return a.elems[l : l+n : l+n]
+}
+
+// respsVal returns the value to be used as the val of a Resp holding l
+func (a *respArena) respsVal(l []Resp) interface{} {
+	if a == nil {
+		return l
+	}
+	if len(a.lists) == cap(a.lists) {
+		a.lists = make([][]Resp, 0, chunkSize(cap(a.lists), 1, 4))
+	}
+	a.lists = append(a.lists, l)
+	return &a.lists[len(a.lists)-1]
+}
+
+// chunkSize returns the capacity a new chunk should have, given the capacity
+// of the previous one and the size which is needed right now
+func chunkSize(prev, need, min int) int {
+	c := prev * 2
+	if c < min {
+		c = min
+	}
+	if c < need {
+		c = need
+	}
+	return c
+}
+
+// release clears out the arena and puts it back in the pool. Nothing
+// previously returned from it may be used afterwards
+func (a *respArena) release() {
+	a.root = nil
+	if cap(a.buf) > maxArenaBytes {
+		a.buf = nil
+	}
+	a.buf = a.buf[:0]
+
+	// zero out the slices of references, so what they point to can be garbage
+	// collected while the arena sits in the pool
+	for i := range a.strs {
+		a.strs[i] = nil
+	}
+	a.strs = a.strs[:0]
+	for i := range a.elems {
+		a.elems[i] = Resp{}
+	}
+	if cap(a.elems) > maxArenaElems {
+		a.elems = nil
+	}
+	a.elems = a.elems[:0]
+	for i := range a.lists {
+		a.lists[i] = nil
+	}
+	a.lists = a.lists[:0]
+
+	arenaPool.Put(a)
+}
+
+// ReadPooled is like Read, but the returned Resp, and everything in it, is
+// read into memory which is pooled and reused. This makes reading replies,
+// especially large Array replies, much cheaper, as it requires next to no
+// allocations.
+//
+// Release must be called on the returned Resp once it's no longer needed.
+// After that neither the Resp, nor anything retrieved from it which refers to
+// its memory (e.g. the slices returned by Bytes, ListBytes or Array) may be
+// used. Values which are copies (e.g. those returned by Str, List or Int) are
+// unaffected. Not calling Release is not an error, the memory is simply
+// garbage collected as usual.
+//
+// The whole reply, including every element of an Array, is still parsed
+// before ReadPooled returns. Parsing elements lazily, on first access,
+// wouldn't save much: finding where the reply ends means reading the header
+// of every element anyway, and with the arena the up front parsing no longer
+// costs an allocation per element.
+func (rr *RespReader) ReadPooled() *Resp {
+	if err := rr.ready(); err != nil {
+		return NewRespIOErr(err)
+	}
+	a := arenaPool.Get().(*respArena)
+	res, err := bufioReadResp(rr.r, a, rr.limits())
+	if err != nil {
+		a.release()
+		err = rr.readErr(err)
+		return &Resp{typ: IOErr, val: err, Err: err}
+	}
+	// The root is allocated separately rather than being part of the arena,
+	// so that a Resp which has already been released can't be mistaken for
+	// whichever reply the arena is being used for next
+	res.arena = a
+	a.root = &res
+	return a.root
+}
+
+// Release returns the memory used by a Resp returned from ReadPooled, or
+// given to the callback of Client.CmdFunc, to the pool it came from. It does
+// nothing for any other Resp, or for one which has already been released, so
+// it's always safe to call. See ReadPooled for what may no longer be used
+// afterwards.
+func (r *Resp) Release() {
+	a := r.arena
+	if a == nil {
+		return
+	}
+	r.arena = nil
+	if a.root == r {
+		a.release()
+	}
+}
+
+// bytesVal returns the value of the Resp as a byte slice, if it has one
+func (r *Resp) bytesVal() ([]byte, bool) {
+	switch v := r.val.(type) {
+	case []byte:
+		return v, true
+	case *[]byte:
+		return *v, true
+	}
+	return nil, false
+}
+
+// arrayVal returns the value of the Resp as a slice of Resps, if it has one
+func (r *Resp) arrayVal() ([]Resp, bool) {
+	switch v := r.val.(type) {
+	case []Resp:
+		return v, true
+	case *[]Resp:
+		return *v, true
+	}
+	return nil, false
+}
+
+// value returns the value of the Resp as it would be had it not been read
+// into an arena
+func (r *Resp) value() interface{} {
+	switch v := r.val.(type) {
+	case *[]byte:
+		return *v
+	case *[]Resp:
+		return *v
+	}
+	return r.val
+}
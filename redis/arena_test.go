@@ -0,0 +1,207 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPooled(t *T) {
+	tests := []string{
+		"+OK\r\n",
+		"-ERR bad\r\n",
+		":1024\r\n",
+		"$3\r\nfoo\r\n",
+		"$-1\r\n",
+		"*-1\r\n",
+		"*0\r\n",
+		"*3\r\n$3\r\nfoo\r\n:5\r\n*2\r\n+bar\r\n$0\r\n\r\n",
+		"%2\r\n+a\r\n:1\r\n+b\r\n~1\r\n=7\r\ntxt:baz\r\n",
+		"|1\r\n+ttl\r\n:3600\r\n$3\r\nfoo\r\n",
+	}
+
+	var all string
+	for _, test := range tests {
+		all += test
+	}
+	rr, prr := NewRespReader(bytes.NewBufferString(all)), NewRespReader(bytes.NewBufferString(all))
+	for _, test := range tests {
+		expect := rr.Read()
+		r := prr.ReadPooled()
+		assert.Equal(t, expect.String(), r.String(), "test: %q", test)
+		assert.Equal(t, expect.natural(), r.natural(), "test: %q", test)
+		if attrs := expect.Attributes(); attrs != nil {
+			assert.Equal(t, attrs.natural(), r.Attributes().natural())
+		} else {
+			assert.Nil(t, r.Attributes())
+		}
+
+		buf, pbuf := new(bytes.Buffer), new(bytes.Buffer)
+		_, err := expect.WriteTo(buf)
+		require.Nil(t, err)
+		_, err = r.WriteTo(pbuf)
+		require.Nil(t, err)
+		assert.Equal(t, buf.String(), pbuf.String())
+
+		r.Release()
+		// Releasing more than once is a noop
+		r.Release()
+	}
+
+	// Releasing a Resp which didn't come from ReadPooled is a noop
+	r := NewResp("foo")
+	r.Release()
+	s, err := r.Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+
+	// Values which are copied out of the Resp survive it being released
+	buf := bytes.NewBufferString("*2\r\n$3\r\nfoo\r\n$3\r\nbar\r\n*1\r\n$3\r\nbaz\r\n")
+	prr = NewRespReader(buf)
+	r = prr.ReadPooled()
+	l, err := r.List()
+	require.Nil(t, err)
+	r.Release()
+	r = prr.ReadPooled()
+	defer r.Release()
+	assert.Equal(t, []string{"foo", "bar"}, l)
+}
+
+func TestReleaseTwice(t *T) {
+	buf := bytes.NewBufferString("$3\r\nfoo\r\n$3\r\nbar\r\n")
+	rr := NewRespReader(buf)
+	r1 := rr.ReadPooled()
+	r1.Release()
+
+	// The arena is most likely reused for the next reply, which mustn't be
+	// released by releasing the first again
+	r2 := rr.ReadPooled()
+	r1.Release()
+	b, err := r2.Bytes()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", string(b))
+	assert.NotNil(t, r2.arena)
+	r2.Release()
+
+	// Copies of a Resp don't release it either
+	buf = bytes.NewBufferString("$3\r\nfoo\r\n")
+	r3 := NewRespReader(buf).ReadPooled()
+	r3Copy := *r3
+	r3Copy.Release()
+	assert.NotNil(t, r3.arena)
+	b, err = r3.Bytes()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", string(b))
+	r3.Release()
+}
+
+func TestReadLongLine(t *T) {
+	// longer than the bufio.Reader's buffer
+	long := strings.Repeat("a", 10000)
+	rr := NewRespReader(bytes.NewBufferString("+" + long + "\r\n-" + long + "\r\n"))
+	s, err := rr.Read().Str()
+	require.Nil(t, err)
+	assert.Equal(t, long, s)
+	assert.Equal(t, long, rr.Read().Err.Error())
+}
+
+func TestParseInt(t *T) {
+	for _, s := range []string{
+		"0", "1", "-1", "+5", "1234567890", "-9223372036854775808",
+		"9223372036854775807",
+	} {
+		expect, err := strconv.ParseInt(s, 10, 64)
+		require.Nil(t, err)
+		i, err := parseInt([]byte(s))
+		require.Nil(t, err, "s: %q", s)
+		assert.Equal(t, expect, i, "s: %q", s)
+	}
+	for _, s := range []string{"", "-", "a", "1a", "9223372036854775808"} {
+		_, err := parseInt([]byte(s))
+		assert.Equal(t, errParse, err, "s: %q", s)
+	}
+}
+
+func TestCmdFunc(t *T) {
+	addr := cmdServer(t, func(args []string) *Resp {
+		switch args[0] {
+		case "MGET":
+			return NewResp(args[1:])
+		case "ERR":
+			return NewResp(errors.New("ERR bad"))
+		}
+		return NewRespSimple("OK")
+	})
+	c, err := Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+
+	var l []string
+	err = c.CmdFunc(func(r *Resp) error {
+		var err error
+		l, err = r.List()
+		return err
+	}, "MGET", "a", "b", "c")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, l)
+
+	err = c.CmdFunc(func(r *Resp) error { return r.Err }, "ERR")
+	assert.Equal(t, "ERR bad", err.Error())
+	assert.Nil(t, c.LastCritical)
+
+	// encoding errors are passed to fn too
+	err = c.CmdFunc(func(r *Resp) error { return r.Err }, "SET", testArgMarshaler(""))
+	assert.Equal(t, "empty", err.Error())
+}
+
+// loopReader endlessly reads the same bytes, so a RespReader can be
+// benchmarked without the cost of creating it being included
+type loopReader struct {
+	b   []byte
+	off int
+}
+
+func (lr *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, lr.b[lr.off:])
+	lr.off = (lr.off + n) % len(lr.b)
+	return n, nil
+}
+
+func benchReply(n int) []byte {
+	l := make([]string, n)
+	for i := range l {
+		l[i] = strings.Repeat("v", 16)
+	}
+	buf := new(bytes.Buffer)
+	NewResp(l).WriteTo(buf)
+	return buf.Bytes()
+}
+
+func BenchmarkRead(b *B) {
+	rr := NewRespReader(&loopReader{b: benchReply(100)})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if r := rr.Read(); r.Err != nil {
+			b.Fatal(r.Err)
+		}
+	}
+}
+
+func BenchmarkReadPooled(b *B) {
+	rr := NewRespReader(&loopReader{b: benchReply(100)})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := rr.ReadPooled()
+		if r.Err != nil {
+			b.Fatal(r.Err)
+		}
+		r.Release()
+	}
+}
@@ -1,11 +1,13 @@
 package redis
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"reflect"
+	"strings"
 	"time"
 )
 
@@ -15,14 +17,16 @@ var ErrPipelineEmpty = errors.New("pipeline queue empty")
 
 // Client describes a Redis client.
 type Client struct {
-	conn         net.Conn
-	respReader   *RespReader
-	pending      []request
-	writeScratch []byte
-	writeBuf     *bytes.Buffer
+	conn       net.Conn
+	respReader *RespReader
+	pending    []request
+	writeBuf   *requestBuf
 
 	completed, completedHead []*Resp
 
+	// set for the duration of a CmdContext call, if the context has a deadline
+	ctxDeadline time.Time
+
 	// The network/address of the redis instance this client is connected to.
 	// These will be whatever strings were passed into the Dial function when
 	// creating this connection
@@ -35,6 +39,13 @@ type Client struct {
 	// passed in value.
 	ReadTimeout, WriteTimeout time.Duration
 
+	// If true the keys of any maps passed in as command arguments will be
+	// sorted before the map is flattened, so that the same arguments will
+	// always produce the same command. This makes commands reproducible, e.g.
+	// in logs and tests, at the cost of sorting the keys on every command. By
+	// default maps are flattened in whatever order go iterates over them.
+	SortMapArgs bool
+
 	// The most recent network error which occurred when either reading
 	// or writing. A critical network error is basically any non-application
 	// level error, e.g. a timeout, disconnect, etc... Close is automatically
@@ -61,20 +72,166 @@ func DialTimeout(network, addr string, timeout time.Duration) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newClient(conn, network, addr, timeout), nil
+}
+
+// DialTLS is like DialTimeout, but the connection is secured using TLS with
+// the given config, which may be nil to use the default configuration. If the
+// config doesn't have a ServerName set the host portion of addr is used, both
+// for verifying the server's certificate and for SNI. Client certificates can
+// be given using the Certificates field on the config.
+//
+// The timeout applies to both establishing the connection and the TLS
+// handshake, as well as being used as the read/write timeout afterwards.
+func DialTLS(
+	network, addr string, timeout time.Duration, config *tls.Config,
+) (
+	*Client, error,
+) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, network, addr, timeout), nil
+}
 
+// DialOpts are options which can be passed into DialWithOpts. Any which are
+// left as their zero value are not used
+type DialOpts struct {
+
+	// Used as the timeout for establishing the connection, and then as the
+	// read/write timeout when communicating with redis
+	Timeout time.Duration
+
+	// If set the connection will be secured using TLS with this config. See
+	// DialTLS
+	TLSConfig *tls.Config
+
+	// If Password is set AUTH will be called with it once connected. If
+	// Username is also set it will be passed to AUTH as well, for use with
+	// redis 6 ACLs
+	Username, Password string
+
+	// If set SELECT will be called with this database index once connected
+	DB int
+
+	// If set CLIENT SETNAME will be called with this name once connected
+	ClientName string
+
+	// Sets the SortMapArgs field on the Client
+	SortMapArgs bool
+
+	// Limits on the size of the replies which will be read, see
+	// SetReadLimits
+	ReadLimits ReadLimits
+
+	// If set HELLO 3 will be called once connected, switching the connection
+	// to RESP3 (see Hello). AUTH and SETNAME are sent as part of the HELLO
+	// command in this case
+	Resp3 bool
+}
+
+// DialWithOpts connects to the given Redis server using the given options.
+// Once connected the connection is initialized according to the options, with
+// AUTH being called first, then SELECT, then CLIENT SETNAME. If any of those
+// return an error the connection is closed and that error is returned.
+func DialWithOpts(network, addr string, o DialOpts) (*Client, error) {
+	var c *Client
+	var err error
+	if o.TLSConfig != nil {
+		c, err = DialTLS(network, addr, o.Timeout, o.TLSConfig)
+	} else {
+		c, err = DialTimeout(network, addr, o.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.SortMapArgs = o.SortMapArgs
+	c.SetReadLimits(o.ReadLimits)
+	if err = o.init(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialFunc returns a function which will call DialWithOpts with these options.
+// The returned function can be used anywhere a dial function is needed, e.g.
+// pool.NewCustom, cluster.Opts or sentinel.NewClientCustom
+func (o DialOpts) DialFunc() func(network, addr string) (*Client, error) {
+	return func(network, addr string) (*Client, error) {
+		return DialWithOpts(network, addr, o)
+	}
+}
+
+func (o DialOpts) init(c *Client) error {
+	if o.Resp3 {
+		args := []interface{}{3}
+		if o.Password != "" {
+			username := o.Username
+			if username == "" {
+				username = "default"
+			}
+			args = append(args, "AUTH", username, o.Password)
+		}
+		if o.ClientName != "" {
+			args = append(args, "SETNAME", o.ClientName)
+		}
+		if err := c.Cmd("HELLO", args...).Err; err != nil {
+			return err
+		}
+	} else if o.Password != "" {
+		args := []interface{}{o.Password}
+		if o.Username != "" {
+			args = []interface{}{o.Username, o.Password}
+		}
+		if err := c.Cmd("AUTH", args...).Err; err != nil {
+			return err
+		}
+	}
+
+	if o.DB != 0 {
+		if err := c.Cmd("SELECT", o.DB).Err; err != nil {
+			return err
+		}
+	}
+
+	if o.ClientName != "" && !o.Resp3 {
+		if err := c.Cmd("CLIENT", "SETNAME", o.ClientName).Err; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewClient returns a Client which will communicate over the given, already
+// established, connection. This can be used to run redis over connections
+// which can't be created by Dial. Network and Addr are taken from the
+// connection's remote address, and no read/write timeouts are set.
+func NewClient(conn net.Conn) *Client {
+	var network, addr string
+	if raddr := conn.RemoteAddr(); raddr != nil {
+		network, addr = raddr.Network(), raddr.String()
+	}
+	return newClient(conn, network, addr, 0)
+}
+
+func newClient(
+	conn net.Conn, network, addr string, timeout time.Duration,
+) *Client {
 	completed := make([]*Resp, 0, 10)
 	return &Client{
 		conn:          conn,
 		respReader:    NewRespReader(conn),
 		ReadTimeout:   timeout,
 		WriteTimeout:  timeout,
-		writeScratch:  make([]byte, 0, 128),
-		writeBuf:      bytes.NewBuffer(make([]byte, 0, 128)),
+		writeBuf:      newRequestBuf(),
 		completed:     completed,
 		completedHead: completed,
 		Network:       network,
 		Addr:          addr,
-	}, nil
+	}
 }
 
 // Dial connects to the given Redis server.
@@ -82,6 +239,13 @@ func Dial(network, addr string) (*Client, error) {
 	return DialTimeout(network, addr, time.Duration(0))
 }
 
+// SetReadLimits sets limits on the size of the replies the Client will read.
+// A reply which exceeds them results in an IOErr wrapping ErrReadLimit, and
+// the connection being closed.
+func (c *Client) SetReadLimits(l ReadLimits) {
+	c.respReader.SetLimits(l)
+}
+
 // Close closes the connection.
 func (c *Client) Close() error {
 	return c.conn.Close()
@@ -89,11 +253,177 @@ func (c *Client) Close() error {
 
 // Cmd calls the given Redis command.
 func (c *Client) Cmd(cmd string, args ...interface{}) *Resp {
-	err := c.writeRequest(request{cmd, args})
-	if err != nil {
+	if r := c.writeRequest(request{cmd, args}); r != nil {
+		return r
+	}
+	return c.readReply(cmd)
+}
+
+// CmdContext is like Cmd, but the command is bound by the given context. If
+// the context has a deadline which is earlier than the ReadTimeout/WriteTimeout
+// of the Client it will be used as the deadline on the connection instead. If
+// the context is cancelled while the command is in progress the command is
+// aborted and the connection is closed, as it would be for any other critical
+// network error. In both cases the returned IOErr's Err will be the context's
+// error.
+func (c *Client) CmdContext(ctx context.Context, cmd string, args ...interface{}) *Resp {
+	if err := ctx.Err(); err != nil {
 		return NewRespIOErr(err)
 	}
-	return c.readResp(true)
+
+	c.ctxDeadline, _ = ctx.Deadline()
+	stop := c.watchContext(ctx)
+	r := c.Cmd(cmd, args...)
+	aborted := stop()
+	if !c.ctxDeadline.IsZero() || aborted {
+		c.ctxDeadline = time.Time{}
+		if !r.IsType(IOErr) {
+			// clear out the deadline set by the context, subsequent commands
+			// will set their own if they need one
+			c.conn.SetDeadline(time.Time{})
+		}
+	}
+
+	if r.IsType(IOErr) {
+		if err := ctx.Err(); err != nil {
+			return NewRespIOErr(err)
+		} else if d, ok := ctx.Deadline(); ok && IsTimeout(r) && !time.Now().Before(d) {
+			// the connection's deadline, which was set to the context's, can
+			// be hit slightly before the context itself notices it's expired
+			return NewRespIOErr(context.DeadlineExceeded)
+		}
+	}
+	return r
+}
+
+// aLongTimeAgo is a non-zero time, far in the past, used to immediately
+// interrupt any blocking reads or writes on a connection
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watchContext spawns a go-routine which will interrupt any reads/writes on
+// the connection if the context is cancelled. The returned function must be
+// called once the command is done, it returns whether or not the connection
+// was interrupted.
+func (c *Client) watchContext(ctx context.Context) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+
+	doneCh := make(chan struct{})
+	abortedCh := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(aLongTimeAgo)
+			abortedCh <- true
+		case <-doneCh:
+			abortedCh <- false
+		}
+	}()
+	return func() bool {
+		close(doneCh)
+		return <-abortedCh
+	}
+}
+
+// deadline returns the deadline which should be used for a read/write with the
+// given timeout, taking into account the deadline of the context given to
+// CmdContext, if any
+func (c *Client) deadline(timeout time.Duration) time.Time {
+	var d time.Time
+	if timeout != 0 {
+		d = time.Now().Add(timeout)
+	}
+	if !c.ctxDeadline.IsZero() && (d.IsZero() || c.ctxDeadline.Before(d)) {
+		d = c.ctxDeadline
+	}
+	return d
+}
+
+// CmdFunc calls the given Redis command and passes its reply to fn, returning
+// whatever fn returns. fn is called exactly once, even if the command fails,
+// in which case the reply will have Err set as it would for Cmd.
+//
+// The reply is read into pooled memory, as with RespReader's ReadPooled, and is
+// released once fn returns. This makes CmdFunc much cheaper than Cmd for
+// commands with large Array replies (e.g. MGET or LRANGE), but means fn must
+// not hold on to the reply, or anything retrieved from it which refers to its
+// memory (e.g. the slices returned by Bytes, ListBytes or Array), once it's
+// done:
+//
+//	var total int
+//	err := client.CmdFunc(func(r *redis.Resp) error {
+//		l, err := r.ListBytes()
+//		if err != nil {
+//			return err
+//		}
+//		for _, b := range l {
+//			total += len(b)
+//		}
+//		return nil
+//	}, "MGET", keys)
+func (c *Client) CmdFunc(fn func(*Resp) error, cmd string, args ...interface{}) error {
+	if r := c.writeRequest(request{cmd, args}); r != nil {
+		return fn(r)
+	}
+	c.setReadDeadline()
+	r := c.checkResp(c.respReader.ReadPooled(), true)
+	for isOutOfBand(cmd, r) {
+		r.Release()
+		c.setReadDeadline()
+		r = c.checkResp(c.respReader.ReadPooled(), true)
+	}
+	defer r.Release()
+	return fn(r)
+}
+
+// CmdStream calls the given Redis command, and returns its reply as a
+// BulkReader rather than reading it all into memory first. This is meant for
+// commands which return very large bulk strings, e.g. GET or DUMP on a large
+// key. Other string replies are returned as a BulkReader as well, but are read
+// in full first. If the command returns an error, Nil (ErrRespNil), or any
+// other kind of reply, an error is returned instead.
+//
+// ReadTimeout applies to every individual read on the BulkReader, rather than
+// to the reply as a whole. A failed read is a critical network error, as it
+// is for Cmd.
+//
+// The BulkReader must be read to the end or closed before the Client is used
+// again, or it will be discarded by the next command. In particular it must be
+// closed before the Client is returned to a Pool.
+func (c *Client) CmdStream(cmd string, args ...interface{}) (*BulkReader, error) {
+	if r := c.writeRequest(request{cmd, args}); r != nil {
+		return nil, r.Err
+	}
+
+	c.setReadDeadline()
+	br, r := c.respReader.readStream()
+	for r != nil && isOutOfBand(cmd, r) {
+		c.setReadDeadline()
+		br, r = c.respReader.readStream()
+	}
+	if r != nil {
+		if r.IsType(IOErr) {
+			c.LastCritical = r.Err
+			c.Close()
+		}
+		return bulkReaderFromResp(r)
+	}
+	br.beforeRead = c.setReadDeadline
+	br.onErr = func(err error) {
+		c.LastCritical = err
+		c.Close()
+	}
+	return br, nil
+}
+
+// Hello sends a HELLO command to the server, switching the connection to the
+// given protocol version. Passing 3 opts this connection into RESP3, after
+// which replies may be of any of the RESP3 types (e.g. Map, Double, Push).
+// Any further arguments (e.g. AUTH or SETNAME) are passed through to the
+// command as-is. The reply, a Map describing the server, is returned.
+func (c *Client) Hello(protover int, args ...interface{}) *Resp {
+	return c.Cmd("HELLO", append([]interface{}{protover}, args...)...)
 }
 
 // PipeAppend adds the given call to the pipeline queue.
@@ -103,7 +433,10 @@ func (c *Client) PipeAppend(cmd string, args ...interface{}) {
 }
 
 // PipeResp returns the reply for the next request in the pipeline queue. Err
-// with ErrPipelineEmpty is returned if the pipeline queue is empty.
+// with ErrPipelineEmpty is returned if the pipeline queue is empty. If the
+// arguments of any of the queued commands can't be encoded (see ArgMarshaler)
+// none of the commands are sent, they are all dropped and the encoding error
+// is returned.
 func (c *Client) PipeResp() *Resp {
 	if len(c.completed) > 0 {
 		r := c.completed[0]
@@ -115,15 +448,15 @@ func (c *Client) PipeResp() *Resp {
 		return NewResp(ErrPipelineEmpty)
 	}
 
-	nreqs := len(c.pending)
-	err := c.writeRequest(c.pending...)
+	reqs := c.pending
+	r := c.writeRequest(reqs...)
 	c.pending = nil
-	if err != nil {
-		return NewRespIOErr(err)
+	if r != nil {
+		return r
 	}
 	c.completed = c.completedHead
-	for i := 0; i < nreqs; i++ {
-		r := c.readResp(true)
+	for i := range reqs {
+		r := c.readReply(reqs[i].cmd)
 		c.completed = append(c.completed, r)
 	}
 
@@ -161,10 +494,58 @@ func (c *Client) ReadResp() *Resp {
 // strict indicates whether or not to consider timeouts as critical network
 // errors
 func (c *Client) readResp(strict bool) *Resp {
-	if c.ReadTimeout != 0 {
-		c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	c.setReadDeadline()
+	return c.checkResp(c.respReader.Read(), strict)
+}
+
+// pubSubKinds are the kinds of Push data which make up pub/sub on a RESP3
+// connection. The (un)subscribe ones are also the names of the commands whose
+// replies they are.
+var pubSubKinds = map[string]bool{
+	"message":      true,
+	"pmessage":     true,
+	"smessage":     true,
+	"subscribe":    true,
+	"psubscribe":   true,
+	"ssubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"sunsubscribe": true,
+}
+
+// isOutOfBand returns whether r, read while waiting for the reply to cmd, is
+// out-of-band data rather than that reply. On a RESP3 connection the server
+// may send Push data (e.g. client side caching invalidations) at any point,
+// which no command is waiting for. The exception is the pub/sub Push data
+// read while (un)subscribing, which is the reply, or at least what the
+// pubsub package expects to be given.
+func isOutOfBand(cmd string, r *Resp) bool {
+	if !r.IsType(Push) {
+		return false
+	} else if !pubSubKinds[strings.ToLower(cmd)] {
+		return true
+	}
+	arr, err := r.Array()
+	if err != nil || len(arr) == 0 {
+		return true
 	}
-	r := c.respReader.Read()
+	kind, err := arr[0].Str()
+	return err != nil || !pubSubKinds[kind]
+}
+
+// readReply reads the reply to the given command, skipping any out-of-band
+// data read first (see isOutOfBand)
+func (c *Client) readReply(cmd string) *Resp {
+	for {
+		if r := c.readResp(true); !isOutOfBand(cmd, r) {
+			return r
+		}
+	}
+}
+
+// checkResp closes the connection if the given Resp, which was just read off
+// of it, is a critical network error
+func (c *Client) checkResp(r *Resp, strict bool) *Resp {
 	if r.IsType(IOErr) && (strict || !IsTimeout(r)) {
 		c.LastCritical = r.Err
 		c.Close()
@@ -172,40 +553,54 @@ func (c *Client) readResp(strict bool) *Resp {
 	return r
 }
 
-func (c *Client) writeRequest(requests ...request) error {
-	if c.WriteTimeout != 0 {
-		c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+func (c *Client) setWriteDeadline() {
+	if d := c.deadline(c.WriteTimeout); !d.IsZero() {
+		c.conn.SetWriteDeadline(d)
 	}
-	var err error
-outer:
-	for i := range requests {
-		c.writeBuf.Reset()
-		elems := flattenedLength(requests[i].args...) + 1
-		_, err = writeArrayHeader(c.writeBuf, c.writeScratch, int64(elems))
-		if err != nil {
-			break
-		}
+}
 
-		_, err = writeTo(c.writeBuf, c.writeScratch, requests[i].cmd, true, true)
-		if err != nil {
-			break
-		}
+// timeoutWriter writes to the Client's connection, setting the write deadline
+// before every write
+type timeoutWriter struct {
+	c *Client
+}
 
-		for _, arg := range requests[i].args {
-			_, err = writeTo(c.writeBuf, c.writeScratch, arg, true, true)
-			if err != nil {
-				break outer
-			}
-		}
+func (tw timeoutWriter) Write(b []byte) (int, error) {
+	tw.c.setWriteDeadline()
+	return tw.c.conn.Write(b)
+}
+
+func (c *Client) setReadDeadline() {
+	if d := c.deadline(c.ReadTimeout); !d.IsZero() {
+		c.conn.SetReadDeadline(d)
+	}
+}
 
-		if _, err = c.writeBuf.WriteTo(c.conn); err != nil {
-			break
+// writeRequest encodes all of the given requests and writes them to the
+// connection. If anything goes wrong a Resp describing the error is returned.
+// If the requests couldn't be encoded nothing is written and the error is an
+// AppErr, otherwise it is a critical IOErr.
+func (c *Client) writeRequest(requests ...request) *Resp {
+	c.writeBuf.reset()
+	for i := range requests {
+		if err := c.writeBuf.appendRequest(requests[i], c.SortMapArgs); err != nil {
+			return NewResp(err)
 		}
 	}
+
+	c.setWriteDeadline()
+	var err error
+	if c.writeBuf.hasStreams() {
+		// streams can take arbitrarily long to write, so WriteTimeout applies
+		// to each write rather than to the request as a whole
+		_, err = c.writeBuf.WriteTo(timeoutWriter{c})
+	} else {
+		_, err = c.writeBuf.WriteTo(c.conn)
+	}
 	if err != nil {
 		c.LastCritical = err
 		c.Close()
-		return err
+		return NewRespIOErr(err)
 	}
 	return nil
 }
@@ -230,7 +625,32 @@ func KeyFromArgs(args ...interface{}) (string, error) {
 		return argv, nil
 	case []byte:
 		return string(argv), nil
+	case *StreamArg:
+		// reading the key would consume the stream
+		return "", errBadCmdNoKey
+	case ArgMarshaler:
+		b, err := argv.MarshalRedisArg()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case ArgsMarshaler:
+		l, err := argv.MarshalRedisArgs()
+		if err != nil {
+			return "", err
+		}
+		return KeyFromArgs(l...)
 	default:
+		if _, ok := flattenableStruct(arg); ok {
+			// Structs are flattened into field name/values, so the key is the
+			// name of the first field
+			return KeyFromArgs(flatten(arg)...)
+		} else if parg, ok := derefPtr(arg); ok {
+			if parg == nil {
+				return "", nil
+			}
+			return KeyFromArgs(parg)
+		}
 		switch reflect.TypeOf(arg).Kind() {
 		case reflect.Slice:
 			argVal := reflect.ValueOf(arg)
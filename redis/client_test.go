@@ -1,8 +1,19 @@
 package redis
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
 	. "testing"
 	"time"
 
@@ -148,6 +159,363 @@ func TestPipelineClear(t *T) {
 	}
 }
 
+func TestHello(t *T) {
+	c := dial(t)
+	require.Nil(t, c.Hello(3).Err)
+
+	k := randStr()
+	require.Nil(t, c.Cmd("HSET", k, "foo", "bar").Err)
+	r := c.Cmd("HGETALL", k)
+	assert.Equal(t, Map, r.typ)
+	m, err := r.Map()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"foo": "bar"}, m)
+}
+
+// blackhole returns the address of a server which accepts connections and
+// reads everything sent to it, but never replies
+func blackhole(t *T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestCmdContext(t *T) {
+	c := dial(t)
+	echo := randStr()
+	v, err := c.CmdContext(context.Background(), "ECHO", echo).Str()
+	require.Nil(t, err)
+	assert.Equal(t, echo, v)
+
+	// A deadline which isn't hit shouldn't affect the connection afterwards
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	v, err = c.CmdContext(ctx, "ECHO", echo).Str()
+	cancel()
+	require.Nil(t, err)
+	assert.Equal(t, echo, v)
+	assert.Nil(t, c.Cmd("BLPOP", randStr(), 1).Err)
+	assert.Nil(t, c.LastCritical)
+}
+
+func TestCmdContextAbort(t *T) {
+	addr := blackhole(t)
+
+	// A context which is already cancelled shouldn't touch the connection
+	c, err := Dial("tcp", addr)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := c.CmdContext(ctx, "PING")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, context.Canceled, r.Err)
+	assert.Nil(t, c.LastCritical)
+
+	// Deadline
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r = c.CmdContext(ctx, "PING")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+	assert.NotNil(t, c.LastCritical)
+
+	// Cancellation
+	c, err = Dial("tcp", addr)
+	require.Nil(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	r = c.CmdContext(ctx, "PING")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, context.Canceled, r.Err)
+	assert.NotNil(t, c.LastCritical)
+}
+
+// selfSignedCert returns a tls.Certificate for localhost signed by itself,
+// along with a pool containing it which can be used to verify it
+func selfSignedCert(t *T, cn string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+		},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(cert)
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, certPool
+}
+
+func TestDialTLS(t *T) {
+	serverCert, serverPool := selfSignedCert(t, "server")
+	clientCert, clientPool := selfSignedCert(t, "client")
+
+	serverNameCh := make(chan string, 1)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			select {
+			case serverNameCh <- cs.ServerName:
+			default:
+			}
+			return nil
+		},
+	})
+	require.Nil(t, err)
+	defer l.Close()
+
+	// The server replies to every command with PONG
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rr := NewRespReader(conn)
+				for rr.Read().Err == nil {
+					conn.Write([]byte("+PONG\r\n"))
+				}
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	addr := "localhost:" + port
+
+	c, err := DialTLS("tcp", addr, 5*time.Second, &tls.Config{
+		RootCAs:      serverPool,
+		Certificates: []tls.Certificate{clientCert},
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, "localhost", <-serverNameCh)
+	v, err := c.Cmd("PING").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", v)
+
+	// Without a client certificate the server should reject us. Depending on
+	// the TLS version this might not be noticed until the first read
+	c, err = DialTLS("tcp", addr, 5*time.Second, &tls.Config{
+		RootCAs: serverPool,
+	})
+	if err == nil {
+		assert.NotNil(t, c.Cmd("PING").Err)
+	}
+
+	// The server's certificate won't verify without the right root CA
+	_, err = DialTLS("tcp", addr, 5*time.Second, nil)
+	assert.NotNil(t, err)
+}
+
+// cmdServer returns the address of a server which calls fn with every command
+// it receives, replying with whatever fn returns
+func cmdServer(t TB, fn func([]string) *Resp) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rr := NewRespReader(conn)
+				for {
+					args, err := rr.Read().List()
+					if err != nil {
+						return
+					}
+					fn(args).WriteTo(conn)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestCmdSkipsPush(t *T) {
+	// A RESP3 server which sends a client side caching invalidation before
+	// every reply. Replies are the command's name, except for SUBSCRIBE.
+	const invalidate = ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rr := NewRespReader(conn)
+		for {
+			args, err := rr.Read().List()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, invalidate)
+			if args[0] == "SUBSCRIBE" {
+				io.WriteString(conn, ">3\r\n$9\r\nsubscribe\r\n$3\r\nfoo\r\n:1\r\n")
+			} else {
+				NewResp(args[0]).WriteTo(conn)
+			}
+		}
+	}()
+
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(t, err)
+	defer c.Close()
+	assertReply := func(expected string, r *Resp) {
+		s, err := r.Str()
+		require.Nil(t, err)
+		assert.Equal(t, expected, s)
+	}
+
+	assertReply("PING", c.Cmd("PING"))
+	assertReply("ECHO", c.Cmd("ECHO", "foo"))
+
+	c.PipeAppend("GET", "foo")
+	c.PipeAppend("SET", "foo", "bar")
+	assertReply("GET", c.PipeResp())
+	assertReply("SET", c.PipeResp())
+
+	p := c.Pipeline()
+	get := p.Append("GET", "foo")
+	del := p.Append("DEL", "foo")
+	require.Nil(t, p.Exec())
+	assertReply("GET", get.Resp())
+	assertReply("DEL", del.Resp())
+
+	require.Nil(t, c.CmdFunc(func(r *Resp) error {
+		assertReply("PING", r)
+		return nil
+	}, "PING"))
+
+	br, err := c.CmdStream("GET", "foo")
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(br)
+	require.Nil(t, err)
+	assert.Equal(t, "GET", string(b))
+
+	// The reply to SUBSCRIBE is itself Push data, but the invalidation before
+	// it is still skipped
+	r := c.Cmd("SUBSCRIBE", "foo")
+	require.True(t, r.IsType(Push))
+	arr, err := r.Array()
+	require.Nil(t, err)
+	require.Len(t, arr, 3)
+	assertReply("subscribe", arr[0])
+	assertReply("foo", arr[1])
+}
+
+func TestDialWithOpts(t *T) {
+	cmdCh := make(chan []string, 10)
+	addr := cmdServer(t, func(args []string) *Resp {
+		cmdCh <- args
+		if args[0] == "AUTH" && args[len(args)-1] != "pass" {
+			return NewResp(errors.New("WRONGPASS invalid password"))
+		}
+		return NewRespSimple("OK")
+	})
+	assertCmds := func(expected ...[]string) {
+		for _, e := range expected {
+			assert.Equal(t, e, <-cmdCh)
+		}
+		assert.Len(t, cmdCh, 0)
+	}
+
+	// No options means no commands
+	c, err := DialWithOpts("tcp", addr, DialOpts{})
+	require.Nil(t, err)
+	require.Nil(t, c.Cmd("PING").Err)
+	assertCmds([]string{"PING"})
+
+	c, err = DialWithOpts("tcp", addr, DialOpts{
+		Timeout:    time.Second,
+		Username:   "user",
+		Password:   "pass",
+		DB:         2,
+		ClientName: "name",
+	})
+	require.Nil(t, err)
+	assert.Equal(t, time.Second, c.ReadTimeout)
+	assertCmds(
+		[]string{"AUTH", "user", "pass"},
+		[]string{"SELECT", "2"},
+		[]string{"CLIENT", "SETNAME", "name"},
+	)
+
+	df := DialOpts{Password: "pass", ClientName: "name", Resp3: true}.DialFunc()
+	_, err = df("tcp", addr)
+	require.Nil(t, err)
+	assertCmds(
+		[]string{"HELLO", "3", "AUTH", "default", "pass", "SETNAME", "name"},
+	)
+
+	// A failed AUTH should fail the whole dial
+	_, err = DialWithOpts("tcp", addr, DialOpts{Password: "wrong", DB: 2})
+	assert.NotNil(t, err)
+	assertCmds([]string{"AUTH", "wrong"})
+}
+
+func TestCmdArgMarshalerErr(t *T) {
+	addr := cmdServer(t, func(args []string) *Resp {
+		return NewResp(args)
+	})
+	c, err := Dial("tcp", addr)
+	require.Nil(t, err)
+
+	l, err := c.Cmd("ECHO", testArgsMarshaler{testArgMarshaler("foo"), 1}).List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"ECHO", "m:foo", "1"}, l)
+
+	// An encoding error should be returned without anything being sent, and
+	// should leave the connection usable
+	r := c.Cmd("ECHO", "foo", testArgMarshaler(""))
+	assert.Equal(t, AppErr, r.typ)
+	assert.Nil(t, c.LastCritical)
+
+	c.PipeAppend("ECHO", "foo")
+	c.PipeAppend("ECHO", testArgsMarshaler(nil))
+	r = c.PipeResp()
+	assert.Equal(t, AppErr, r.typ)
+	assert.Equal(t, ErrPipelineEmpty, c.PipeResp().Err)
+
+	l, err = c.Cmd("ECHO", "bar").List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"ECHO", "bar"}, l)
+}
+
 func TestLastCritical(t *T) {
 	c := dial(t)
 
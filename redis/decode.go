@@ -0,0 +1,377 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errUnmarshalPtr = errors.New("Unmarshal requires a non-nil pointer")
+
+var (
+	typeOfResp      = reflect.TypeOf(Resp{})
+	typeOfBigIntPtr = reflect.TypeOf((*big.Int)(nil))
+)
+
+// Unmarshal decodes the value of the Resp into dst, which must be a non-nil
+// pointer. If r.Err != nil that will be returned. Values are converted as
+// needed, so a Str reply can be decoded into an int field as long as it can
+// be parsed as one, and an Int reply can be decoded into a string.
+//
+// Arrays (and the other Aggregate types) are decoded into slices, Go arrays,
+// maps and structs. Maps and structs are filled from alternating key/values,
+// as are returned by commands like HGETALL or CONFIG GET. Struct fields are
+// matched by their name, or by the name given in their "redis" tag. Fields
+// with a tag of "-" are skipped, as are keys which don't match any field.
+// Nested arrays are decoded into nested types:
+//
+//	type Person struct {
+//		Name string `redis:"name"`
+//		Age  int    `redis:"age"`
+//	}
+//
+//	var p Person
+//	err := client.Cmd("HGETALL", "person:1").Unmarshal(&p)
+//
+// A Nil reply will set dst to its zero value. dst may also be an
+// interface{}, in which case it will be set to the natural Go type of the
+// reply (string, int64, []interface{}, etc...), or a Resp, in which case the
+// Resp is copied as-is.
+func (r *Resp) Unmarshal(dst interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errUnmarshalPtr
+	}
+	return r.decode(v.Elem())
+}
+
+func (r *Resp) unmarshalErr(v reflect.Value) error {
+	return fmt.Errorf("cannot unmarshal %s into %s", r, v.Type())
+}
+
+func (r *Resp) decode(v reflect.Value) error {
+	if r.Err != nil {
+		return r.Err
+	}
+
+	if v.Type() == typeOfResp {
+		v.Set(reflect.ValueOf(*r))
+		return nil
+	} else if r.IsType(Nil) {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	} else if v.Type() == typeOfBigIntPtr {
+		bi, err := r.BigInt()
+		if err != nil {
+			return r.unmarshalErr(v)
+		}
+		v.Set(reflect.ValueOf(bi))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return r.decode(v.Elem())
+
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return r.unmarshalErr(v)
+		}
+		v.Set(reflect.ValueOf(r.natural()))
+		return nil
+
+	case reflect.String:
+		s, err := r.scalarStr()
+		if err != nil {
+			return r.unmarshalErr(v)
+		}
+		v.SetString(s)
+		return nil
+
+	case reflect.Bool:
+		b, err := r.Bool()
+		if err != nil {
+			return r.unmarshalErr(v)
+		}
+		v.SetBool(b)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s, err := r.scalarStr()
+		if err != nil {
+			return r.unmarshalErr(v)
+		}
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		s, err := r.scalarStr()
+		if err != nil {
+			return r.unmarshalErr(v)
+		}
+		i, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		s, err := r.scalarStr()
+		if err != nil {
+			return r.unmarshalErr(v)
+		}
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+
+	case reflect.Slice:
+		// byte slices are strings, not arrays
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			s, err := r.scalarStr()
+			if err != nil {
+				return r.unmarshalErr(v)
+			}
+			v.SetBytes([]byte(s))
+			return nil
+		}
+		l, err := r.betterArray()
+		if err != nil {
+			return r.unmarshalErr(v)
+		}
+		sl := reflect.MakeSlice(v.Type(), len(l), len(l))
+		for i := range l {
+			if err := l[i].decode(sl.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(sl)
+		return nil
+
+	case reflect.Array:
+		l, err := r.betterArray()
+		if err != nil {
+			return r.unmarshalErr(v)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if i >= len(l) {
+				v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+				continue
+			}
+			if err := l[i].decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		l, err := r.pairs(v)
+		if err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(l)/2))
+		}
+		kt, vt := v.Type().Key(), v.Type().Elem()
+		for i := 0; i < len(l); i += 2 {
+			kv, vv := reflect.New(kt).Elem(), reflect.New(vt).Elem()
+			if err := l[i].decode(kv); err != nil {
+				return err
+			}
+			if err := l[i+1].decode(vv); err != nil {
+				return err
+			}
+			v.SetMapIndex(kv, vv)
+		}
+		return nil
+
+	case reflect.Struct:
+		l, err := r.pairs(v)
+		if err != nil {
+			return err
+		}
+		fields := structFields(v.Type())
+		for i := 0; i < len(l); i += 2 {
+			k, err := l[i].scalarStr()
+			if err != nil {
+				return err
+			}
+			f, ok := fields.byName[k]
+			if !ok {
+				continue
+			}
+			if err := l[i+1].decode(v.FieldByIndex(f.index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return r.unmarshalErr(v)
+}
+
+// pairs returns the elements of an Aggregate Resp, checking that they can be
+// read as alternating key/values
+func (r *Resp) pairs(v reflect.Value) ([]Resp, error) {
+	l, err := r.betterArray()
+	if err != nil {
+		return nil, r.unmarshalErr(v)
+	} else if len(l)%2 != 0 {
+		return nil, errors.New("reply has odd number of elements")
+	}
+	return l, nil
+}
+
+// natural returns the value of the Resp as whatever go type most naturally
+// represents it
+func (r *Resp) natural() interface{} {
+	switch r.typ {
+	case SimpleStr, BulkStr, Verbatim:
+		s, _ := r.Str()
+		return s
+	case Int, Double, Bool:
+		return r.val
+	case BigNum:
+		bi, _ := r.BigInt()
+		return bi
+	case AppErr, IOErr:
+		return r.Err
+	case Map:
+		l, _ := r.arrayVal()
+		m := make(map[string]interface{}, len(l)/2)
+		for i := 0; i < len(l); i += 2 {
+			k, err := l[i].scalarStr()
+			if err != nil {
+				break
+			}
+			m[k] = l[i+1].natural()
+		}
+		if len(m) == len(l)/2 {
+			return m
+		}
+		fallthrough
+	case Array, Set, Push:
+		l, _ := r.arrayVal()
+		il := make([]interface{}, len(l))
+		for i := range l {
+			il[i] = l[i].natural()
+		}
+		return il
+	}
+	return nil
+}
+
+// structField describes a single field of a struct which will be read from
+// or written to as a key/value pair
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// omit returns whether the given value of the field should be left out when
+// the struct is flattened
+func (f structField) omit(v reflect.Value) bool {
+	return f.omitEmpty && v.IsZero()
+}
+
+type structInfo struct {
+	fields []structField
+	byName map[string]structField
+}
+
+var structInfoCache = struct {
+	sync.RWMutex
+	m map[reflect.Type]*structInfo
+}{
+	m: map[reflect.Type]*structInfo{},
+}
+
+// structFields returns information about the fields on the given struct type
+// which can be decoded into/encoded from. Fields of embedded structs which
+// aren't given a name by a tag are treated as if they were fields on the
+// outer struct
+func structFields(t reflect.Type) *structInfo {
+	structInfoCache.RLock()
+	si, ok := structInfoCache.m[t]
+	structInfoCache.RUnlock()
+	if ok {
+		return si
+	}
+
+	si = &structInfo{byName: map[string]structField{}}
+	for _, f := range appendStructFields(nil, t, nil) {
+		// fields on the outer struct take precedence over those on embedded
+		// structs, which always come after them
+		if _, ok := si.byName[f.name]; ok {
+			continue
+		}
+		si.fields = append(si.fields, f)
+		si.byName[f.name] = f
+	}
+
+	structInfoCache.Lock()
+	structInfoCache.m[t] = si
+	structInfoCache.Unlock()
+	return si
+}
+
+func appendStructFields(
+	fields []structField, t reflect.Type, index []int,
+) []structField {
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("redis")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		var omitEmpty bool
+		for _, opt := range opts[1:] {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, f)
+			continue
+		} else if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{
+			name:      name,
+			index:     append(append([]int{}, index...), i),
+			omitEmpty: omitEmpty,
+		})
+	}
+
+	for _, f := range embedded {
+		fieldIndex := append(append([]int{}, index...), f.Index...)
+		fields = appendStructFields(fields, f.Type, fieldIndex)
+	}
+	return fields
+}
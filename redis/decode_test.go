@@ -0,0 +1,164 @@
+package redis
+
+import (
+	"math/big"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type decodeEmbedded struct {
+	Embedded string `redis:"embedded"`
+	Shadowed string `redis:"name"`
+}
+
+type decodeStruct struct {
+	decodeEmbedded
+	Name       string  `redis:"name"`
+	Age        int     `redis:"age"`
+	Score      float64 `redis:"score"`
+	Active     bool    `redis:"active"`
+	Raw        []byte
+	Ptr        *int    `redis:"ptr"`
+	Skipped    string  `redis:"-"`
+	Nested     []int64 `redis:"nested"`
+	unexported string
+}
+
+func TestUnmarshalScalars(t *T) {
+	var s string
+	require.Nil(t, pretendRead("$3\r\nfoo\r\n").Unmarshal(&s))
+	assert.Equal(t, "foo", s)
+	require.Nil(t, pretendRead(":10\r\n").Unmarshal(&s))
+	assert.Equal(t, "10", s)
+
+	var i int
+	require.Nil(t, pretendRead("$2\r\n10\r\n").Unmarshal(&i))
+	assert.Equal(t, 10, i)
+	assert.NotNil(t, pretendRead("$3\r\nfoo\r\n").Unmarshal(&i))
+
+	var i8 int8
+	assert.NotNil(t, pretendRead(":1000\r\n").Unmarshal(&i8))
+
+	var u uint64
+	require.Nil(t, pretendRead("$20\r\n18446744073709551615\r\n").Unmarshal(&u))
+	assert.Equal(t, uint64(18446744073709551615), u)
+
+	var f float64
+	require.Nil(t, pretendRead("$4\r\n1.25\r\n").Unmarshal(&f))
+	assert.Equal(t, 1.25, f)
+	require.Nil(t, pretendRead(",2.5\r\n").Unmarshal(&f))
+	assert.Equal(t, 2.5, f)
+
+	var b bool
+	require.Nil(t, pretendRead(":1\r\n").Unmarshal(&b))
+	assert.Equal(t, true, b)
+	require.Nil(t, pretendRead("#f\r\n").Unmarshal(&b))
+	assert.Equal(t, false, b)
+
+	var bs []byte
+	require.Nil(t, pretendRead("$3\r\nfoo\r\n").Unmarshal(&bs))
+	assert.Equal(t, []byte("foo"), bs)
+
+	var bi *big.Int
+	require.Nil(t, pretendRead("(12345678901234567890123\r\n").Unmarshal(&bi))
+	assert.Equal(t, "12345678901234567890123", bi.String())
+
+	var ptr *string
+	require.Nil(t, pretendRead("+foo\r\n").Unmarshal(&ptr))
+	require.NotNil(t, ptr)
+	assert.Equal(t, "foo", *ptr)
+	require.Nil(t, pretendRead("$-1\r\n").Unmarshal(&ptr))
+	assert.Nil(t, ptr)
+
+	// Errors are passed through
+	assert.Equal(t, "ERR foo", pretendRead("-ERR foo\r\n").Unmarshal(&s).Error())
+
+	// dst must be a non-nil pointer
+	assert.Equal(t, errUnmarshalPtr, pretendRead("+foo\r\n").Unmarshal(s))
+	assert.Equal(t, errUnmarshalPtr, pretendRead("+foo\r\n").Unmarshal(nil))
+}
+
+func TestUnmarshalAggregates(t *T) {
+	var l []int
+	require.Nil(t, pretendRead("*3\r\n:1\r\n$1\r\n2\r\n+3\r\n").Unmarshal(&l))
+	assert.Equal(t, []int{1, 2, 3}, l)
+
+	var a [2]string
+	require.Nil(t, pretendRead("*3\r\n+a\r\n+b\r\n+c\r\n").Unmarshal(&a))
+	assert.Equal(t, [2]string{"a", "b"}, a)
+
+	var ll [][]string
+	r := pretendRead("*2\r\n*2\r\n+a\r\n+b\r\n*1\r\n+c\r\n")
+	require.Nil(t, r.Unmarshal(&ll))
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, ll)
+
+	// Nil elements become zero values
+	var ls []string
+	require.Nil(t, pretendRead("*2\r\n+a\r\n$-1\r\n").Unmarshal(&ls))
+	assert.Equal(t, []string{"a", ""}, ls)
+
+	var m map[string]int
+	require.Nil(t, pretendRead("*4\r\n+a\r\n$1\r\n1\r\n+b\r\n:2\r\n").Unmarshal(&m))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, m)
+	m = nil
+	require.Nil(t, pretendRead("%1\r\n+a\r\n:1\r\n").Unmarshal(&m))
+	assert.Equal(t, map[string]int{"a": 1}, m)
+	assert.NotNil(t, pretendRead("*1\r\n+a\r\n").Unmarshal(&m))
+
+	var rl []Resp
+	require.Nil(t, pretendRead("*2\r\n+a\r\n:1\r\n").Unmarshal(&rl))
+	assert.Equal(t, SimpleStr, rl[0].typ)
+	assert.Equal(t, Int, rl[1].typ)
+
+	var i interface{}
+	require.Nil(t, pretendRead("*3\r\n+a\r\n:1\r\n*1\r\n$-1\r\n").Unmarshal(&i))
+	assert.Equal(t, []interface{}{"a", int64(1), []interface{}{nil}}, i)
+	require.Nil(t, pretendRead("%1\r\n+a\r\n,1.5\r\n").Unmarshal(&i))
+	assert.Equal(t, map[string]interface{}{"a": 1.5}, i)
+
+	var s string
+	assert.NotNil(t, pretendRead("*1\r\n+a\r\n").Unmarshal(&s))
+}
+
+func TestUnmarshalStruct(t *T) {
+	r := NewResp([]interface{}{
+		"name", "foo",
+		"age", 30,
+		"score", "1.5",
+		"active", "1",
+		"Raw", "raw",
+		"ptr", "5",
+		"Skipped", "nope",
+		"unexported", "nope",
+		"embedded", "emb",
+		"unknown", "ignored",
+		"nested", []int{1, 2},
+	})
+
+	var s decodeStruct
+	require.Nil(t, r.Unmarshal(&s))
+	five := 5
+	assert.Equal(t, decodeStruct{
+		decodeEmbedded: decodeEmbedded{Embedded: "emb"},
+		Name:           "foo",
+		Age:            30,
+		Score:          1.5,
+		Active:         true,
+		Raw:            []byte("raw"),
+		Ptr:            &five,
+		Nested:         []int64{1, 2},
+	}, s)
+
+	// A list of structs, each from its own flat array
+	var sl []*decodeStruct
+	r = pretendRead("*2\r\n*2\r\n+name\r\n+a\r\n*2\r\n+name\r\n+b\r\n")
+	require.Nil(t, r.Unmarshal(&sl))
+	require.Len(t, sl, 2)
+	assert.Equal(t, "a", sl[0].Name)
+	assert.Equal(t, "b", sl[1].Name)
+
+	assert.NotNil(t, NewResp([]string{"age", "foo"}).Unmarshal(&s))
+	assert.NotNil(t, NewResp([]string{"age"}).Unmarshal(&s))
+}
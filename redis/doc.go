@@ -1,9 +1,19 @@
 // Package redis is a simple client for connecting and interacting with a single
 // redis instance.
 //
-// THE FUNCTIONALITY PROVIDED IN THIS PACKAGE IS NOT THREAD-SAFE. To use a
-// single redis instance amongst multiple go-routines, check out the pool
-// subpackage (http://godoc.org/github.com/kevwan/radix.v2/pool)
+// THE FUNCTIONALITY PROVIDED IN THIS PACKAGE IS NOT THREAD-SAFE, with the
+// exception of MuxClient. To use a single redis instance amongst multiple
+// go-routines, check out the pool subpackage
+// (http://godoc.org/github.com/kevwan/radix.v2/pool), or MuxClient, which
+// multiplexes the commands of many go-routines over a few connections:
+//
+//	mc, err := redis.DialMux("tcp", "localhost:6379", redis.MuxOpts{})
+//	if err != nil {
+//		// handle err
+//	}
+//
+//	// safe to call from any number of go-routines
+//	foo, err := mc.Cmd("GET", "foo").Str()
 //
 // To import inside your package do:
 //
@@ -18,9 +28,36 @@
 //		// handle err
 //	}
 //
+// To connect to a redis server over TLS use DialTLS, which takes a tls.Config
+// for setting root CAs, client certificates, etc...
+//
+//	client, err := redis.DialTLS("tcp", "redis.example.com:6380", 10*time.Second, &tls.Config{
+//		Certificates: []tls.Certificate{clientCert},
+//	})
+//
 // Make sure to call Close on the client if you want to clean it up before the
 // end of the program.
 //
+// Once a Client hits a network error it's closed and can't be used again. For
+// long-lived single connections use DialReconnect instead, which reconnects
+// (redoing any setup done by its Dialer) on the next command after the
+// connection is lost:
+//
+//	rc, err := redis.DialReconnect("tcp", "localhost:6379", redis.ReconnectOpts{
+//		Dialer: redis.DialOpts{Password: "secret", DB: 2}.DialFunc(),
+//	})
+//	if err != nil {
+//		// handle err
+//	}
+//
+// A command which was in progress when the connection was lost gets an IOErr
+// whose Err is a *ConnLostError, since it may or may not have been run.
+//
+// By default there's no limit on the size of the replies which are read, so a
+// misbehaving server can make the client allocate as much memory as it likes.
+// When connecting to a server which isn't fully trusted set the ReadLimits
+// field on DialOpts, or call SetReadLimits on the Client.
+//
 // Cmd and Resp
 //
 // The Cmd method returns a Resp, which has methods for converting to various
@@ -41,6 +78,18 @@
 //		// handle err
 //	}
 //
+// Errors returned by redis are an *Error, which has the error's prefix (e.g.
+// WRONGTYPE) and, for MOVED and ASK errors, where to redirect to. They can be
+// checked for using errors.Is and the sentinel errors:
+//
+//	err = client.Cmd("LPUSH", "foo", "bar").Err
+//	if errors.Is(err, redis.ErrWrongType) {
+//		// foo isn't a list
+//	}
+//
+// The Err of an IOErr will be, or wrap, the underlying network error, so
+// errors.As can be used to get at the net.Error.
+//
 // Array Replies
 //
 // The elements to Array replies can be accessed as strings using List or
@@ -64,6 +113,16 @@
 //		fmt.Println(elemStr)
 //	}
 //
+// Replies can also be decoded straight into go types using Unmarshal, which
+// will fill in slices, maps and structs (using "redis" struct tags):
+//
+//	var user struct {
+//		Name  string `redis:"name"`
+//		Email string `redis:"email"`
+//		Age   int    `redis:"age"`
+//	}
+//	err := client.Cmd("HGETALL", "user:1").Unmarshal(&user)
+//
 // Pipelining
 //
 // Pipelining is when the client sends a bunch of commands to the server at
@@ -72,7 +131,9 @@
 // methods. PipeAppend will simply append the command to a buffer without
 // sending it, the first time PipeResp is called it will send all the commands
 // in the buffer and return the Resp for the first command that was sent.
-// Subsequent calls to PipeResp return Resps for subsequent commands:
+// All of the buffered commands are sent using a single write to the
+// connection. Subsequent calls to PipeResp return Resps for subsequent
+// commands:
 //
 //	client.PipeAppend("GET", "foo")
 //	client.PipeAppend("SET", "bar", "foo")
@@ -94,6 +155,92 @@
 //		// handle err
 //	}
 //
+// Alternatively a Pipeline can be used, which returns a handle for each
+// command appended to it so their replies don't need to be read in order:
+//
+//	p := client.Pipeline()
+//	get := p.Append("GET", "foo")
+//	del := p.Append("DEL", "baz")
+//	if err := p.Exec(); err != nil {
+//		// handle err, a *PipelineError describes which commands got replies
+//	}
+//
+//	foo, err := get.Resp().Str()
+//
+// Transactions
+//
+// Transaction runs a MULTI/EXEC transaction, WATCHing the given keys and
+// retrying it if any of them are changed before it could be run:
+//
+//	err := client.Transaction([]string{"counter"}, func(tx *redis.Tx) error {
+//		n, err := tx.Cmd("GET", "counter").Int()
+//		if err != nil {
+//			return err
+//		}
+//		tx.Queue("SET", "counter", n*2)
+//		return nil
+//	})
+//
+// Streaming
+//
+// Normally a bulk string reply is read into memory in full before Cmd returns.
+// For very large values CmdStream can be used instead, which returns a
+// BulkReader that reads the value directly off the connection:
+//
+//	br, err := client.CmdStream("GET", "big-blob")
+//	if err != nil {
+//		// handle err
+//	}
+//	defer br.Close()
+//
+//	if _, err := io.Copy(f, br); err != nil {
+//		// handle err
+//	}
+//
+// The client can be used again once the BulkReader has been read to the end or
+// closed.
+//
+// Large arguments can be streamed in the same way, by wrapping an io.Reader of
+// known length with ReaderArg:
+//
+//	err := client.Cmd("SET", "big-blob", redis.ReaderArg(f, size)).Err
+//
+// Pooled Replies
+//
+// Every string and array in a reply normally gets its own allocation, which
+// adds up for large Array replies. CmdFunc instead reads the reply into pooled
+// memory, which is reused once the given callback returns, making it close to
+// allocation free:
+//
+//	err := client.CmdFunc(func(r *redis.Resp) error {
+//		l, err := r.ListBytes()
+//		if err != nil {
+//			return err
+//		}
+//		// use l, but don't hold on to it
+//		return nil
+//	}, "LRANGE", "big-list", 0, -1)
+//
+// RespReader's ReadPooled and Resp's Release can be used to do the same when
+// reading replies directly.
+//
+// RESP3
+//
+// By default connections speak RESP2, the protocol supported by every version
+// of redis. A connection can be switched to RESP3 (redis 6 and up) by calling
+// Hello on it:
+//
+//	if err := client.Hello(3).Err; err != nil {
+//		// handle err
+//	}
+//
+// Once switched, replies may be of any of the RESP3 types. HGETALL, for
+// example, will return a Map rather than an Array. All methods which work on
+// Arrays (Array, List, ListBytes, Map) work on the RESP3 aggregate types as
+// well, and Float64, Bool and BigInt can be used to read Double, Bool and
+// BigNum replies. Any attributes sent alongside a reply can be retrieved using
+// Attributes.
+//
 // Flattening
 //
 // Radix will automatically flatten passed in maps and slices into the argument
@@ -113,4 +260,56 @@
 // Radix is not picky about the types inside or outside the maps/slices, if they
 // don't match a subset of primitive types it will fall back to reflection to
 // figure out what they are and encode them.
+//
+// Types can also define how they are encoded by implementing ArgMarshaler,
+// which encodes a value as a single argument, or ArgsMarshaler, which expands
+// a value into multiple arguments:
+//
+//	type Timestamp time.Time
+//
+//	func (ts Timestamp) MarshalRedisArg() ([]byte, error) {
+//		return time.Time(ts).MarshalText()
+//	}
+//
+//	client.Cmd("SET", "last-seen", Timestamp(time.Now()))
+//
+// Structs are flattened into alternating field names and values, using the
+// same "redis" tags as Unmarshal, so they can be passed straight to commands
+// like HSET/HMSET. Fields tagged with the omitempty option are left out if
+// they have their zero value:
+//
+//	type Person struct {
+//		Name  string `redis:"name"`
+//		Age   int    `redis:"age"`
+//		Email string `redis:"email,omitempty"`
+//	}
+//
+//	client.Cmd("HMSET", "person:1", Person{Name: "bob", Age: 28})
+//
+// Structs which implement fmt.Stringer, like time.Time, are still sent as a
+// single argument using their String method.
+//
+// Maps are flattened in the same way, but in whatever order go iterates over
+// them. Setting SortMapArgs on the Client (or in DialOpts) sorts their keys
+// first, so that the same arguments always produce the same command.
+//
+// Speaking the Protocol
+//
+// RespReader and RespWriter read and write the redis protocol on any
+// io.Reader/io.Writer, for writing servers and proxies. ReadRequest reads the
+// requests sent by clients, in either the multibulk or inline format:
+//
+//	rr, rw := redis.NewRespReader(conn), redis.NewRespWriter(conn)
+//	for {
+//		args, err := rr.ReadRequest()
+//		if err != nil {
+//			return
+//		}
+//		if strings.EqualFold(string(args[0]), "PING") {
+//			rw.WriteSimpleStr("PONG")
+//		} else {
+//			rw.WriteError(errors.New("ERR unknown command"))
+//		}
+//		rw.Flush()
+//	}
 package redis
@@ -0,0 +1,225 @@
+package redis
+
+import (
+	"io"
+	"net"
+	"strconv"
+)
+
+// byte slice arguments at least this long are written straight from the
+// caller's slice, rather than being copied into the requestBuf
+const minRefLen = 2048
+
+// requestBuf is what requests are encoded into before being written to a
+// connection. Everything is appended into a single buffer which is reused from
+// one request to the next, with the exception of large byte slices, which are
+// referenced in place, and StreamArgs, which are copied from their reader
+// when the requestBuf is written. The whole thing is written using a single
+// vectored write (see net.Buffers), so a pipeline of any number of requests
+// needs only one syscall.
+type requestBuf struct {
+	buf     []byte
+	segs    []bufSeg
+	vec     net.Buffers
+	out     net.Buffers
+	scratch []byte
+
+	// if set large byte slices are copied like any other, for when the caller
+	// may be done with them before the buffer is written
+	noRefs bool
+}
+
+// bufSeg marks a point in buf, after which either ref or stream is written
+type bufSeg struct {
+	end    int
+	ref    []byte
+	stream *StreamArg
+}
+
+func newRequestBuf() *requestBuf {
+	return &requestBuf{
+		buf:     make([]byte, 0, 128),
+		scratch: make([]byte, 0, 64),
+	}
+}
+
+func (rb *requestBuf) reset() {
+	rb.buf = rb.buf[:0]
+	for i := range rb.segs {
+		rb.segs[i] = bufSeg{}
+	}
+	rb.segs = rb.segs[:0]
+}
+
+// Write implements the io.Writer interface by appending to the buffer, so that
+// any argument without a fast path can be encoded using writeTo
+func (rb *requestBuf) Write(b []byte) (int, error) {
+	rb.buf = append(rb.buf, b...)
+	return len(b), nil
+}
+
+func (rb *requestBuf) writeStream(sa *StreamArg) (int64, error) {
+	rb.segs = append(rb.segs, bufSeg{end: len(rb.buf), stream: sa})
+	return sa.size, nil
+}
+
+// appendRequest encodes the given request onto the end of the buffer. If an
+// error is returned the buffer is left in an unknown state, and must be reset
+// before being used again
+func (rb *requestBuf) appendRequest(req request, sortMaps bool) error {
+	rb.buf = append(rb.buf, arrayPrefix...)
+	rb.buf = strconv.AppendInt(rb.buf, int64(flattenedLength(req.args...)+1), 10)
+	rb.buf = append(rb.buf, delim...)
+	rb.appendBulkString(req.cmd)
+
+	for _, arg := range req.args {
+		if err := rb.appendArg(arg, sortMaps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tryAppendRequest is like appendRequest, but if the request can't be encoded
+// the buffer is left as it was before
+func (rb *requestBuf) tryAppendRequest(req request, sortMaps bool) error {
+	l, segs := len(rb.buf), len(rb.segs)
+	err := rb.appendRequest(req, sortMaps)
+	if err != nil {
+		rb.buf = rb.buf[:l]
+		for i := segs; i < len(rb.segs); i++ {
+			rb.segs[i] = bufSeg{}
+		}
+		rb.segs = rb.segs[:segs]
+	}
+	return err
+}
+
+// appendArg encodes a single argument of a request, which may be flattened
+// into multiple bulk strings. The most common types are encoded directly,
+// anything else goes through writeTo
+func (rb *requestBuf) appendArg(arg interface{}, sortMaps bool) error {
+	switch at := arg.(type) {
+	case []byte:
+		rb.appendBulk(at)
+	case string:
+		rb.appendBulkString(at)
+	case int:
+		rb.appendBulkInt(int64(at))
+	case int64:
+		rb.appendBulkInt(at)
+	case []string:
+		for i := range at {
+			rb.appendBulkString(at[i])
+		}
+	case [][]byte:
+		for i := range at {
+			rb.appendBulk(at[i])
+		}
+	case []interface{}:
+		for i := range at {
+			if err := rb.appendArg(at[i], sortMaps); err != nil {
+				return err
+			}
+		}
+	default:
+		_, err := writeTo(rb, rb.scratch, arg, true, true, sortMaps)
+		return err
+	}
+	return nil
+}
+
+func (rb *requestBuf) appendBulkHeader(l int) {
+	rb.buf = append(rb.buf, bulkStrPrefix...)
+	rb.buf = strconv.AppendInt(rb.buf, int64(l), 10)
+	rb.buf = append(rb.buf, delim...)
+}
+
+func (rb *requestBuf) appendBulk(b []byte) {
+	rb.appendBulkHeader(len(b))
+	if len(b) >= minRefLen && !rb.noRefs {
+		rb.segs = append(rb.segs, bufSeg{end: len(rb.buf), ref: b})
+	} else {
+		rb.buf = append(rb.buf, b...)
+	}
+	rb.buf = append(rb.buf, delim...)
+}
+
+func (rb *requestBuf) appendBulkString(s string) {
+	rb.appendBulkHeader(len(s))
+	rb.buf = append(rb.buf, s...)
+	rb.buf = append(rb.buf, delim...)
+}
+
+func (rb *requestBuf) appendBulkInt(i int64) {
+	rb.scratch = strconv.AppendInt(rb.scratch[:0], i, 10)
+	rb.appendBulk(rb.scratch)
+}
+
+// hasStreams returns whether any StreamArgs have been encoded into the buffer
+func (rb *requestBuf) hasStreams() bool {
+	for i := range rb.segs {
+		if rb.segs[i].stream != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTo writes the encoded requests to w. If w supports vectored writes
+// (e.g. a *net.TCPConn) this is done in a single call. The returned error is
+// from either w or the reader of a StreamArg.
+func (rb *requestBuf) WriteTo(w io.Writer) (int64, error) {
+	if rb.hasStreams() {
+		return rb.writeStreamsTo(w)
+	} else if len(rb.segs) == 0 {
+		n, err := w.Write(rb.buf)
+		return int64(n), err
+	}
+
+	rb.vec = rb.vec[:0]
+	var prev int
+	for _, s := range rb.segs {
+		rb.vec = append(rb.vec, rb.buf[prev:s.end], s.ref)
+		prev = s.end
+	}
+	rb.vec = append(rb.vec, rb.buf[prev:])
+
+	// WriteTo consumes the net.Buffers it's called on, so it's called on a
+	// copy in order to keep rb.vec's backing array around for next time
+	rb.out = rb.vec
+	return rb.out.WriteTo(w)
+}
+
+// writeStreamsTo is like WriteTo, but writes each part of the buffer
+// separately, copying any StreamArgs in between
+func (rb *requestBuf) writeStreamsTo(w io.Writer) (int64, error) {
+	var total int64
+	var prev int
+	for _, s := range rb.segs {
+		n, err := w.Write(rb.buf[prev:s.end])
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		prev = s.end
+
+		if s.stream != nil {
+			nn, err := io.CopyN(w, s.stream.r, s.stream.size)
+			total += nn
+			if err == io.EOF {
+				return total, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return total, err
+			}
+		} else {
+			n, err := w.Write(s.ref)
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	n, err := w.Write(rb.buf[prev:])
+	return total + int64(n), err
+}
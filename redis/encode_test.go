@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeRequestSlow encodes the request the way it would be if everything went
+// through writeTo, to check the fast paths against
+func encodeRequestSlow(t *T, buf *bytes.Buffer, req request) {
+	_, err := writeArrayHeader(buf, nil, int64(flattenedLength(req.args...)+1))
+	require.Nil(t, err)
+	_, err = writeTo(buf, nil, req.cmd, true, true, true)
+	require.Nil(t, err)
+	for _, arg := range req.args {
+		_, err = writeTo(buf, nil, arg, true, true, true)
+		require.Nil(t, err)
+	}
+}
+
+func TestRequestBuf(t *T) {
+	big := bytes.Repeat([]byte("b"), minRefLen*2)
+	reqs := []request{
+		{"PING", nil},
+		{"SET", []interface{}{"foo", []byte("bar")}},
+		{"SET", []interface{}{"foo", big, "EX", 10}},
+		{"MSET", []interface{}{[]string{"a", "1", "b", "2"}}},
+		{"MSET", []interface{}{[][]byte{[]byte("a"), big}}},
+		{"RPUSH", []interface{}{"l", []interface{}{1, int64(-2), 3.5, true, nil, []interface{}{big, "x"}}}},
+		{"HMSET", []interface{}{"h", map[string]int{"b": 2, "a": 1}}},
+		{"SET", []interface{}{testArgMarshaler("foo"), NewResp("bar")}},
+	}
+
+	rb := newRequestBuf()
+	for i := 0; i < 2; i++ { // make sure the buffer is reusable
+		rb.reset()
+		expect := new(bytes.Buffer)
+		for _, req := range reqs {
+			require.Nil(t, rb.appendRequest(req, true))
+			encodeRequestSlow(t, expect, req)
+		}
+		buf := new(bytes.Buffer)
+		n, err := rb.WriteTo(buf)
+		require.Nil(t, err)
+		assert.Equal(t, int64(buf.Len()), n)
+		assert.Equal(t, expect.String(), buf.String())
+	}
+
+	// StreamArgs mixed in with everything else
+	rb.reset()
+	req := request{"SET", []interface{}{
+		"foo", big, ReaderArg(strings.NewReader("streamed"), 8), "bar",
+	}}
+	require.Nil(t, rb.appendRequest(req, false))
+	buf := new(bytes.Buffer)
+	_, err := rb.WriteTo(buf)
+	require.Nil(t, err)
+	req.args[2] = "streamed"
+	expect := new(bytes.Buffer)
+	encodeRequestSlow(t, expect, req)
+	assert.Equal(t, expect.String(), buf.String())
+}
+
+func TestRequestBufAllocs(t *T) {
+	rb := newRequestBuf()
+	small, big := []byte("bar"), bytes.Repeat([]byte("b"), minRefLen)
+	reqs := []request{
+		{"SET", []interface{}{"foo", small}},
+		{"SET", []interface{}{"foo", big}},
+		{"INCRBY", []interface{}{"foo", 10}},
+	}
+	allocs := AllocsPerRun(100, func() {
+		rb.reset()
+		for _, req := range reqs {
+			rb.appendRequest(req, false)
+		}
+		rb.WriteTo(ioutil.Discard)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestCmdLargeArgs(t *T) {
+	m := map[string]string{}
+	addr := cmdServer(t, func(args []string) *Resp {
+		if args[0] == "SET" {
+			m[args[1]] = args[2]
+			return NewRespSimple("OK")
+		}
+		return NewResp(m[args[1]])
+	})
+	c, err := Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+
+	vals := make([][]byte, 10)
+	for i := range vals {
+		vals[i] = bytes.Repeat([]byte{byte('a' + i)}, minRefLen*(i+1))
+		c.PipeAppend("SET", i, vals[i])
+	}
+	for i := range vals {
+		c.PipeAppend("GET", i)
+	}
+	for range vals {
+		require.Nil(t, c.PipeResp().Err)
+	}
+	for i := range vals {
+		b, err := c.PipeResp().Bytes()
+		require.Nil(t, err)
+		assert.Equal(t, vals[i], b)
+	}
+}
+
+func BenchmarkPipelineEncode(b *B) {
+	rb := newRequestBuf()
+	key, val := "foo", bytes.Repeat([]byte("v"), 64)
+	reqs := make([]request, 100)
+	for i := range reqs {
+		reqs[i] = request{"SET", []interface{}{key, val}}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.reset()
+		for _, req := range reqs {
+			rb.appendRequest(req, false)
+		}
+		rb.WriteTo(ioutil.Discard)
+	}
+}
@@ -0,0 +1,107 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Error is the type of the Err on AppErr Resps which were read off the
+// connection, i.e. errors returned by redis itself
+type Error struct {
+	// The full error message, e.g. "WRONGTYPE Operation against a key holding
+	// the wrong kind of value"
+	Msg string
+
+	// The first word of Msg, if it is all upper-case, e.g. "WRONGTYPE", "ERR",
+	// "MOVED". Empty if the message doesn't start with one.
+	Prefix string
+
+	// Set for MOVED and ASK errors, the slot and the address of the node the
+	// command should be sent to. Addr is empty if the error was of neither
+	// kind, or was malformed.
+	Slot int
+	Addr string
+}
+
+// newError parses the given error message returned by redis into an Error
+func newError(msg string) *Error {
+	e := &Error{Msg: msg}
+	word := msg
+	if i := strings.IndexByte(msg, ' '); i >= 0 {
+		word = msg[:i]
+	}
+	if isErrorPrefix(word) {
+		e.Prefix = word
+	}
+
+	if e.Prefix == "MOVED" || e.Prefix == "ASK" {
+		// MOVED <slot> <addr>
+		parts := strings.Split(msg, " ")
+		if len(parts) != 3 || parts[2] == "" {
+			return e
+		}
+		slot, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return e
+		}
+		e.Slot, e.Addr = slot, parts[2]
+	}
+	return e
+}
+
+func isErrorPrefix(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if (s[i] < 'A' || s[i] > 'Z') && s[i] != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Is returns whether the target is the sentinel error (e.g. ErrMoved) for
+// this Error's Prefix, for use with errors.Is:
+//
+//	if errors.Is(r.Err, redis.ErrWrongType) {
+//		// ...
+//	}
+func (e *Error) Is(target error) bool {
+	p, ok := target.(errorPrefix)
+	return ok && string(p) == e.Prefix
+}
+
+// IsRedirect returns whether this is a MOVED or ASK error with a valid slot
+// and address
+func (e *Error) IsRedirect() bool {
+	return e.Addr != ""
+}
+
+// errorPrefix is the type of the sentinel errors, an Error matches the one for
+// its Prefix
+type errorPrefix string
+
+func (p errorPrefix) Error() string {
+	return string(p)
+}
+
+// Sentinel errors which any Error with the corresponding Prefix matches when
+// using errors.Is. To get at the rest of the error's information use errors.As
+// with an *Error.
+var (
+	ErrWrongType   error = errorPrefix("WRONGTYPE")
+	ErrMoved       error = errorPrefix("MOVED")
+	ErrAsk         error = errorPrefix("ASK")
+	ErrNoScript    error = errorPrefix("NOSCRIPT")
+	ErrLoading     error = errorPrefix("LOADING")
+	ErrBusy        error = errorPrefix("BUSY")
+	ErrReadOnly    error = errorPrefix("READONLY")
+	ErrClusterDown error = errorPrefix("CLUSTERDOWN")
+	ErrTryAgain    error = errorPrefix("TRYAGAIN")
+	ErrNoAuth      error = errorPrefix("NOAUTH")
+)
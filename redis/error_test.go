@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *T) {
+	for _, test := range []struct {
+		in       string
+		expected Error
+		is       error
+	}{
+		{"ERR unknown command 'FOO'", Error{Prefix: "ERR"}, nil},
+		{"WRONGTYPE Operation against a key holding the wrong kind of value",
+			Error{Prefix: "WRONGTYPE"}, ErrWrongType},
+		{"MOVED 3999 127.0.0.1:6381",
+			Error{Prefix: "MOVED", Slot: 3999, Addr: "127.0.0.1:6381"}, ErrMoved},
+		{"ASK 3999 127.0.0.1:6381",
+			Error{Prefix: "ASK", Slot: 3999, Addr: "127.0.0.1:6381"}, ErrAsk},
+		{"NOSCRIPT No matching script.", Error{Prefix: "NOSCRIPT"}, ErrNoScript},
+		{"LOADING Redis is loading the dataset in memory",
+			Error{Prefix: "LOADING"}, ErrLoading},
+		{"BUSY Redis is busy running a script.", Error{Prefix: "BUSY"}, ErrBusy},
+		{"READONLY You can't write against a read only replica.",
+			Error{Prefix: "READONLY"}, ErrReadOnly},
+		{"CLUSTERDOWN The cluster is down", Error{Prefix: "CLUSTERDOWN"}, ErrClusterDown},
+		{"TRYAGAIN Multiple keys request during rehashing of slot",
+			Error{Prefix: "TRYAGAIN"}, ErrTryAgain},
+		{"NOAUTH Authentication required.", Error{Prefix: "NOAUTH"}, ErrNoAuth},
+		{"NOAUTH", Error{Prefix: "NOAUTH"}, ErrNoAuth},
+		{"not a prefix", Error{}, nil},
+		{"", Error{}, nil},
+
+		// malformed redirects aren't redirects
+		{"MOVED", Error{Prefix: "MOVED"}, ErrMoved},
+		{"MOVED foo 127.0.0.1:6381", Error{Prefix: "MOVED"}, ErrMoved},
+		{"ASK 3999", Error{Prefix: "ASK"}, ErrAsk},
+		{"ASK 3999 127.0.0.1:6381 extra", Error{Prefix: "ASK"}, ErrAsk},
+	} {
+		r := pretendRead("-" + test.in + "\r\n")
+		require.True(t, r.IsType(AppErr))
+		test.expected.Msg = test.in
+
+		var rerr *Error
+		require.True(t, errors.As(r.Err, &rerr), test.in)
+		assert.Equal(t, test.expected, *rerr, test.in)
+		assert.Equal(t, test.in, r.Err.Error())
+		assert.Equal(t, test.expected.Addr != "", rerr.IsRedirect(), test.in)
+
+		if test.is != nil {
+			assert.True(t, errors.Is(r.Err, test.is), test.in)
+		}
+		if test.is != ErrWrongType {
+			assert.False(t, errors.Is(r.Err, ErrWrongType), test.in)
+		}
+	}
+
+	// Errors created by the user aren't parsed
+	r := NewResp(errors.New("MOVED 3999 127.0.0.1:6381"))
+	assert.False(t, errors.Is(r.Err, ErrMoved))
+}
+
+func TestIOErrWrapsNetError(t *T) {
+	// A timeout wrapped in a ConnLostError is still a timeout
+	c, err := DialTimeout("tcp", blackhole(t), 10*time.Millisecond)
+	require.Nil(t, err)
+	r := c.Cmd("PING")
+	require.True(t, r.IsType(IOErr))
+	assert.True(t, IsTimeout(r))
+
+	var netErr net.Error
+	wrapped := NewRespIOErr(&ConnLostError{Err: r.Err})
+	assert.True(t, errors.As(wrapped.Err, &netErr))
+	assert.True(t, IsTimeout(wrapped))
+
+	wrapped = NewRespIOErr(&PipelineError{Err: r.Err})
+	assert.True(t, errors.As(wrapped.Err, &netErr))
+	assert.True(t, IsTimeout(wrapped))
+}
@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReadLimit is the error (wrapped with details of which limit) given when a
+// reply exceeds the ReadLimits of the RespReader reading it. Once a reply has
+// exceeded the limits the RespReader can't know where the next reply starts,
+// so every read after that returns the same error, and a Client will close
+// its connection as it would for any other critical network error. It's also
+// given, whatever the limits, for a length too large to be read at all.
+var ErrReadLimit = errors.New("reply exceeds read limit")
+
+// ReadLimits limit the size of the replies (or requests, see ReadRequest) a
+// RespReader will read, so that a misbehaving peer can't cause an unbounded
+// amount of memory to be allocated. Any left as zero are not limited, except
+// by ReadRequest, which uses DefaultRequestLimits for them.
+type ReadLimits struct {
+	// The maximum length of a bulk string, blob error or verbatim string
+	MaxBulkLen int64
+
+	// The maximum number of elements in an array, set or push reply. Maps
+	// count both their keys and their values.
+	MaxArrayLen int64
+
+	// The maximum nesting depth of aggregate replies (arrays, maps, etc...). A
+	// flat array has a depth of 1, an array of arrays a depth of 2.
+	MaxDepth int
+
+	// The maximum length of an inline command read by ReadRequest
+	MaxInlineLen int64
+}
+
+// SetLimits sets the limits which will be applied to every reply read from
+// now on. See ReadLimits.
+func (rr *RespReader) SetLimits(l ReadLimits) {
+	rr.lim = readLimits{ReadLimits: l}
+	rr.hasLim = l != ReadLimits{}
+}
+
+// limits returns the readLimits to pass to bufioReadResp for the next reply,
+// or nil if there are none
+func (rr *RespReader) limits() *readLimits {
+	if !rr.hasLim {
+		return nil
+	}
+	rr.lim.depth = 0
+	return &rr.lim
+}
+
+// ready is called before each reply is read, returning an error if it can't
+// be
+func (rr *RespReader) ready() error {
+	if rr.err != nil {
+		return rr.err
+	}
+	return rr.discardStream()
+}
+
+// readErr is called with any error returned while reading a reply. If it
+// leaves the RespReader unusable it's remembered so ready can return it.
+func (rr *RespReader) readErr(err error) error {
+	if errors.Is(err, ErrReadLimit) || errors.Is(err, ErrProtocol) {
+		rr.err = err
+	}
+	return err
+}
+
+// errTooLarge is returned for a length which couldn't be read even without
+// limits, because it doesn't fit in an int
+func errTooLarge(what string, size int64) error {
+	return fmt.Errorf("%w: %s length %d too large", ErrReadLimit, what, size)
+}
+
+// readLimits tracks a reply being read against a set of ReadLimits. All of its
+// methods may be called on a nil readLimits, which has no limits.
+type readLimits struct {
+	ReadLimits
+	depth int
+}
+
+func (l *readLimits) checkBulk(size int64) error {
+	if l != nil && l.MaxBulkLen > 0 && size > l.MaxBulkLen {
+		return fmt.Errorf(
+			"%w: bulk string length %d > %d", ErrReadLimit, size, l.MaxBulkLen,
+		)
+	}
+	return nil
+}
+
+func (l *readLimits) checkArray(size int64) error {
+	if l != nil && l.MaxArrayLen > 0 && size > l.MaxArrayLen {
+		return fmt.Errorf(
+			"%w: array length %d > %d", ErrReadLimit, size, l.MaxArrayLen,
+		)
+	}
+	return nil
+}
+
+// enter is called when starting to read the elements of an aggregate reply,
+// and leave when done
+func (l *readLimits) enter() error {
+	if l == nil {
+		return nil
+	}
+	l.depth++
+	if l.MaxDepth > 0 && l.depth > l.MaxDepth {
+		return fmt.Errorf(
+			"%w: nesting depth > %d", ErrReadLimit, l.MaxDepth,
+		)
+	}
+	return nil
+}
+
+func (l *readLimits) leave() {
+	if l != nil {
+		l.depth--
+	}
+}
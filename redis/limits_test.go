@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLimits(t *T) {
+	l := ReadLimits{MaxBulkLen: 5, MaxArrayLen: 4, MaxDepth: 2}
+	for _, test := range []struct {
+		in     string
+		exceed bool
+	}{
+		{"$5\r\nhello\r\n", false},
+		{"$6\r\nhello!\r\n", true},
+		{"$2147483647\r\n", true},
+		{"$-1\r\n", false},
+		{"!6\r\nERR no\r\n", true},
+		{"=9\r\ntxt:hello\r\n", true},
+		{"=5\r\ntxt:h\r\n", false},
+		{"*4\r\n:1\r\n:2\r\n:3\r\n:4\r\n", false},
+		{"*5\r\n:1\r\n:2\r\n:3\r\n:4\r\n:5\r\n", true},
+		{"*2147483647\r\n", true},
+		{"~5\r\n:1\r\n:2\r\n:3\r\n:4\r\n:5\r\n", true},
+		{">5\r\n:1\r\n:2\r\n:3\r\n:4\r\n:5\r\n", true},
+		{"%2\r\n:1\r\n:2\r\n:3\r\n:4\r\n", false},
+		{"%3\r\n:1\r\n:2\r\n:3\r\n:4\r\n:5\r\n:6\r\n", true},
+		{"*1\r\n*1\r\n:1\r\n", false},
+		{"*1\r\n*1\r\n*1\r\n:1\r\n", true},
+		{"*2\r\n*1\r\n:1\r\n*1\r\n:1\r\n", false},
+		{"*1\r\n$6\r\nhello!\r\n", true},
+		{"|1\r\n+a\r\n*1\r\n:1\r\n:2\r\n", false},
+		{"|1\r\n+a\r\n*1\r\n*1\r\n:1\r\n:2\r\n", true},
+	} {
+		// valid reply follows every test, which can't be read if the limits
+		// were exceeded
+		buf := bytes.NewBufferString(test.in + "+OK\r\n")
+		rr := NewRespReader(buf)
+		rr.SetLimits(l)
+
+		r := rr.Read()
+		if !test.exceed {
+			require.False(t, r.IsType(IOErr), test.in)
+			assert.Equal(t, pretendRead(test.in), r, test.in)
+			assert.Nil(t, rr.Read().Err, test.in)
+			continue
+		}
+
+		require.True(t, r.IsType(IOErr), test.in)
+		assert.True(t, errors.Is(r.Err, ErrReadLimit), test.in)
+		assert.Equal(t, r.Err, rr.Read().Err, test.in)
+		assert.Equal(t, r.Err, rr.ReadPooled().Err, test.in)
+		_, err := rr.ReadStream()
+		assert.Equal(t, r.Err, err, test.in)
+
+		// without limits the reply is read fine, unless it's one of the huge
+		// ones which doesn't actually have a body
+		if !strings.Contains(test.in, "2147483647") {
+			assert.False(t, pretendRead(test.in).IsType(IOErr), test.in)
+		}
+	}
+
+	// ReadPooled applies the limits as well
+	rr := NewRespReader(bytes.NewBufferString("*5\r\n:1\r\n:2\r\n:3\r\n:4\r\n:5\r\n"))
+	rr.SetLimits(l)
+	assert.True(t, errors.Is(rr.ReadPooled().Err, ErrReadLimit))
+
+	// Streamed bulk strings aren't limited
+	rr = NewRespReader(bytes.NewBufferString("$6\r\nhello!\r\n"))
+	rr.SetLimits(l)
+	br, err := rr.ReadStream()
+	require.Nil(t, err)
+	assert.Equal(t, int64(6), br.Len())
+}
+
+func TestClientReadLimits(t *T) {
+	addr := cmdServer(t, func(args []string) *Resp {
+		return NewResp(strings.Repeat("a", 100))
+	})
+	c, err := DialWithOpts("tcp", addr, DialOpts{
+		ReadLimits: ReadLimits{MaxBulkLen: 10},
+	})
+	require.Nil(t, err)
+
+	r := c.Cmd("GET", "foo")
+	assert.True(t, r.IsType(IOErr))
+	assert.True(t, errors.Is(r.Err, ErrReadLimit))
+	assert.Equal(t, r.Err, c.LastCritical)
+
+	// Without limits the reply is fine
+	c, err = Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+	s, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Len(t, s, 100)
+}
+
+func TestReadHugeHeaders(t *T) {
+	// a map header which would overflow when doubled is over the limit, and
+	// like any other leaves the RespReader unusable
+	rr := NewRespReader(bytes.NewBufferString("%4611686018427387904\r\n+OK\r\n"))
+	rr.SetLimits(ReadLimits{MaxArrayLen: 10})
+	r := rr.Read()
+	assert.True(t, r.IsType(IOErr))
+	assert.True(t, errors.Is(r.Err, ErrReadLimit))
+	assert.Equal(t, r.Err, rr.Read().Err)
+
+	// which is true even without limits
+	rr = NewRespReader(bytes.NewBufferString("%4611686018427387904\r\n+OK\r\n"))
+	r = rr.Read()
+	assert.True(t, errors.Is(r.Err, ErrReadLimit))
+	assert.Equal(t, r.Err, rr.Read().Err)
+
+	// without limits a header which is bigger than the reply actually is
+	// doesn't get allocated up front, it just runs out of data
+	for _, in := range []string{
+		"*4000000000000000000\r\n:1\r\n",
+		"%4000000000000000000\r\n:1\r\n",
+		"$4000000000000000000\r\nfoo\r\n",
+		"*1\r\n$4000000000000000000\r\nfoo\r\n",
+	} {
+		r := NewRespReader(bytes.NewBufferString(in)).Read()
+		assert.True(t, r.IsType(IOErr), in)
+		assert.True(t, errors.Is(r.Err, io.EOF) || errors.Is(r.Err, io.ErrUnexpectedEOF), in)
+	}
+
+	// replies which are larger than what's allocated up front are still read
+	// in full
+	big := strings.Repeat("a", maxPreallocBytes*3+1)
+	buf := bytes.NewBufferString("*2000\r\n")
+	for i := 0; i < 1999; i++ {
+		buf.WriteString("+a\r\n")
+	}
+	buf.WriteString("$" + strconv.Itoa(len(big)) + "\r\n" + big + "\r\n")
+	l, err := NewRespReader(buf).Read().List()
+	require.Nil(t, err)
+	assert.Len(t, l, 2000)
+	assert.Equal(t, big, l[1999])
+}
@@ -0,0 +1,402 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrMuxClosed is the error returned from commands on a MuxClient which has
+// been closed
+var ErrMuxClosed = errors.New("mux client closed")
+
+var errMuxUnexpectedReply = errors.New("received reply with no command waiting for it")
+
+// MuxOpts are the options used by DialMux
+type MuxOpts struct {
+	// The number of connections to spread commands over. Defaults to 1
+	Conns int
+
+	// How long to wait for more commands to come in, once one has, before
+	// writing them all to the connection together. The default, 0, means
+	// commands are written as soon as there are no more which are ready to go
+	// immediately, which already batches commands together under load. A
+	// small value (e.g. 100 microseconds) may increase throughput at the cost
+	// of latency.
+	FlushInterval time.Duration
+
+	// The function used to create each connection. This is where any setup
+	// (AUTH, SELECT, etc...) should happen, see DialOpts's DialFunc. The
+	// ReadTimeout and WriteTimeout of the returned Client are used. Defaults
+	// to Dial.
+	Dialer func(network, addr string) (*Client, error)
+}
+
+// MuxClient is a client which, unlike Client, is safe to use from many
+// go-routines at the same time. Commands from all of them are multiplexed
+// over one or a few connections: commands which come in at around the same
+// time are written to the connection together, as a pipeline, and their
+// replies are matched back up with them in the order they come in. Under heavy
+// concurrent load this gives much higher throughput than a pool.Pool, using
+// far fewer connections.
+//
+// Since connections are shared, commands which block the connection (e.g.
+// BLPOP), change its state (e.g. SELECT, WATCH, MULTI), or change how redis
+// replies on it (e.g. SUBSCRIBE) must not be used.
+//
+// If a connection hits a network error every command waiting on it gets an
+// IOErr, and it's replaced with a new one the next time it's used.
+type MuxClient struct {
+	network, addr string
+	o             MuxOpts
+
+	l      sync.Mutex
+	conns  []*muxConn
+	closed bool
+	next   uint32
+}
+
+// DialMux creates a MuxClient connected to the given redis instance
+func DialMux(network, addr string, o MuxOpts) (*MuxClient, error) {
+	if o.Conns < 1 {
+		o.Conns = 1
+	}
+	if o.Dialer == nil {
+		o.Dialer = Dial
+	}
+
+	mc := &MuxClient{
+		network: network,
+		addr:    addr,
+		o:       o,
+		conns:   make([]*muxConn, o.Conns),
+	}
+	for i := range mc.conns {
+		conn, err := mc.dial()
+		if err != nil {
+			mc.Close()
+			return nil, err
+		}
+		mc.conns[i] = conn
+	}
+	return mc, nil
+}
+
+func (mc *MuxClient) dial() (*muxConn, error) {
+	c, err := mc.o.Dialer(mc.network, mc.addr)
+	if err != nil {
+		return nil, err
+	}
+	return newMuxConn(c, mc.o.FlushInterval), nil
+}
+
+// getConn returns the next connection to use, replacing it with a new one if
+// it's been closed
+func (mc *MuxClient) getConn() (*muxConn, error) {
+	i := int(atomic.AddUint32(&mc.next, 1) % uint32(len(mc.conns)))
+
+	mc.l.Lock()
+	if mc.closed {
+		mc.l.Unlock()
+		return nil, ErrMuxClosed
+	} else if conn := mc.conns[i]; conn != nil && !conn.isClosed() {
+		mc.l.Unlock()
+		return conn, nil
+	}
+	mc.l.Unlock()
+
+	// dialing is done without the lock held, so that a slow dial doesn't hold
+	// up commands on every other connection
+	conn, err := mc.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	mc.l.Lock()
+	defer mc.l.Unlock()
+	if mc.closed {
+		conn.fail(ErrMuxClosed)
+		return nil, ErrMuxClosed
+	} else if cur := mc.conns[i]; cur != nil && !cur.isClosed() {
+		// another go-routine replaced the connection while this one was
+		// dialing
+		conn.fail(ErrMuxClosed)
+		return cur, nil
+	}
+	mc.conns[i] = conn
+	return conn, nil
+}
+
+// Cmd calls the given Redis command, and returns its reply. It may be called
+// from any number of go-routines at the same time.
+func (mc *MuxClient) Cmd(cmd string, args ...interface{}) *Resp {
+	return mc.CmdContext(context.Background(), cmd, args...)
+}
+
+// CmdContext is like Cmd, but stops waiting for the reply if the given
+// context is cancelled, in which case an IOErr with the context's error is
+// returned. Unlike with Client the connection is not affected, the command
+// may or may not still be run, and its reply is discarded when it comes in.
+//
+// Once CmdContext returns the arguments are no longer used and can be reused
+// by the caller, with the exception of StreamArgs, which may still be read
+// from if the command was cancelled while being written.
+func (mc *MuxClient) CmdContext(ctx context.Context, cmd string, args ...interface{}) *Resp {
+	conn, err := mc.getConn()
+	if err != nil {
+		return NewRespIOErr(err)
+	}
+	return conn.do(ctx, request{cmd, args})
+}
+
+// Close closes all of the MuxClient's connections. Any commands waiting on a
+// reply get an IOErr, as do any called afterwards.
+func (mc *MuxClient) Close() error {
+	mc.l.Lock()
+	defer mc.l.Unlock()
+	mc.closed = true
+	for _, conn := range mc.conns {
+		if conn != nil {
+			conn.fail(ErrMuxClosed)
+		}
+	}
+	return nil
+}
+
+type muxReq struct {
+	req    request
+	respCh chan *Resp
+
+	// closed by the writer once req has been encoded, after which its
+	// arguments are no longer used
+	encodedCh chan struct{}
+}
+
+// muxConn is a single multiplexed connection. Requests are sent to the writer
+// go-routine on reqCh, which adds them to pending and writes them. The reader
+// go-routine reads replies and hands each to the first pending request.
+// reqCh is unbuffered, so that a request is never sent to a writer which has
+// already stopped.
+type muxConn struct {
+	c             *Client
+	flushInterval time.Duration
+	reqCh         chan *muxReq
+	closeCh       chan struct{}
+
+	l       sync.Mutex
+	pending []*muxReq
+	err     error
+}
+
+func newMuxConn(c *Client, flushInterval time.Duration) *muxConn {
+	conn := &muxConn{
+		c:             c,
+		flushInterval: flushInterval,
+		reqCh:         make(chan *muxReq),
+		closeCh:       make(chan struct{}),
+	}
+	// do can return before the request is written, so the arguments can't be
+	// referenced by the buffer
+	c.writeBuf.noRefs = true
+
+	// whatever the Dialer did (e.g. AUTH) may have left a read deadline set,
+	// which an idle connection mustn't hit
+	c.conn.SetReadDeadline(time.Time{})
+	go conn.writer()
+	go conn.reader()
+	return conn
+}
+
+func (conn *muxConn) isClosed() bool {
+	select {
+	case <-conn.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeErr returns the error the connection was closed with
+func (conn *muxConn) closeErr() error {
+	conn.l.Lock()
+	defer conn.l.Unlock()
+	return conn.err
+}
+
+func (conn *muxConn) do(ctx context.Context, req request) *Resp {
+	mr := &muxReq{
+		req:       req,
+		respCh:    make(chan *Resp, 1),
+		encodedCh: make(chan struct{}),
+	}
+	select {
+	case conn.reqCh <- mr:
+	case <-conn.closeCh:
+		return NewRespIOErr(conn.closeErr())
+	case <-ctx.Done():
+		return NewRespIOErr(ctx.Err())
+	}
+
+	// once the writer has the request it's guaranteed to get a reply, even if
+	// it's only an error. It's also guaranteed to encode it, which has to
+	// finish before returning so the caller can reuse the arguments.
+	select {
+	case r := <-mr.respCh:
+		return r
+	case <-ctx.Done():
+		<-mr.encodedCh
+		return NewRespIOErr(ctx.Err())
+	}
+}
+
+// fail closes the connection, if it hasn't been already, and gives every
+// request waiting on a reply the given error
+func (conn *muxConn) fail(err error) {
+	conn.l.Lock()
+	defer conn.l.Unlock()
+	if conn.err != nil {
+		return
+	}
+	conn.err = err
+	conn.c.LastCritical = err
+	conn.c.Close()
+	close(conn.closeCh)
+
+	r := NewRespIOErr(err)
+	for _, mr := range conn.pending {
+		mr.respCh <- r
+	}
+	conn.pending = nil
+}
+
+func (conn *muxConn) writer() {
+	var batch []*muxReq
+	for {
+		batch = batch[:0]
+		select {
+		case mr := <-conn.reqCh:
+			batch = append(batch, mr)
+		case <-conn.closeCh:
+			return
+		}
+
+		if conn.flushInterval > 0 {
+			timer := time.NewTimer(conn.flushInterval)
+		wait:
+			for {
+				select {
+				case mr := <-conn.reqCh:
+					batch = append(batch, mr)
+				case <-timer.C:
+					break wait
+				case <-conn.closeCh:
+					timer.Stop()
+					break wait
+				}
+			}
+		}
+	more:
+		for {
+			select {
+			case mr := <-conn.reqCh:
+				batch = append(batch, mr)
+			default:
+				break more
+			}
+		}
+
+		conn.write(batch)
+	}
+}
+
+// write writes the batch of requests to the connection, and adds them to
+// pending so their replies can be read
+func (conn *muxConn) write(batch []*muxReq) {
+	rb := conn.c.writeBuf
+	rb.reset()
+	toWrite := batch[:0]
+	for _, mr := range batch {
+		err := rb.tryAppendRequest(mr.req, conn.c.SortMapArgs)
+		close(mr.encodedCh)
+		if err != nil {
+			mr.respCh <- NewResp(err)
+			continue
+		}
+		toWrite = append(toWrite, mr)
+	}
+	if len(toWrite) == 0 {
+		return
+	}
+
+	conn.l.Lock()
+	if conn.err != nil {
+		conn.l.Unlock()
+		r := NewRespIOErr(conn.err)
+		for _, mr := range toWrite {
+			mr.respCh <- r
+		}
+		return
+	}
+	conn.pending = append(conn.pending, toWrite...)
+	conn.l.Unlock()
+
+	c := conn.c
+	var err error
+	c.setWriteDeadline()
+	if rb.hasStreams() {
+		_, err = rb.WriteTo(timeoutWriter{c})
+	} else {
+		_, err = rb.WriteTo(c.conn)
+	}
+	if err != nil {
+		conn.fail(err)
+		return
+	}
+
+	conn.l.Lock()
+	conn.setReadDeadline()
+	conn.l.Unlock()
+}
+
+// setReadDeadline sets the read deadline of the connection based on whether
+// or not there are any replies pending. It must be called with l held, so
+// that the writer and reader don't step on each other
+func (conn *muxConn) setReadDeadline() {
+	c := conn.c
+	if c.ReadTimeout == 0 || len(conn.pending) == 0 {
+		// an idle connection shouldn't time out, and a deadline left over
+		// from before shouldn't apply if there's no ReadTimeout
+		c.conn.SetReadDeadline(time.Time{})
+	} else {
+		c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	}
+}
+
+func (conn *muxConn) reader() {
+	for {
+		r := conn.c.respReader.Read()
+		if r.IsType(IOErr) {
+			conn.fail(r.Err)
+			return
+		} else if r.IsType(Push) {
+			// out-of-band RESP3 data which no command is waiting on
+			continue
+		}
+
+		conn.l.Lock()
+		if len(conn.pending) == 0 {
+			conn.l.Unlock()
+			conn.fail(errMuxUnexpectedReply)
+			return
+		}
+		mr := conn.pending[0]
+		conn.pending[0] = nil
+		conn.pending = conn.pending[1:]
+		conn.setReadDeadline()
+		conn.l.Unlock()
+
+		mr.respCh <- r
+	}
+}
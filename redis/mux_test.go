@@ -0,0 +1,271 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoServer(t TB) string {
+	return cmdServer(t, func(args []string) *Resp {
+		if args[0] == "ECHO" {
+			return NewResp(args[1])
+		}
+		return NewRespSimple("PONG")
+	})
+}
+
+func TestMuxClient(t *T) {
+	for _, o := range []MuxOpts{
+		{},
+		{Conns: 3},
+		{Conns: 2, FlushInterval: 100 * time.Microsecond},
+	} {
+		mc, err := DialMux("tcp", echoServer(t), o)
+		require.Nil(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					expect := strconv.Itoa(i) + ":" + strconv.Itoa(j)
+					s, err := mc.Cmd("ECHO", expect).Str()
+					assert.Nil(t, err)
+					assert.Equal(t, expect, s)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// Encoding errors only affect the command in question
+		r := mc.Cmd("ECHO", testArgMarshaler(""))
+		assert.True(t, r.IsType(AppErr))
+		s, err := mc.Cmd("ECHO", "foo").Str()
+		require.Nil(t, err)
+		assert.Equal(t, "foo", s)
+
+		require.Nil(t, mc.Close())
+		r = mc.Cmd("ECHO", "foo")
+		assert.True(t, r.IsType(IOErr))
+		assert.Equal(t, ErrMuxClosed, r.Err)
+	}
+}
+
+func TestMuxClientReconnect(t *T) {
+	// The first connection is closed as soon as it gets a command, the rest
+	// reply to PING
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(i int) {
+				defer conn.Close()
+				rr := NewRespReader(conn)
+				for !rr.Read().IsType(IOErr) && i > 0 {
+					NewRespSimple("PONG").WriteTo(conn)
+				}
+			}(i)
+		}
+	}()
+
+	mc, err := DialMux("tcp", l.Addr().String(), MuxOpts{})
+	require.Nil(t, err)
+	defer mc.Close()
+
+	r := mc.Cmd("PING")
+	assert.True(t, r.IsType(IOErr))
+
+	s, err := mc.Cmd("PING").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", s)
+}
+
+func TestMuxClientContext(t *T) {
+	mc, err := DialMux("tcp", blackhole(t), MuxOpts{})
+	require.Nil(t, err)
+	defer mc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := mc.CmdContext(ctx, "PING")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+}
+
+func TestMuxClientContextArgs(t *T) {
+	// A server which doesn't read anything until told to, so that writing a
+	// large command blocks
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	readCh, argsCh := make(chan struct{}), make(chan [][]byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-readCh
+		args, _ := NewRespReader(conn).ReadRequest()
+		argsCh <- args
+	}()
+
+	mc, err := DialMux("tcp", l.Addr().String(), MuxOpts{})
+	require.Nil(t, err)
+	defer mc.Close()
+
+	// Once CmdContext has returned, even though the command is still being
+	// written, the caller can reuse its arguments without changing what's
+	// sent
+	buf := make([]byte, 16*1024*1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := mc.CmdContext(ctx, "SET", "foo", buf)
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+	for i := range buf {
+		buf[i] = 1
+	}
+
+	close(readCh)
+	args := <-argsCh
+	require.Len(t, args, 3)
+	assert.Equal(t, make([]byte, len(buf)), args[2])
+}
+
+func TestMuxClientSlowDial(t *T) {
+	addr := echoServer(t)
+	var dialing, release chan struct{}
+	dialer := func(network, addr string) (*Client, error) {
+		if dialing != nil {
+			close(dialing)
+			<-release
+		}
+		return Dial(network, addr)
+	}
+	mc, err := DialMux("tcp", addr, MuxOpts{Conns: 2, Dialer: dialer})
+	require.Nil(t, err)
+	defer mc.Close()
+
+	// The next command goes to the first connection, which has to be
+	// redialed
+	dialing, release = make(chan struct{}), make(chan struct{})
+	mc.conns[0].fail(errors.New("closed"))
+	mc.next = 1
+	doneCh := make(chan *Resp)
+	go func() { doneCh <- mc.Cmd("ECHO", "foo") }()
+	<-dialing
+
+	// Commands on the other connection aren't held up by the dial
+	s, err := mc.Cmd("ECHO", "bar").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+
+	close(release)
+	s, err = (<-doneCh).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+}
+
+func TestMuxClientReadTimeout(t *T) {
+	dialer := func(network, addr string) (*Client, error) {
+		return DialTimeout(network, addr, 50*time.Millisecond)
+	}
+	// An idle connection doesn't time out
+	mc, err := DialMux("tcp", echoServer(t), MuxOpts{Dialer: dialer})
+	require.Nil(t, err)
+	defer mc.Close()
+	time.Sleep(100 * time.Millisecond)
+	s, err := mc.Cmd("PING").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", s)
+	assert.Nil(t, mc.conns[0].closeErr())
+
+	mc, err = DialMux("tcp", blackhole(t), MuxOpts{Dialer: dialer})
+	require.Nil(t, err)
+	defer mc.Close()
+	r := mc.Cmd("PING")
+	assert.True(t, r.IsType(IOErr))
+	assert.True(t, IsTimeout(r))
+}
+
+func TestMuxClientDialOptsIdle(t *T) {
+	// The commands sent by DialOpts when connecting leave a read deadline on
+	// the connection, which mustn't be hit while it's idle
+	dialer := DialOpts{Timeout: 50 * time.Millisecond, ClientName: "x"}.DialFunc()
+	mc, err := DialMux("tcp", echoServer(t), MuxOpts{Dialer: dialer})
+	require.Nil(t, err)
+	defer mc.Close()
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, mc.conns[0].closeErr())
+	s, err := mc.Cmd("ECHO", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+
+	// The same goes for a Client with no ReadTimeout at all
+	dialer = func(network, addr string) (*Client, error) {
+		c, err := DialOpts{Timeout: 50 * time.Millisecond, ClientName: "x"}.DialFunc()(network, addr)
+		if err == nil {
+			c.ReadTimeout = 0
+		}
+		return c, err
+	}
+	mc, err = DialMux("tcp", echoServer(t), MuxOpts{Dialer: dialer})
+	require.Nil(t, err)
+	defer mc.Close()
+	require.Nil(t, mc.Cmd("ECHO", "foo").Err)
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, mc.conns[0].closeErr())
+}
+
+func TestMuxClientCloseFlushInterval(t *T) {
+	// Closing doesn't wait on the FlushInterval of a batch being collected
+	mc, err := DialMux("tcp", blackhole(t), MuxOpts{FlushInterval: time.Hour})
+	require.Nil(t, err)
+	doneCh := make(chan *Resp)
+	go func() { doneCh <- mc.Cmd("PING") }()
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, mc.Close())
+	select {
+	case r := <-doneCh:
+		assert.Equal(t, ErrMuxClosed, r.Err)
+	case <-time.After(time.Second):
+		t.Fatal("command still waiting after Close")
+	}
+}
+
+func benchmarkMux(b *B, do func(string) *Resp) {
+	b.ReportAllocs()
+	b.SetParallelism(10)
+	b.ResetTimer()
+	b.RunParallel(func(pb *PB) {
+		for pb.Next() {
+			if r := do("foo"); r.Err != nil {
+				b.Fatal(r.Err)
+			}
+		}
+	})
+}
+
+func BenchmarkMuxClient(b *B) {
+	addr := echoServer(b)
+	mc, err := DialMux("tcp", addr, MuxOpts{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer mc.Close()
+	benchmarkMux(b, func(s string) *Resp { return mc.Cmd("ECHO", s) })
+}
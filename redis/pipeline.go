@@ -0,0 +1,152 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPipelineNotExecuted is the error on the Resp of a PipelineCmd whose
+// command hasn't been run yet
+var ErrPipelineNotExecuted = errors.New("pipeline not executed")
+
+// Pipeline is a set of commands which are sent to redis all at once, and whose
+// replies are then all read back at once. Unlike with PipeAppend/PipeResp,
+// each command appended to a Pipeline gets its own PipelineCmd handle, through
+// which its reply can be retrieved once the Pipeline has been executed:
+//
+//	p := client.Pipeline()
+//	get := p.Append("GET", "foo")
+//	incr := p.Append("INCR", "bar")
+//	if err := p.Exec(); err != nil {
+//		// handle err
+//	}
+//
+//	foo, err := get.Resp().Str()
+//	bar, err := incr.Resp().Int()
+//
+// A Pipeline is tied to the Client it was created from, and, like the Client,
+// is not safe to use from multiple go-routines at the same time.
+type Pipeline struct {
+	c    *Client
+	reqs []request
+	cmds []*PipelineCmd
+}
+
+// PipelineCmd is a handle to a single command in a Pipeline, or queued in a
+// transaction, see Pipeline and Tx
+type PipelineCmd struct {
+	r *Resp
+}
+
+// Resp returns the reply to the command. Before the command has been run
+// this is an AppErr with ErrPipelineNotExecuted. If the command didn't get a
+// reply because Exec failed (see PipelineError) it is the error which caused
+// that.
+func (pc *PipelineCmd) Resp() *Resp {
+	if pc.r == nil {
+		return NewResp(ErrPipelineNotExecuted)
+	}
+	return pc.r
+}
+
+// PipelineError is returned from Exec when something went wrong which caused
+// some or all of the commands in the Pipeline not to get a reply. Errors
+// returned from redis for individual commands are not a PipelineError, they
+// are only available on the Resp of the command in question.
+type PipelineError struct {
+	// The error which caused the Pipeline to fail. This will be the same error
+	// as on the Resp of every command which didn't get a reply.
+	Err error
+
+	// The number of commands in the Pipeline which were written to the
+	// connection. This is either all of them or none of them, as they are all
+	// written at once. If the write failed part way through redis may have
+	// received and acted on some of the commands anyway, Sent only indicates
+	// that the write as a whole succeeded.
+	Sent int
+
+	// The number of commands which got a reply. The first Replied commands in
+	// the Pipeline, in the order they were appended, have a reply, while the
+	// rest don't.
+	Replied int
+
+	// The total number of commands in the Pipeline
+	Total int
+}
+
+func (pe *PipelineError) Error() string {
+	return fmt.Sprintf(
+		"pipeline failed, %d of %d commands sent and %d replied: %s",
+		pe.Sent, pe.Total, pe.Replied, pe.Err,
+	)
+}
+
+// Unwrap returns the error which caused the Pipeline to fail
+func (pe *PipelineError) Unwrap() error {
+	return pe.Err
+}
+
+// Pipeline returns a new, empty, Pipeline which will send its commands using
+// this Client
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// Append adds the given command to the Pipeline, returning a handle through
+// which the command's reply can be retrieved once Exec has been called
+func (p *Pipeline) Append(cmd string, args ...interface{}) *PipelineCmd {
+	pc := new(PipelineCmd)
+	p.reqs = append(p.reqs, request{cmd, args})
+	p.cmds = append(p.cmds, pc)
+	return pc
+}
+
+// Len returns the number of commands which have been appended to the Pipeline
+// since it was created or last executed
+func (p *Pipeline) Len() int {
+	return len(p.reqs)
+}
+
+// Exec sends all of the commands which have been appended to the Pipeline,
+// and reads all of their replies, which are then available through the
+// PipelineCmd of each. Exec returns a *PipelineError if not all of the commands
+// got a reply, either because they couldn't be encoded (in which case none of
+// them are sent), or because of a network error. Once Exec returns the
+// Pipeline is empty, and can be used again.
+func (p *Pipeline) Exec() error {
+	reqs, cmds := p.reqs, p.cmds
+	p.reqs, p.cmds = nil, nil
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	pe := &PipelineError{Total: len(reqs)}
+	fail := func(r *Resp) error {
+		pe.Err = r.Err
+		for _, pc := range cmds[pe.Replied:] {
+			pc.r = r
+		}
+		return pe
+	}
+
+	if r := p.c.writeRequest(reqs...); r != nil {
+		return fail(r)
+	}
+	pe.Sent = len(reqs)
+
+	for i, pc := range cmds {
+		r := p.c.readReply(reqs[i].cmd)
+		if r.IsType(IOErr) {
+			return fail(r)
+		}
+		pc.r = r
+		pe.Replied++
+	}
+	return nil
+}
+
+// Discard removes all of the commands which have been appended to the
+// Pipeline without sending them. Their PipelineCmds are left as they are.
+func (p *Pipeline) Discard() {
+	p.reqs, p.cmds = nil, nil
+}
@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineExec(t *T) {
+	m := map[string]string{}
+	addr := cmdServer(t, func(args []string) *Resp {
+		switch args[0] {
+		case "SET":
+			m[args[1]] = args[2]
+			return NewRespSimple("OK")
+		case "GET":
+			return NewResp(m[args[1]])
+		}
+		return NewResp(errors.New("ERR unknown command"))
+	})
+	c, err := Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+
+	p := c.Pipeline()
+	set := p.Append("SET", "foo", "bar")
+	bad := p.Append("BAD")
+	get := p.Append("GET", "foo")
+	assert.Equal(t, 3, p.Len())
+	assert.Equal(t, ErrPipelineNotExecuted, get.Resp().Err)
+
+	require.Nil(t, p.Exec())
+	assert.Equal(t, 0, p.Len())
+	assert.Nil(t, set.Resp().Err)
+	assert.True(t, bad.Resp().IsType(AppErr))
+	s, err := get.Resp().Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+
+	// Executing an empty Pipeline does nothing
+	require.Nil(t, p.Exec())
+
+	// The Pipeline can be reused, and doesn't interfere with PipeAppend
+	c.PipeAppend("GET", "foo")
+	get = p.Append("GET", "foo")
+	p.Append("SET", "a", "b")
+	p.Discard()
+	assert.Equal(t, ErrPipelineNotExecuted, get.Resp().Err)
+	get = p.Append("GET", "foo")
+	require.Nil(t, p.Exec())
+	for _, r := range []*Resp{get.Resp(), c.PipeResp()} {
+		s, err = r.Str()
+		require.Nil(t, err)
+		assert.Equal(t, "bar", s)
+	}
+
+	// Encoding errors mean nothing is sent
+	p.Append("SET", "foo", "baz")
+	failed := p.Append("SET", "foo", testArgMarshaler(""))
+	err = p.Exec()
+	require.IsType(t, &PipelineError{}, err)
+	pe := err.(*PipelineError)
+	assert.Equal(t, PipelineError{Err: failed.Resp().Err, Total: 2}, *pe)
+	assert.Equal(t, "empty", pe.Err.Error())
+	assert.Equal(t, "bar", m["foo"])
+	assert.Nil(t, c.LastCritical)
+}
+
+func TestPipelinePartialFailure(t *T) {
+	// replies to the first two commands it gets and then hangs up
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rr := NewRespReader(conn)
+		for i := 0; i < 2; i++ {
+			if rr.Read().IsType(IOErr) {
+				return
+			}
+			NewRespSimple("OK").WriteTo(conn)
+		}
+	}()
+
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(t, err)
+	defer c.Close()
+
+	p := c.Pipeline()
+	cmds := make([]*PipelineCmd, 4)
+	for i := range cmds {
+		cmds[i] = p.Append("PING")
+	}
+	err = p.Exec()
+	require.IsType(t, &PipelineError{}, err)
+	pe := err.(*PipelineError)
+	assert.Equal(t, 4, pe.Total)
+	assert.Equal(t, 4, pe.Sent)
+	assert.Equal(t, 2, pe.Replied)
+	assert.NotNil(t, pe.Err)
+	assert.NotNil(t, c.LastCritical)
+
+	for _, pc := range cmds[:2] {
+		assert.Nil(t, pc.Resp().Err)
+	}
+	for _, pc := range cmds[2:] {
+		assert.True(t, pc.Resp().IsType(IOErr))
+		assert.Equal(t, pe.Err, pc.Resp().Err)
+	}
+}
@@ -0,0 +1,172 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrClientClosed is returned from commands on a ReconnectClient which has
+// been closed
+var ErrClientClosed = errors.New("client closed")
+
+// ConnLostError is the error given for a command which was in progress when
+// the connection it was using was lost. The command may or may not have been
+// run by redis. It's the Err of an IOErr Resp.
+type ConnLostError struct {
+	// The network error which caused the connection to be lost
+	Err error
+}
+
+func (e *ConnLostError) Error() string {
+	return "connection lost during command: " + e.Err.Error()
+}
+
+// Unwrap returns the network error which caused the connection to be lost
+func (e *ConnLostError) Unwrap() error {
+	return e.Err
+}
+
+// ReconnectOpts are the options used by DialReconnect
+type ReconnectOpts struct {
+	// The function used to create each connection, including reconnects. This
+	// is where any setup (AUTH, SELECT, etc...) should happen, so that it's
+	// redone on every new connection, see DialOpts's DialFunc. Defaults to
+	// Dial.
+	Dialer func(network, addr string) (*Client, error)
+
+	// The number of times to try to reconnect before a command gives up and
+	// returns the error from the last attempt. The next command will start
+	// trying again. Defaults to 3.
+	MaxAttempts int
+
+	// Returns how long to wait before the given reconnect attempt, the first
+	// attempt being 1. Defaults to waiting no time before the first attempt,
+	// and then 100ms, doubling with every attempt after that, up to 5s.
+	Backoff func(attempt int) time.Duration
+}
+
+func defaultBackoff(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	d := 100 * time.Millisecond << uint(attempt-2)
+	if max := 5 * time.Second; d > max || d <= 0 {
+		d = max
+	}
+	return d
+}
+
+// ReconnectClient is a single connection to redis which, rather than being
+// unusable once it hits a critical network error like Client, reconnects the
+// next time a command is called on it. The command which was in progress when
+// the connection was lost gets an IOErr whose Err is a *ConnLostError.
+//
+// Like Client, a ReconnectClient is not safe to use from multiple go-routines
+// at the same time.
+type ReconnectClient struct {
+	network, addr string
+	o             ReconnectOpts
+	c             *Client
+	closed        bool
+}
+
+// DialReconnect connects to the given redis instance, returning a
+// ReconnectClient which will reconnect whenever the connection is lost. If the
+// initial connection can't be made its error is returned straight away.
+func DialReconnect(network, addr string, o ReconnectOpts) (*ReconnectClient, error) {
+	if o.Dialer == nil {
+		o.Dialer = Dial
+	}
+	if o.MaxAttempts < 1 {
+		o.MaxAttempts = 3
+	}
+	if o.Backoff == nil {
+		o.Backoff = defaultBackoff
+	}
+
+	c, err := o.Dialer(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectClient{network: network, addr: addr, o: o, c: c}, nil
+}
+
+// Client returns the current underlying connection, reconnecting first if
+// needed. This can be used to call methods which ReconnectClient doesn't have
+// (e.g. PipeAppend/PipeResp). The returned Client won't reconnect by itself,
+// and shouldn't be held on to.
+func (rc *ReconnectClient) Client() (*Client, error) {
+	return rc.client(context.Background())
+}
+
+func (rc *ReconnectClient) client(ctx context.Context) (*Client, error) {
+	if rc.closed {
+		return nil, ErrClientClosed
+	} else if rc.c != nil && rc.c.LastCritical == nil {
+		return rc.c, nil
+	}
+
+	rc.c = nil
+	var err error
+	for attempt := 1; attempt <= rc.o.MaxAttempts; attempt++ {
+		if d := rc.o.Backoff(attempt); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			}
+		}
+
+		var c *Client
+		if c, err = rc.o.Dialer(rc.network, rc.addr); err == nil {
+			rc.c = c
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// Cmd calls the given Redis command, reconnecting first if the connection was
+// lost. If the connection is lost during the command an IOErr with a
+// *ConnLostError is returned, and the next command will reconnect. If a new
+// connection couldn't be made an IOErr with the error from the last attempt
+// is returned.
+func (rc *ReconnectClient) Cmd(cmd string, args ...interface{}) *Resp {
+	return rc.CmdContext(context.Background(), cmd, args...)
+}
+
+// CmdContext is like Cmd, but the command, and any reconnecting beforehand, is
+// bound by the given context, as with Client's CmdContext. A command which is
+// cancelled by the context gets the context's error, not a ConnLostError,
+// though the next command will still reconnect.
+func (rc *ReconnectClient) CmdContext(ctx context.Context, cmd string, args ...interface{}) *Resp {
+	c, err := rc.client(ctx)
+	if err != nil {
+		return NewRespIOErr(err)
+	}
+
+	r := c.CmdContext(ctx, cmd, args...)
+	if r.IsType(IOErr) && c.LastCritical != nil && ctx.Err() == nil {
+		return NewRespIOErr(&ConnLostError{Err: r.Err})
+	}
+	return r
+}
+
+// Close closes the current connection, if there is one, after which no more
+// commands can be called
+func (rc *ReconnectClient) Close() error {
+	rc.closed = true
+	if rc.c == nil {
+		return nil
+	}
+	c := rc.c
+	rc.c = nil
+	if c.LastCritical != nil {
+		// already closed
+		return nil
+	}
+	return c.Close()
+}
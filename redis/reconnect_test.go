@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectClient(t *T) {
+	// Every connection sends the commands it gets to cmdCh, and is closed
+	// without a reply when it gets DIE
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	cmdCh := make(chan []string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rr := NewRespReader(conn)
+				for {
+					args, err := rr.Read().List()
+					if err != nil || args[0] == "DIE" {
+						return
+					}
+					cmdCh <- args
+					if args[0] == "ECHO" {
+						NewResp(args[1]).WriteTo(conn)
+					} else {
+						NewRespSimple("OK").WriteTo(conn)
+					}
+				}
+			}()
+		}
+	}()
+
+	rc, err := DialReconnect("tcp", l.Addr().String(), ReconnectOpts{
+		Dialer: DialOpts{ClientName: "admin"}.DialFunc(),
+	})
+	require.Nil(t, err)
+	defer rc.Close()
+	assert.Equal(t, []string{"CLIENT", "SETNAME", "admin"}, <-cmdCh)
+
+	s, err := rc.Cmd("ECHO", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+	<-cmdCh
+
+	r := rc.Cmd("DIE")
+	require.True(t, r.IsType(IOErr))
+	var connLost *ConnLostError
+	assert.True(t, errors.As(r.Err, &connLost))
+
+	// The next command reconnects, redoing the setup
+	s, err = rc.Cmd("ECHO", "bar").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	assert.Equal(t, []string{"CLIENT", "SETNAME", "admin"}, <-cmdCh)
+	assert.Equal(t, []string{"ECHO", "bar"}, <-cmdCh)
+
+	require.Nil(t, rc.Close())
+	r = rc.Cmd("ECHO", "baz")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, ErrClientClosed, r.Err)
+}
+
+func TestReconnectClientBackoff(t *T) {
+	addr := echoServer(t)
+	dialErr := errors.New("dial failed")
+	var failures int
+	var attempts []int
+	rc, err := DialReconnect("tcp", addr, ReconnectOpts{
+		Dialer: func(network, addr string) (*Client, error) {
+			if failures > 0 {
+				failures--
+				return nil, dialErr
+			}
+			return Dial(network, addr)
+		},
+		Backoff: func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return 0
+		},
+	})
+	require.Nil(t, err)
+	defer rc.Close()
+
+	// Kill the connection out from under the client
+	rc.c.conn.Close()
+	r := rc.Cmd("ECHO", "foo")
+	require.True(t, r.IsType(IOErr))
+	var connLost *ConnLostError
+	assert.True(t, errors.As(r.Err, &connLost))
+
+	// More failures than MaxAttempts, the command gets the dial error
+	failures = 5
+	r = rc.Cmd("ECHO", "foo")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, dialErr, r.Err)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+
+	// The next command starts over, and succeeds on its third attempt
+	attempts = nil
+	s, err := rc.Cmd("ECHO", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+
+	// Waiting for the backoff is bound by the context
+	rc.o.Backoff = func(int) time.Duration { return time.Hour }
+	rc.c.conn.Close()
+	rc.Cmd("ECHO", "foo")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r = rc.CmdContext(ctx, "ECHO", "foo")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+}
+
+func TestDefaultBackoff(t *T) {
+	assert.Equal(t, time.Duration(0), defaultBackoff(1))
+	assert.Equal(t, 100*time.Millisecond, defaultBackoff(2))
+	assert.Equal(t, 200*time.Millisecond, defaultBackoff(3))
+	assert.Equal(t, 5*time.Second, defaultBackoff(10))
+	assert.Equal(t, 5*time.Second, defaultBackoff(100))
+}
@@ -0,0 +1,297 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+)
+
+// ErrProtocol is the error (wrapped with details of what was wrong) given by
+// ReadRequest when a request is malformed. As with ErrReadLimit, once a
+// request is malformed the RespReader can't know where the next one starts,
+// so every read after that returns the same error.
+var ErrProtocol = errors.New("Protocol error")
+
+// maxRequestHeaderLen is the maximum length of the header line of a multibulk
+// request or of one of its arguments. They only ever contain a number.
+const maxRequestHeaderLen = 32
+
+// DefaultRequestLimits are the limits ReadRequest uses in place of any which
+// haven't been set with SetLimits, so that a request can never make the
+// reader allocate an unbounded amount of memory. They're the same as redis'
+// own defaults.
+var DefaultRequestLimits = ReadLimits{
+	MaxBulkLen:   512 * 1024 * 1024,
+	MaxArrayLen:  1024 * 1024,
+	MaxInlineLen: 64 * 1024,
+}
+
+func protocolErrorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrProtocol}, args...)...)
+}
+
+// ReadRequest reads a request, as sent by a client to a redis server, off of
+// the io.Reader. The first element of the returned slice is the name of the
+// command, and the rest its arguments. Both of the request formats redis
+// supports are accepted: multibulk requests (an array of bulk strings), which
+// is what clients send, and inline commands (a line of space separated
+// arguments, which may be quoted), which is what's typed over telnet:
+//
+//	PING
+//	SET foo "bar baz\n"
+//
+// Empty requests are skipped. The returned slices are owned by the caller.
+//
+// The limits set by SetLimits are applied to requests as well. MaxArrayLen
+// limits the number of arguments, MaxBulkLen the length of each argument, and
+// MaxInlineLen the length of an inline command. Any of these left as zero are
+// taken from DefaultRequestLimits instead. A request which exceeds them
+// returns an error wrapping ErrReadLimit, one which is malformed an error
+// wrapping ErrProtocol. Either way nothing more can be read from the
+// RespReader afterwards.
+func (rr *RespReader) ReadRequest() ([][]byte, error) {
+	if err := rr.ready(); err != nil {
+		return nil, err
+	}
+	l := rr.requestLimits()
+	for {
+		b, err := rr.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		var args [][]byte
+		if b[0] == arrayPrefix[0] {
+			args, err = readMultiBulkRequest(rr.r, l)
+		} else {
+			args, err = readInlineRequest(rr.r, l)
+		}
+		if err != nil {
+			return nil, rr.readErr(err)
+		} else if len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+// requestLimits returns the readLimits to apply to the next request, which are
+// those set by SetLimits with any unset ones taken from DefaultRequestLimits
+func (rr *RespReader) requestLimits() *readLimits {
+	l := &readLimits{ReadLimits: rr.lim.ReadLimits}
+	if l.MaxBulkLen == 0 {
+		l.MaxBulkLen = DefaultRequestLimits.MaxBulkLen
+	}
+	if l.MaxArrayLen == 0 {
+		l.MaxArrayLen = DefaultRequestLimits.MaxArrayLen
+	}
+	if l.MaxInlineLen == 0 {
+		l.MaxInlineLen = DefaultRequestLimits.MaxInlineLen
+	}
+	return l
+}
+
+// readRequestLine reads a single line off of r, returning it without its
+// trailing delimiter, which may be either "\r\n" or just "\n". If the line is
+// longer than max (and max isn't 0) errTooLong is returned. The returned
+// slice is only valid until the next read on r, unless it was longer than
+// r's buffer.
+func readRequestLine(r *bufio.Reader, max int64, errTooLong error) ([]byte, error) {
+	b, err := r.ReadSlice(delimEnd)
+	if err == bufio.ErrBufferFull {
+		b = append([]byte{}, b...)
+		for err == bufio.ErrBufferFull {
+			if max > 0 && int64(len(b)) > max {
+				return nil, errTooLong
+			}
+			var rest []byte
+			rest, err = r.ReadSlice(delimEnd)
+			b = append(b, rest...)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b = b[:len(b)-1]
+	if len(b) > 0 && b[len(b)-1] == '\r' {
+		b = b[:len(b)-1]
+	}
+	if max > 0 && int64(len(b)) > max {
+		return nil, errTooLong
+	}
+	return b, nil
+}
+
+// readRequestInt reads a header line starting with the given prefix off of r,
+// and returns the integer which follows the prefix
+func readRequestInt(r *bufio.Reader, prefix byte, what string) (int64, error) {
+	errBad := protocolErrorf("invalid %s", what)
+	b, err := readRequestLine(r, maxRequestHeaderLen, errBad)
+	if err != nil {
+		return 0, err
+	} else if len(b) < 2 || b[0] != prefix {
+		if len(b) == 0 {
+			return 0, protocolErrorf("expected '%c', got nothing", prefix)
+		}
+		return 0, protocolErrorf("expected '%c', got '%c'", prefix, b[0])
+	}
+	i, err := parseInt(b[1:])
+	if err != nil {
+		return 0, errBad
+	}
+	return i, nil
+}
+
+func readMultiBulkRequest(r *bufio.Reader, l *readLimits) ([][]byte, error) {
+	n, err := readRequestInt(r, arrayPrefix[0], "multibulk length")
+	if err != nil {
+		return nil, err
+	} else if n <= 0 {
+		return nil, nil
+	} else if err := l.checkArray(n); err != nil {
+		return nil, err
+	}
+
+	// n can't be trusted yet, so only a little is allocated up front and the
+	// rest as arguments actually arrive
+	prealloc := n
+	if prealloc > maxPreallocElems {
+		prealloc = maxPreallocElems
+	}
+	args := make([][]byte, 0, prealloc)
+	for i := int64(0); i < n; i++ {
+		size, err := readRequestInt(r, bulkStrPrefix[0], "bulk length")
+		if err != nil {
+			return nil, err
+		} else if size < 0 {
+			return nil, protocolErrorf("invalid bulk length")
+		} else if err := l.checkBulk(size); err != nil {
+			return nil, err
+		}
+
+		arg, err := readBulkBody(r, nil, size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func readInlineRequest(r *bufio.Reader, l *readLimits) ([][]byte, error) {
+	errTooLong := fmt.Errorf(
+		"%w: inline command length > %d", ErrReadLimit, l.MaxInlineLen,
+	)
+	b, err := readRequestLine(r, l.MaxInlineLen, errTooLong)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := splitInline(b)
+	if err != nil {
+		return nil, err
+	} else if err := l.checkArray(int64(len(args))); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// splitInline splits an inline command into its arguments, in the same way
+// redis does. Arguments are separated by whitespace, and may be quoted. Double
+// quoted arguments may contain the usual escape sequences (e.g. "\n", "\x07"),
+// single quoted ones only "\'".
+func splitInline(b []byte) ([][]byte, error) {
+	errQuotes := protocolErrorf("unbalanced quotes in request")
+	var args [][]byte
+	for i := 0; ; {
+		for i < len(b) && isInlineSpace(b[i]) {
+			i++
+		}
+		if i == len(b) {
+			return args, nil
+		}
+
+		arg := []byte{}
+		switch b[i] {
+		case '"':
+			for i++; ; i++ {
+				if i == len(b) {
+					return nil, errQuotes
+				} else if b[i] == '"' {
+					break
+				} else if b[i] != '\\' || i+1 == len(b) {
+					arg = append(arg, b[i])
+					continue
+				}
+
+				i++
+				switch b[i] {
+				case 'n':
+					arg = append(arg, '\n')
+				case 'r':
+					arg = append(arg, '\r')
+				case 't':
+					arg = append(arg, '\t')
+				case 'b':
+					arg = append(arg, '\b')
+				case 'a':
+					arg = append(arg, '\a')
+				case 'x':
+					if i+2 < len(b) && isHex(b[i+1]) && isHex(b[i+2]) {
+						arg = append(arg, unhex(b[i+1])<<4|unhex(b[i+2]))
+						i += 2
+					} else {
+						arg = append(arg, b[i])
+					}
+				default:
+					arg = append(arg, b[i])
+				}
+			}
+			i++
+		case '\'':
+			for i++; ; i++ {
+				if i == len(b) {
+					return nil, errQuotes
+				} else if b[i] == '\'' {
+					break
+				} else if b[i] == '\\' && i+1 < len(b) && b[i+1] == '\'' {
+					i++
+				}
+				arg = append(arg, b[i])
+			}
+			i++
+		default:
+			for ; i < len(b) && !isInlineSpace(b[i]); i++ {
+				arg = append(arg, b[i])
+			}
+		}
+
+		// a closing quote must be followed by a space, or the end of the line
+		if i < len(b) && !isInlineSpace(b[i]) {
+			return nil, errQuotes
+		}
+		args = append(args, arg)
+	}
+}
+
+func isInlineSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func byteArgs(args ...string) [][]byte {
+	bargs := make([][]byte, len(args))
+	for i := range args {
+		bargs[i] = []byte(args[i])
+	}
+	return bargs
+}
+
+func TestReadRequest(t *T) {
+	for _, test := range []struct {
+		in       string
+		expected [][]byte
+	}{
+		{"*1\r\n$4\r\nPING\r\n", byteArgs("PING")},
+		{"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$5\r\nb\r\nar\r\n", byteArgs("SET", "foo", "b\r\nar")},
+		{"*2\r\n$3\r\nGET\r\n$0\r\n\r\n", byteArgs("GET", "")},
+		{"PING\r\n", byteArgs("PING")},
+		{"PING\n", byteArgs("PING")},
+		{"  SET  foo\tbar \r\n", byteArgs("SET", "foo", "bar")},
+		{`SET foo "bar baz"` + "\r\n", byteArgs("SET", "foo", "bar baz")},
+		{`SET foo "a\r\n\t\"\\\x41\xzz"` + "\r\n", byteArgs("SET", "foo", "a\r\n\t\"\\Axzz")},
+		{`SET foo 'it\'s "ok"'` + "\r\n", byteArgs("SET", "foo", `it's "ok"`)},
+		{`SET foo ""` + "\r\n", byteArgs("SET", "foo", "")},
+
+		// empty requests are skipped
+		{"\r\n\n*0\r\n*-1\r\n  \r\nPING\r\n", byteArgs("PING")},
+	} {
+		rr := NewRespReader(bytes.NewBufferString(test.in))
+		args, err := rr.ReadRequest()
+		require.Nil(t, err, test.in)
+		assert.Equal(t, test.expected, args, test.in)
+	}
+
+	// Multiple requests in a row, of both kinds
+	buf := bytes.NewBufferString("*1\r\n$4\r\nPING\r\nECHO foo\r\n*2\r\n$4\r\nECHO\r\n$3\r\nbar\r\n")
+	rr := NewRespReader(buf)
+	for _, expected := range [][][]byte{
+		byteArgs("PING"), byteArgs("ECHO", "foo"), byteArgs("ECHO", "bar"),
+	} {
+		args, err := rr.ReadRequest()
+		require.Nil(t, err)
+		assert.Equal(t, expected, args)
+	}
+
+	// A long inline command, longer than the reader's buffer
+	long := strings.Repeat("a", 10000)
+	rr = NewRespReader(bytes.NewBufferString("ECHO " + long + "\r\n"))
+	args, err := rr.ReadRequest()
+	require.Nil(t, err)
+	assert.Equal(t, byteArgs("ECHO", long), args)
+}
+
+func TestReadRequestErrs(t *T) {
+	l := ReadLimits{MaxBulkLen: 5, MaxArrayLen: 3, MaxInlineLen: 20}
+	for _, test := range []struct {
+		in  string
+		err error
+	}{
+		{"*1\r\n:1\r\n", ErrProtocol},
+		{"*1\r\n\r\n", ErrProtocol},
+		{"*foo\r\n", ErrProtocol},
+		{"*1\r\n$foo\r\n", ErrProtocol},
+		{"*1\r\n$-1\r\n", ErrProtocol},
+		{"*1\r\n$" + strings.Repeat("1", 100) + "\r\n", ErrProtocol},
+		{`SET foo "bar` + "\r\n", ErrProtocol},
+		{`SET foo 'bar` + "\r\n", ErrProtocol},
+		{`SET foo "bar"baz` + "\r\n", ErrProtocol},
+		{"*4\r\n", ErrReadLimit},
+		{"*2\r\n$3\r\nGET\r\n$6\r\n", ErrReadLimit},
+		{"a b c d\r\n", ErrReadLimit},
+		{strings.Repeat("a", 21) + "\r\n", ErrReadLimit},
+		{strings.Repeat("a", 5000) + "\r\n", ErrReadLimit},
+	} {
+		// a valid request follows every test, which can't be read
+		rr := NewRespReader(bytes.NewBufferString(test.in + "PING\r\n"))
+		rr.SetLimits(l)
+		_, err := rr.ReadRequest()
+		require.NotNil(t, err, test.in)
+		assert.True(t, errors.Is(err, test.err), "%q: %s", test.in, err)
+
+		_, err2 := rr.ReadRequest()
+		assert.Equal(t, err, err2, test.in)
+		assert.Equal(t, err, rr.Read().Err, test.in)
+	}
+
+	// Running out of input isn't a protocol error
+	for _, in := range []string{"", "PING", "*1\r\n", "*1\r\n$4\r\nPI"} {
+		rr := NewRespReader(bytes.NewBufferString(in))
+		_, err := rr.ReadRequest()
+		require.NotNil(t, err, in)
+		assert.False(t, errors.Is(err, ErrProtocol), in)
+	}
+}
+
+func TestReadRequestDefaultLimits(t *T) {
+	// Even without any limits set requests can't be arbitrarily large
+	for _, in := range []string{
+		"*4000000000000000000\r\n",
+		"*1\r\n$4000000000000000000\r\n",
+		"*1\r\n$9223372036854775807\r\n",
+		strings.Repeat("a", 65*1024) + "\r\n",
+	} {
+		_, err := NewRespReader(bytes.NewBufferString(in)).ReadRequest()
+		assert.True(t, errors.Is(err, ErrReadLimit), "%.30q: %v", in, err)
+	}
+
+	// Limits which are set override the defaults, but the header still isn't
+	// trusted
+	rr := NewRespReader(bytes.NewBufferString("*4000000000000000000\r\n$4\r\nPING\r\n"))
+	rr.SetLimits(ReadLimits{MaxArrayLen: 1 << 62})
+	_, err := rr.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+
+	rr = NewRespReader(bytes.NewBufferString("*1\r\n$4000000000000000000\r\nPING\r\n"))
+	rr.SetLimits(ReadLimits{MaxBulkLen: 1 << 62})
+	_, err = rr.ReadRequest()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadRequestFromClient(t *T) {
+	// Everything a Client writes can be read as a request
+	rb := newRequestBuf()
+	require.Nil(t, rb.appendRequest(request{cmd: "HMSET", args: []interface{}{
+		"foo", map[string]int{"a": 1}, []byte("bin\x00ary"), 1.5,
+	}}, false))
+	buf := new(bytes.Buffer)
+	_, err := rb.WriteTo(buf)
+	require.Nil(t, err)
+
+	args, err := NewRespReader(buf).ReadRequest()
+	require.Nil(t, err)
+	assert.Equal(t, byteArgs("HMSET", "foo", "a", "1", "bin\x00ary", "1.5"), args)
+}
@@ -2,11 +2,15 @@ package redis
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -31,15 +35,30 @@ const (
 	Array
 	Nil
 
-	// Str combines both SimpleStr and BulkStr, which are considered strings to
-	// the Str() method.  This is what you want to give to IsType when
-	// determining if a response is a string
-	Str = SimpleStr | BulkStr
+	// The following types are only ever sent by redis on connections which
+	// have negotiated RESP3 (see the Hello method on Client)
+
+	Map      // A map of key/value pairs, stored as an alternating list
+	Set      // An unordered collection of unique elements
+	Double   // A floating point number
+	Bool     // A boolean
+	BigNum   // An integer too large for an int64
+	Verbatim // A string with a three character format prefix, e.g. "txt"
+	Push     // An out-of-band message, e.g. a pub/sub message
+
+	// Str combines SimpleStr, BulkStr and Verbatim, which are considered
+	// strings to the Str() method.  This is what you want to give to IsType
+	// when determining if a response is a string
+	Str = SimpleStr | BulkStr | Verbatim
 
 	// Err combines both IOErr and AppErr, which both indicate that the Err
 	// field on their Resp is filled. To determine if a Resp is an error you'll
 	// most often want to simply check if the Err field on it is nil
 	Err = IOErr | AppErr
+
+	// Aggregate combines all types whose values are a list of Resps, and
+	// which can therefore be read using the Array method and its wrappers
+	Aggregate = Array | Map | Set | Push
 )
 
 var (
@@ -49,6 +68,20 @@ var (
 	bulkStrPrefix   = []byte{'$'}
 	arrayPrefix     = []byte{'*'}
 	nilFormatted    = []byte("$-1\r\n")
+
+	// RESP3 prefixes
+	nullPrefix      = []byte{'_'}
+	boolPrefix      = []byte{'#'}
+	doublePrefix    = []byte{','}
+	bigNumPrefix    = []byte{'('}
+	blobErrPrefix   = []byte{'!'}
+	verbatimPrefix  = []byte{'='}
+	mapPrefix       = []byte{'%'}
+	setPrefix       = []byte{'~'}
+	attrPrefix      = []byte{'|'}
+	pushPrefix      = []byte{'>'}
+	boolTrueFormat  = []byte("#t\r\n")
+	boolFalseFormat = []byte("#f\r\n")
 )
 
 // Parse errors
@@ -58,6 +91,7 @@ var (
 	errNotStr   = errors.New("could not convert to string")
 	errNotInt   = errors.New("could not convert to int")
 	errNotArray = errors.New("could not convert to array")
+	errNotBool  = errors.New("could not convert to bool")
 
 	// ErrRespNil is returned from methods on Resp like Str, Int, etc... when
 	// called on a Resp which is a nil response
@@ -71,6 +105,13 @@ type Resp struct {
 	typ RespType
 	val interface{}
 
+	// attributes which were sent by the server alongside this Resp (RESP3
+	// only), stored as alternating key/values
+	attrs []Resp
+
+	// set on the root Resp of a reply read by ReadPooled
+	arena *respArena
+
 	// Err indicates that this Resp signals some kind of error, either on the
 	// connection level or the application level. Use IsType if you need to
 	// determine which, otherwise you can simply check if this is nil
@@ -113,10 +154,34 @@ func NewRespIOErr(err error) *Resp {
 	return r
 }
 
+const (
+	// maxInt is the largest size which can be allocated
+	maxInt = int64(^uint(0) >> 1)
+
+	// maxPreallocBytes and maxPreallocElems are the largest bulk string and
+	// aggregate sizes which are allocated up front when they're read. Anything
+	// larger is grown as it's read instead, so that a malformed (or
+	// malicious) header can't make us allocate more memory than the reply
+	// actually takes up.
+	maxPreallocBytes = 64 * 1024
+	maxPreallocElems = 1024
+)
+
 // RespReader is a wrapper around an io.Reader which will read Resp messages off
 // of the io.Reader
 type RespReader struct {
 	r *bufio.Reader
+
+	// the last BulkReader returned from ReadStream, see discardStream
+	stream *BulkReader
+
+	// set by SetLimits, see limits
+	lim    readLimits
+	hasLim bool
+
+	// set once a reply has exceeded the limits, after which nothing more can
+	// be read
+	err error
 }
 
 // NewRespReader creates and returns a new RespReader which will read from the
@@ -127,127 +192,363 @@ func NewRespReader(r io.Reader) *RespReader {
 	if !ok {
 		br = bufio.NewReader(r)
 	}
-	return &RespReader{br}
+	return &RespReader{r: br}
 }
 
 // ReadResp attempts to read a message object from the given io.Reader, parse
 // it, and return a Resp representing it
 func (rr *RespReader) Read() *Resp {
-	res, err := bufioReadResp(rr.r)
+	if err := rr.ready(); err != nil {
+		return NewRespIOErr(err)
+	}
+	res, err := bufioReadResp(rr.r, nil, rr.limits())
 	if err != nil {
+		err = rr.readErr(err)
 		res = Resp{typ: IOErr, val: err, Err: err}
 	}
 	return &res
 }
 
-func bufioReadResp(r *bufio.Reader) (Resp, error) {
+func bufioReadResp(r *bufio.Reader, a *respArena, l *readLimits) (Resp, error) {
 	b, err := r.Peek(1)
 	if err != nil {
 		return Resp{}, err
 	}
 	switch b[0] {
 	case simpleStrPrefix[0]:
-		return readSimpleStr(r)
+		return readSimpleStr(r, a)
 	case errPrefix[0]:
 		return readError(r)
 	case intPrefix[0]:
 		return readInt(r)
 	case bulkStrPrefix[0]:
-		return readBulkStr(r)
+		return readBulkStr(r, a, l)
 	case arrayPrefix[0]:
-		return readArray(r)
+		return readArray(r, a, l, Array)
+	case nullPrefix[0]:
+		return readNull(r)
+	case boolPrefix[0]:
+		return readBool(r)
+	case doublePrefix[0]:
+		return readDouble(r)
+	case bigNumPrefix[0]:
+		return readBigNum(r)
+	case blobErrPrefix[0]:
+		return readBlobError(r, l)
+	case verbatimPrefix[0]:
+		return readVerbatim(r, a, l)
+	case mapPrefix[0]:
+		return readArray(r, a, l, Map)
+	case setPrefix[0]:
+		return readArray(r, a, l, Set)
+	case pushPrefix[0]:
+		return readArray(r, a, l, Push)
+	case attrPrefix[0]:
+		return readAttr(r, a, l)
 	default:
 		return Resp{}, errBadType
 	}
 }
 
-func readSimpleStr(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+// readLine reads a single line off of r, returning it without its prefix byte
+// or trailing delimiter. The returned slice is only valid until the next read
+// on r
+func readLine(r *bufio.Reader) ([]byte, error) {
+	b, err := r.ReadSlice(delimEnd)
+	if err == bufio.ErrBufferFull {
+		// the line is longer than r's buffer, fall back to reading it into
+		// memory of its own
+		b = append([]byte{}, b...)
+		var rest []byte
+		rest, err = r.ReadBytes(delimEnd)
+		b = append(b, rest...)
+	}
+	if err != nil {
+		return nil, err
+	} else if len(b) < 3 || b[len(b)-2] != '\r' {
+		return nil, errParse
+	}
+	return b[1 : len(b)-2], nil
+}
+
+// readLineInt reads a line off of r and parses it as an integer
+func readLineInt(r *bufio.Reader) (int64, error) {
+	b, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	return parseInt(b)
+}
+
+// parseInt is like strconv.ParseInt with a base of 10, but works on a byte
+// slice without having to copy it into a string first
+func parseInt(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, errParse
+	}
+	// anything long enough to overflow is handed off to strconv, which handles
+	// that properly
+	if len(b) > 18 {
+		i, err := strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			return 0, errParse
+		}
+		return i, nil
+	}
+	var neg bool
+	if b[0] == '-' || b[0] == '+' {
+		neg = b[0] == '-'
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, errParse
+		}
+	}
+	var i int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, errParse
+		}
+		i = i*10 + int64(c-'0')
+	}
+	if neg {
+		i = -i
+	}
+	return i, nil
+}
+
+func readSimpleStr(r *bufio.Reader, a *respArena) (Resp, error) {
+	b, err := readLine(r)
 	if err != nil {
 		return Resp{}, err
 	}
-	return Resp{typ: SimpleStr, val: b[1 : len(b)-2]}, nil
+	buf := a.bytes(len(b))
+	copy(buf, b)
+	return Resp{typ: SimpleStr, val: a.bytesVal(buf)}, nil
 }
 
 func readError(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+	b, err := readLine(r)
 	if err != nil {
 		return Resp{}, err
 	}
-	err = errors.New(string(b[1 : len(b)-2]))
+	err = newError(string(b))
 	return Resp{typ: AppErr, val: err, Err: err}, nil
 }
 
 func readInt(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+	i, err := readLineInt(r)
 	if err != nil {
 		return Resp{}, err
 	}
-	i, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
-	if err != nil {
-		return Resp{}, errParse
-	}
 	return Resp{typ: Int, val: i}, nil
 }
 
-func readBulkStr(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+func readBulkStr(r *bufio.Reader, a *respArena, l *readLimits) (Resp, error) {
+	size, err := readLineInt(r)
 	if err != nil {
 		return Resp{}, err
 	}
-	size, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
+	if size < 0 {
+		return Resp{typ: Nil}, nil
+	} else if err := l.checkBulk(size); err != nil {
+		return Resp{}, err
+	}
+	total, err := readBulkBody(r, a, size)
 	if err != nil {
-		return Resp{}, errParse
+		return Resp{}, err
+	}
+	return Resp{typ: BulkStr, val: a.bytesVal(total)}, nil
+}
+
+// readBulkBody reads size bytes off of r, followed by the trailing delimiter
+// which follows every length-prefixed body
+func readBulkBody(r *bufio.Reader, a *respArena, size int64) ([]byte, error) {
+	var total []byte
+	if size > maxInt {
+		return nil, errTooLarge("bulk string", size)
+	} else if size <= maxPreallocBytes {
+		total = a.bytes(int(size))
+		if _, err := io.ReadFull(r, total); err != nil {
+			return nil, err
+		}
+	} else {
+		// The size can't be trusted, so the body is only allocated as it's
+		// actually read
+		buf := bytes.NewBuffer(make([]byte, 0, maxPreallocBytes))
+		if _, err := io.CopyN(buf, r, size); err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		total = buf.Bytes()
+	}
+
+	// There's a hanging \r\n there, gotta read past it
+	if _, err := r.Discard(2); err != nil {
+		return nil, err
+	}
+
+	return total, nil
+}
+
+// readArray reads any of the aggregate types, which all share the same format.
+// Maps are read as a list of alternating key/values, so their header
+// indicates half the number of elements actually read
+func readArray(r *bufio.Reader, a *respArena, l *readLimits, typ RespType) (Resp, error) {
+	size, err := readLineInt(r)
+	if err != nil {
+		return Resp{}, err
 	}
 	if size < 0 {
 		return Resp{typ: Nil}, nil
 	}
-	total := make([]byte, size)
-	b2 := total
-	var n int
-	for len(b2) > 0 {
-		n, err = r.Read(b2)
-		if err != nil {
-			return Resp{}, err
+	if typ == Map {
+		// checked before doubling, which could otherwise overflow
+		if size > maxInt/2 {
+			return Resp{}, errTooLarge("map", size)
 		}
-		b2 = b2[n:]
+		size *= 2
+	} else if size > maxInt {
+		return Resp{}, errTooLarge("array", size)
+	}
+	if err := l.checkArray(size); err != nil {
+		return Resp{}, err
+	} else if err := l.enter(); err != nil {
+		return Resp{}, err
 	}
 
-	// There's a hanging \r\n there, gotta read past it
-	trail := make([]byte, 2)
-	for i := 0; i < 2; i++ {
-		c, err := r.ReadByte()
-		if err != nil {
-			return Resp{}, err
+	var arr []Resp
+	if size <= maxPreallocElems {
+		arr = a.resps(int(size))
+		for i := range arr {
+			m, err := bufioReadResp(r, a, l)
+			if err != nil {
+				return Resp{}, err
+			}
+			arr[i] = m
+		}
+	} else {
+		// As with bulk strings, the size can't be trusted, so the elements
+		// are only allocated as they're actually read
+		arr = make([]Resp, 0, maxPreallocElems)
+		for i := int64(0); i < size; i++ {
+			m, err := bufioReadResp(r, a, l)
+			if err != nil {
+				return Resp{}, err
+			}
+			arr = append(arr, m)
 		}
-		trail[i] = c
 	}
+	l.leave()
+	return Resp{typ: typ, val: a.respsVal(arr)}, nil
+}
 
-	return Resp{typ: BulkStr, val: total}, nil
+func readNull(r *bufio.Reader) (Resp, error) {
+	if _, err := readLine(r); err != nil {
+		return Resp{}, err
+	}
+	return Resp{typ: Nil}, nil
 }
 
-func readArray(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+func readBool(r *bufio.Reader) (Resp, error) {
+	b, err := readLine(r)
 	if err != nil {
 		return Resp{}, err
 	}
-	size, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
+	switch string(b) {
+	case "t":
+		return Resp{typ: Bool, val: true}, nil
+	case "f":
+		return Resp{typ: Bool, val: false}, nil
+	default:
+		return Resp{}, errParse
+	}
+}
+
+func readDouble(r *bufio.Reader) (Resp, error) {
+	b, err := readLine(r)
 	if err != nil {
+		return Resp{}, err
+	}
+	var f float64
+	switch s := string(b); s {
+	case "inf":
+		f = math.Inf(1)
+	case "-inf":
+		f = math.Inf(-1)
+	case "nan", "-nan":
+		// some platforms' redis gives the sign of a NaN, which means nothing
+		f = math.NaN()
+	default:
+		if f, err = strconv.ParseFloat(s, 64); err != nil {
+			return Resp{}, errParse
+		}
+	}
+	return Resp{typ: Double, val: f}, nil
+}
+
+func readBigNum(r *bufio.Reader) (Resp, error) {
+	b, err := readLine(r)
+	if err != nil {
+		return Resp{}, err
+	}
+	i, ok := new(big.Int).SetString(string(b), 10)
+	if !ok {
 		return Resp{}, errParse
 	}
-	if size < 0 {
-		return Resp{typ: Nil}, nil
+	return Resp{typ: BigNum, val: i}, nil
+}
+
+func readBlobError(r *bufio.Reader, l *readLimits) (Resp, error) {
+	size, err := readLineInt(r)
+	if err != nil {
+		return Resp{}, err
+	} else if size < 0 {
+		return Resp{}, errParse
+	} else if err := l.checkBulk(size); err != nil {
+		return Resp{}, err
 	}
+	body, err := readBulkBody(r, nil, size)
+	if err != nil {
+		return Resp{}, err
+	}
+	err = newError(string(body))
+	return Resp{typ: AppErr, val: err, Err: err}, nil
+}
 
-	arr := make([]Resp, size)
-	for i := range arr {
-		m, err := bufioReadResp(r)
-		if err != nil {
-			return Resp{}, err
-		}
-		arr[i] = m
+// readVerbatim keeps the format prefix (e.g. "txt:") as part of the value, it
+// is stripped off by Bytes
+func readVerbatim(r *bufio.Reader, a *respArena, l *readLimits) (Resp, error) {
+	size, err := readLineInt(r)
+	if err != nil {
+		return Resp{}, err
+	} else if size < 4 {
+		return Resp{}, errParse
+	} else if err := l.checkBulk(size); err != nil {
+		return Resp{}, err
+	}
+	body, err := readBulkBody(r, a, size)
+	if err != nil {
+		return Resp{}, err
+	}
+	return Resp{typ: Verbatim, val: a.bytesVal(body)}, nil
+}
+
+// readAttr reads an attribute map and the reply which follows it, returning
+// the reply with the attributes attached
+func readAttr(r *bufio.Reader, a *respArena, l *readLimits) (Resp, error) {
+	attrs, err := readArray(r, a, l, Map)
+	if err != nil {
+		return Resp{}, err
+	}
+	res, err := bufioReadResp(r, a, l)
+	if err != nil {
+		return Resp{}, err
 	}
-	return Resp{typ: Array, val: arr}, nil
+	if kids, ok := attrs.arrayVal(); ok {
+		res.attrs = kids
+	}
+	return res, nil
 }
 
 // IsType returns whether or or not the reply is of a given type
@@ -265,19 +566,100 @@ func (r *Resp) IsType(t RespType) bool {
 // WriteTo writes the resp encoded form of the Resp to the given writer,
 // implementing the WriterTo interface
 func (r *Resp) WriteTo(w io.Writer) (int64, error) {
+	var totalWritten int64
+	if len(r.attrs) > 0 {
+		attrs := Resp{typ: Map, val: r.attrs}
+		written, err := writeAggregate(w, attrPrefix, &attrs)
+		totalWritten += written
+		if err != nil {
+			return totalWritten, err
+		}
+	}
+
+	var written int64
+	var err error
+	switch r.typ {
 
 	// SimpleStr is a special case, writeTo always writes strings as BulkStrs,
 	// so we just manually do SimpleStr here
-	if r.typ == SimpleStr {
-		s := r.val.([]byte)
+	case SimpleStr:
+		s, _ := r.bytesVal()
 		b := append(make([]byte, 0, len(s)+3), simpleStrPrefix...)
 		b = append(b, s...)
 		b = append(b, delim...)
-		written, err := w.Write(b)
-		return int64(written), err
+		var n int
+		n, err = w.Write(b)
+		written = int64(n)
+
+	// The RESP3 types don't have any analogue in the types writeTo knows about,
+	// so they are also done manually
+	case Map:
+		written, err = writeAggregate(w, mapPrefix, r)
+	case Set:
+		written, err = writeAggregate(w, setPrefix, r)
+	case Push:
+		written, err = writeAggregate(w, pushPrefix, r)
+	case Bool:
+		if r.val.(bool) {
+			written, err = writeBytesHelper(w, boolTrueFormat, 0, nil)
+		} else {
+			written, err = writeBytesHelper(w, boolFalseFormat, 0, nil)
+		}
+	case Double:
+		written, err = writeDouble(w, r.val.(float64))
+	case BigNum:
+		b := append(append([]byte{}, bigNumPrefix...), r.val.(*big.Int).String()...)
+		written, err = writeBytesHelper(w, append(b, delim...), 0, nil)
+	case Verbatim:
+		b, _ := r.bytesVal()
+		buf := strconv.AppendInt(append([]byte{}, verbatimPrefix...), int64(len(b)), 10)
+		written, err = writeBytesHelper(w, buf, written, err)
+		written, err = writeBytesHelper(w, delim, written, err)
+		written, err = writeBytesHelper(w, b, written, err)
+		written, err = writeBytesHelper(w, delim, written, err)
+
+	default:
+		written, err = writeTo(w, nil, r.value(), false, false, false)
 	}
+	return totalWritten + written, err
+}
 
-	return writeTo(w, nil, r.val, false, false)
+// writeAggregate writes a RESP3 aggregate type with the given prefix. Each
+// element is written using its own WriteTo method, so that the RESP3 types of
+// the elements are preserved
+func writeAggregate(w io.Writer, prefix []byte, r *Resp) (int64, error) {
+	kids, _ := r.arrayVal()
+	l := int64(len(kids))
+	if r.typ == Map {
+		l /= 2
+	}
+	buf := strconv.AppendInt(append([]byte{}, prefix...), l, 10)
+	written, err := writeBytesHelper(w, buf, 0, nil)
+	written, err = writeBytesHelper(w, delim, written, err)
+	if err != nil {
+		return written, err
+	}
+	for i := range kids {
+		kw, err := kids[i].WriteTo(w)
+		written += kw
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func writeDouble(w io.Writer, f float64) (int64, error) {
+	buf := append([]byte{}, doublePrefix...)
+	switch {
+	case math.IsInf(f, 1):
+		buf = append(buf, "inf"...)
+	case math.IsInf(f, -1):
+		buf = append(buf, "-inf"...)
+	default:
+		buf = strconv.AppendFloat(buf, f, 'f', -1, 64)
+	}
+	return writeBytesHelper(w, append(buf, delim...), 0, nil)
 }
 
 // Bytes returns a byte slice representing the value of the Resp. Only valid for
@@ -293,7 +675,11 @@ func (r *Resp) Bytes() ([]byte, error) {
 		return nil, errBadType
 	}
 
-	if b, ok := r.val.([]byte); ok {
+	if b, ok := r.bytesVal(); ok {
+		if r.typ == Verbatim {
+			// strip off the format prefix, e.g. "txt:"
+			return b[4:], nil
+		}
 		return b, nil
 	}
 	return nil, errNotStr
@@ -328,6 +714,11 @@ func (r *Resp) Int64() (int64, error) {
 		return 0, ErrRespNil
 	} else if i, ok := r.val.(int64); ok {
 		return i, nil
+	} else if bi, ok := r.val.(*big.Int); ok {
+		if !bi.IsInt64() {
+			return 0, errNotInt
+		}
+		return bi.Int64(), nil
 	}
 
 	if s, err := r.Str(); err == nil {
@@ -347,7 +738,10 @@ func (r *Resp) Float64() (float64, error) {
 	if r.Err != nil {
 		return 0, r.Err
 	}
-	if b, ok := r.val.([]byte); ok {
+	if f, ok := r.val.(float64); ok {
+		return f, nil
+	}
+	if b, ok := r.bytesVal(); ok {
 		f, err := strconv.ParseFloat(string(b), 64)
 		if err != nil {
 			return 0, err
@@ -357,18 +751,68 @@ func (r *Resp) Float64() (float64, error) {
 	return 0, errNotStr
 }
 
+// Bool returns a bool representing the value of the Resp. For a Resp of type
+// Bool the value is returned directly. For a Resp of type Int or Str the value
+// is true if it is 1, and false if it is 0. If r.Err != nil that will be
+// returned
+func (r *Resp) Bool() (bool, error) {
+	if r.Err != nil {
+		return false, r.Err
+	}
+	if r.IsType(Nil) {
+		return false, ErrRespNil
+	} else if b, ok := r.val.(bool); ok {
+		return b, nil
+	}
+
+	if i, err := r.Int64(); err == nil {
+		switch i {
+		case 0:
+			return false, nil
+		case 1:
+			return true, nil
+		}
+	}
+	return false, errNotBool
+}
+
+// BigInt returns a *big.Int representing the value of the Resp. Valid for a
+// Resp of type BigNum, Int, or a Str which can be parsed as a base-10 integer.
+// If r.Err != nil that will be returned
+func (r *Resp) BigInt() (*big.Int, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.IsType(Nil) {
+		return nil, ErrRespNil
+	} else if bi, ok := r.val.(*big.Int); ok {
+		return new(big.Int).Set(bi), nil
+	} else if i, ok := r.val.(int64); ok {
+		return big.NewInt(i), nil
+	}
+
+	if b, err := r.Bytes(); err == nil {
+		if bi, ok := new(big.Int).SetString(string(b), 10); ok {
+			return bi, nil
+		}
+	}
+	return nil, errNotInt
+}
+
 func (r *Resp) betterArray() ([]Resp, error) {
 	if r.Err != nil {
 		return nil, r.Err
 	}
-	if a, ok := r.val.([]Resp); ok {
+	if a, ok := r.arrayVal(); ok {
 		return a, nil
 	}
 	return nil, errNotArray
 }
 
-// Array returns the Resp slice encompassed by this Resp. Only valid for a Resp
-// of type Array. If r.Err != nil that will be returned
+// Array returns the Resp slice encompassed by this Resp. Valid for a Resp of
+// type Array, as well as any of the other Aggregate types. Map Resps are
+// returned as a list of alternating key/values. If r.Err != nil that will be
+// returned
 func (r *Resp) Array() ([]*Resp, error) {
 	a, err := r.betterArray()
 	if err != nil {
@@ -429,7 +873,9 @@ func (r *Resp) ListBytes() ([][]byte, error) {
 
 // Map is a wrapper around Array which returns the result as a map of strings,
 // calling Str() on alternating key/values for the map. All value fields of type
-// Nil will be treated as empty strings, keys must all be of type Str
+// Nil will be treated as empty strings, Int, Double, BigNum and Bool fields
+// are converted to their string forms. This works on both Array replies (as
+// sent by RESP2) and Map replies (as sent by RESP3)
 func (r *Resp) Map() (map[string]string, error) {
 	l, err := r.betterArray()
 	if err != nil {
@@ -447,7 +893,7 @@ func (r *Resp) Map() (map[string]string, error) {
 		k, v := l[0], l[1]
 		l = l[2:]
 
-		ks, err := k.Str()
+		ks, err := k.scalarStr()
 		if err != nil {
 			return nil, err
 		}
@@ -455,13 +901,42 @@ func (r *Resp) Map() (map[string]string, error) {
 		var vs string
 		if v.IsType(Nil) {
 			vs = ""
-		} else if vs, err = v.Str(); err != nil {
+		} else if vs, err = v.scalarStr(); err != nil {
 			return nil, err
 		}
 		m[ks] = vs
 	}
 }
 
+// scalarStr is like Str, but will also convert the numeric and boolean types
+// into strings, since RESP3 Map replies will often have those as values
+func (r *Resp) scalarStr() (string, error) {
+	switch v := r.val.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case *big.Int:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	}
+	return r.Str()
+}
+
+// Attributes returns the attributes which were sent by the server alongside
+// this Resp, as a Resp of type Map. This is only ever set on connections which
+// have negotiated RESP3. Returns nil if there were no attributes
+func (r *Resp) Attributes() *Resp {
+	if len(r.attrs) == 0 {
+		return nil
+	}
+	return &Resp{typ: Map, val: r.attrs}
+}
+
 // String returns a string representation of the Resp. This method is for
 // debugging, use Str() for reading a Str reply
 func (r *Resp) String() string {
@@ -472,24 +947,64 @@ func (r *Resp) String() string {
 	case IOErr:
 		inner = fmt.Sprintf("IOErr %s", r.Err)
 	case BulkStr, SimpleStr:
-		inner = fmt.Sprintf("Str %q", string(r.val.([]byte)))
+		b, _ := r.bytesVal()
+		inner = fmt.Sprintf("Str %q", string(b))
+	case Verbatim:
+		b, _ := r.Bytes()
+		inner = fmt.Sprintf("Verbatim %q", string(b))
 	case Int:
 		inner = fmt.Sprintf("Int %d", r.val.(int64))
+	case Double:
+		inner = fmt.Sprintf("Double %v", r.val.(float64))
+	case Bool:
+		inner = fmt.Sprintf("Bool %t", r.val.(bool))
+	case BigNum:
+		inner = fmt.Sprintf("BigNum %s", r.val.(*big.Int))
 	case Nil:
 		inner = fmt.Sprintf("Nil")
-	case Array:
-		kids := r.val.([]Resp)
+	case Array, Map, Set, Push:
+		kids, _ := r.arrayVal()
 		kidsStr := make([]string, len(kids))
 		for i := range kids {
 			kidsStr[i] = kids[i].String()
 		}
 		inner = strings.Join(kidsStr, " ")
+		switch r.typ {
+		case Map:
+			inner = "Map " + inner
+		case Set:
+			inner = "Set " + inner
+		case Push:
+			inner = "Push " + inner
+		}
 	default:
 		inner = "UNKNOWN"
 	}
 	return fmt.Sprintf("Resp(%s)", inner)
 }
 
+// ArgMarshaler is implemented by types which know how to encode themselves as
+// a single argument to a command. When a value implementing ArgMarshaler is
+// passed into Cmd (or any of the other methods which take command arguments)
+// the bytes returned from MarshalRedisArg are sent as the argument. If an
+// error is returned the command is not sent, and the error is returned
+// instead.
+type ArgMarshaler interface {
+	MarshalRedisArg() ([]byte, error)
+}
+
+// ArgsMarshaler is like ArgMarshaler, but for types which encode themselves as
+// zero or more arguments. The returned values are flattened into the argument
+// list as if they had been passed in directly, so they may be of any type Cmd
+// accepts (including ArgMarshalers).
+//
+// MarshalRedisArgs may be called more than once for the same command (once
+// for counting the arguments and again to write them), so it must return the
+// same values each time.
+type ArgsMarshaler interface {
+	MarshalRedisArgs() ([]interface{}, error)
+}
+
 var typeOfBytes = reflect.TypeOf([]byte(nil))
 
 func flattenedLength(mm ...interface{}) int {
@@ -497,20 +1012,45 @@ func flattenedLength(mm ...interface{}) int {
 	total := 0
 
 	for _, m := range mm {
-		switch m.(type) {
+		switch mt := m.(type) {
+		case ArgMarshaler:
+			total++
+		case ArgsMarshaler:
+			l, err := mt.MarshalRedisArgs()
+			if err != nil {
+				// The error will be returned when the args are written, at
+				// which point the length no longer matters
+				total++
+				continue
+			}
+			total += flattenedLength(l...)
+
 		case []byte, string, bool, nil, int, int8, int16, int32, int64, uint,
 			uint8, uint16, uint32, uint64, float32, float64, error:
 			total++
 
 		case Resp:
-			total += flattenedLength(m.(Resp).val)
+			rm := m.(Resp)
+			total += flattenedLength(rm.value())
 		case *Resp:
-			total += flattenedLength(m.(*Resp).val)
+			total += flattenedLength(m.(*Resp).value())
 
 		case []interface{}:
 			total += flattenedLength(m.([]interface{})...)
 
 		default:
+			if sv, ok := flattenableStruct(m); ok {
+				for _, f := range structFields(sv.Type()).fields {
+					if fv := sv.FieldByIndex(f.index); !f.omit(fv) {
+						total += 1 + flattenedLength(fv.Interface())
+					}
+				}
+				continue
+			} else if pm, ok := derefPtr(m); ok {
+				total += flattenedLength(pm)
+				continue
+			}
+
 			t := reflect.TypeOf(m)
 
 			switch t.Kind() {
@@ -541,6 +1081,37 @@ func flattenedLength(mm ...interface{}) int {
 }
 
 func flatten(m interface{}) []interface{} {
+	switch mt := m.(type) {
+	case nil:
+		return []interface{}{nil}
+	case ArgMarshaler:
+		return []interface{}{m}
+	case ArgsMarshaler:
+		l, err := mt.MarshalRedisArgs()
+		if err != nil {
+			return []interface{}{err}
+		}
+		ret := make([]interface{}, 0, len(l))
+		for i := range l {
+			ret = append(ret, flatten(l[i])...)
+		}
+		return ret
+	}
+
+	if sv, ok := flattenableStruct(m); ok {
+		fields := structFields(sv.Type()).fields
+		ret := make([]interface{}, 0, len(fields)*2)
+		for _, f := range fields {
+			if fv := sv.FieldByIndex(f.index); !f.omit(fv) {
+				ret = append(ret, f.name)
+				ret = append(ret, flatten(fv.Interface())...)
+			}
+		}
+		return ret
+	} else if pm, ok := derefPtr(m); ok {
+		return flatten(pm)
+	}
+
 	t := reflect.TypeOf(m)
 
 	// If it's a byte-slice we don't want to flatten
@@ -576,6 +1147,71 @@ func flatten(m interface{}) []interface{} {
 	}
 }
 
+// flattenableStruct returns the struct value of m, if m is a struct or a
+// non-nil pointer to one, and if it should be flattened into field name/value
+// pairs. Structs which implement fmt.Stringer (e.g. time.Time) are instead
+// written using their String method, as they always have been.
+func flattenableStruct(m interface{}) (reflect.Value, bool) {
+	if _, ok := m.(fmt.Stringer); ok {
+		return reflect.Value{}, false
+	}
+	v := reflect.ValueOf(m)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+		if _, ok := v.Interface().(fmt.Stringer); ok {
+			return reflect.Value{}, false
+		}
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	return v, true
+}
+
+// derefPtr returns what m points to, if m is a pointer, so that pointers (e.g.
+// the *string fields of a struct) are written as the value they point to
+// rather than as an address. A nil pointer is returned as nil, and so is
+// written the same as a nil argument. Pointers which implement fmt.Stringer
+// are left alone, they're written using their String method.
+func derefPtr(m interface{}) (interface{}, bool) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Ptr {
+		return nil, false
+	} else if v.IsNil() {
+		return nil, true
+	} else if _, ok := m.(fmt.Stringer); ok {
+		return nil, false
+	}
+	return v.Elem().Interface(), true
+}
+
+// sortKeys sorts the keys of a map, as returned by reflect's MapKeys, so that
+// maps are always flattened in the same order. Keys are sorted by their value
+// if they're strings or numbers, otherwise by their string form
+func sortKeys(keys []reflect.Value) {
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		if ki.Kind() == kj.Kind() {
+			switch ki.Kind() {
+			case reflect.String:
+				return ki.String() < kj.String()
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+				reflect.Int64:
+				return ki.Int() < kj.Int()
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+				reflect.Uint64:
+				return ki.Uint() < kj.Uint()
+			case reflect.Float32, reflect.Float64:
+				return ki.Float() < kj.Float()
+			}
+		}
+		return fmt.Sprint(ki.Interface()) < fmt.Sprint(kj.Interface())
+	})
+}
+
 func anyIntToInt64(m interface{}) int64 {
 	switch mt := m.(type) {
 	case int:
@@ -638,13 +1274,29 @@ func stringSlicer(buf []byte, s string) ([]byte, []byte) {
 // 0), so we don't have to re-allocate a new one every time we convert an
 // integer to a string.  forceString means all types will be converted to
 // strings, noArrayHeader means don't write out the headers to any arrays, just
-// inline all the elements in the array
+// inline all the elements in the array, sortMaps means the keys of maps will
+// be sorted before being written
 func writeTo(
-	w io.Writer, buf []byte, m interface{}, forceString, noArrayHeader bool,
+	w io.Writer, buf []byte, m interface{},
+	forceString, noArrayHeader, sortMaps bool,
 ) (
 	int64, error,
 ) {
 	switch mt := m.(type) {
+	case *StreamArg:
+		return writeStreamArg(w, buf, mt)
+	case ArgMarshaler:
+		b, err := mt.MarshalRedisArg()
+		if err != nil {
+			return 0, err
+		}
+		return writeStr(w, buf, b)
+	case ArgsMarshaler:
+		l, err := mt.MarshalRedisArgs()
+		if err != nil {
+			return 0, err
+		}
+		return writeTo(w, buf, l, forceString, noArrayHeader, sortMaps)
 	case []byte:
 		return writeStr(w, buf, mt)
 	case string:
@@ -687,7 +1339,7 @@ func writeTo(
 			}
 		}
 		for i := 0; i < l; i++ {
-			written, err := writeTo(w, buf, mt[i], forceString, noArrayHeader)
+			written, err := writeTo(w, buf, mt[i], forceString, noArrayHeader, sortMaps)
 			totalWritten += written
 			if err != nil {
 				return totalWritten, err
@@ -696,12 +1348,18 @@ func writeTo(
 		return totalWritten, nil
 
 	case *Resp:
-		return writeTo(w, buf, mt.val, forceString, noArrayHeader)
+		return writeTo(w, buf, mt.value(), forceString, noArrayHeader, sortMaps)
 
 	case Resp:
-		return writeTo(w, buf, mt.val, forceString, noArrayHeader)
+		return writeTo(w, buf, mt.value(), forceString, noArrayHeader, sortMaps)
 
 	default:
+		if sv, ok := flattenableStruct(m); ok {
+			return writeStruct(w, buf, sv, forceString, noArrayHeader, sortMaps)
+		} else if pm, ok := derefPtr(m); ok {
+			return writeTo(w, buf, pm, forceString, noArrayHeader, sortMaps)
+		}
+
 		// Fallback to reflect-based.
 		switch reflect.TypeOf(m).Kind() {
 		case reflect.Slice:
@@ -719,7 +1377,7 @@ func writeTo(
 			}
 			for i := 0; i < l; i++ {
 				vv := rm.Index(i).Interface()
-				written, err = writeTo(w, buf, vv, forceString, noArrayHeader)
+				written, err = writeTo(w, buf, vv, forceString, noArrayHeader, sortMaps)
 				totalWritten += written
 				if err != nil {
 					return totalWritten, err
@@ -741,16 +1399,20 @@ func writeTo(
 				}
 			}
 			keys := rm.MapKeys()
+			if sortMaps {
+				sortKeys(keys)
+			}
 			for _, k := range keys {
 				kv := k.Interface()
-				written, err = writeTo(w, buf, kv, forceString, noArrayHeader)
+				written, err = writeTo(w, buf, kv, forceString, noArrayHeader, sortMaps)
 				totalWritten += written
 				if err != nil {
 					return totalWritten, err
 				}
 
 				vv := rm.MapIndex(k).Interface()
-				written, err = writeTo(w, buf, vv, forceString, noArrayHeader)
+				written, err = writeTo(w, buf, vv, forceString, noArrayHeader, sortMaps)
+				totalWritten += written
 				if err != nil {
 					return totalWritten, err
 				}
@@ -763,6 +1425,53 @@ func writeTo(
 	}
 }
 
+// writeStruct writes the exported fields of a struct as alternating field
+// name/values, see flattenableStruct
+func writeStruct(
+	w io.Writer, buf []byte, sv reflect.Value,
+	forceString, noArrayHeader, sortMaps bool,
+) (
+	int64, error,
+) {
+	var totalWritten, written int64
+	var err error
+	fields := structFields(sv.Type()).fields
+
+	if !noArrayHeader {
+		var l int64
+		for _, f := range fields {
+			if fv := sv.FieldByIndex(f.index); !f.omit(fv) {
+				l += 2
+			}
+		}
+		written, err = writeArrayHeader(w, buf, l)
+		totalWritten += written
+		if err != nil {
+			return totalWritten, err
+		}
+	}
+
+	for _, f := range fields {
+		fv := sv.FieldByIndex(f.index)
+		if f.omit(fv) {
+			continue
+		}
+		sbuf, rest := stringSlicer(buf, f.name)
+		written, err = writeStr(w, rest, sbuf)
+		totalWritten += written
+		if err != nil {
+			return totalWritten, err
+		}
+		vv := fv.Interface()
+		written, err = writeTo(w, buf, vv, forceString, noArrayHeader, sortMaps)
+		totalWritten += written
+		if err != nil {
+			return totalWritten, err
+		}
+	}
+	return totalWritten, nil
+}
+
 func writeStr(w io.Writer, buf, b []byte) (int64, error) {
 	var err error
 	var written int64
@@ -822,11 +1531,11 @@ func writeNil(w io.Writer) (int64, error) {
 }
 
 // IsTimeout is a helper function for determining if an IOErr Resp was caused by
-// a network timeout
+// a network timeout. The net.Error may be wrapped, e.g. by a ConnLostError.
 func IsTimeout(r *Resp) bool {
 	if r.IsType(IOErr) {
-		t, ok := r.Err.(*net.OpError)
-		return ok && t.Timeout()
+		var t net.Error
+		return errors.As(r.Err, &t) && t.Timeout()
 	}
 	return false
 }
@@ -836,6 +1545,18 @@ func IsTimeout(r *Resp) bool {
 // NewResp and NewRespFlattenedStrings
 func format(m interface{}, forceString bool) Resp {
 	switch mt := m.(type) {
+	case ArgMarshaler:
+		b, err := mt.MarshalRedisArg()
+		if err != nil {
+			return Resp{typ: AppErr, val: err, Err: err}
+		}
+		return Resp{typ: BulkStr, val: b}
+	case ArgsMarshaler:
+		l, err := mt.MarshalRedisArgs()
+		if err != nil {
+			return Resp{typ: AppErr, val: err, Err: err}
+		}
+		return format(l, forceString)
 	case []byte:
 		return Resp{typ: BulkStr, val: mt}
 	case string:
@@ -887,6 +1608,20 @@ func format(m interface{}, forceString bool) Resp {
 		return mt
 
 	default:
+		if sv, ok := flattenableStruct(m); ok {
+			fields := structFields(sv.Type()).fields
+			rl := make([]Resp, 0, len(fields)*2)
+			for _, f := range fields {
+				if fv := sv.FieldByIndex(f.index); !f.omit(fv) {
+					rl = append(rl, Resp{typ: BulkStr, val: []byte(f.name)})
+					rl = append(rl, format(fv.Interface(), forceString))
+				}
+			}
+			return Resp{typ: Array, val: rl}
+		} else if pm, ok := derefPtr(m); ok {
+			return format(pm, forceString)
+		}
+
 		// Fallback to reflect-based.
 		switch reflect.TypeOf(m).Kind() {
 		case reflect.Slice:
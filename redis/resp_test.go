@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	. "testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func pretendRead(s string) *Resp {
@@ -38,13 +41,13 @@ func TestRead(t *T) {
 	// Error
 	r = pretendRead("-ohey\r\n")
 	assert.Equal(t, AppErr, r.typ)
-	assert.Exactly(t, errors.New("ohey"), r.val)
+	assert.Exactly(t, &Error{Msg: "ohey"}, r.val)
 	assert.Equal(t, "ohey", r.Err.Error())
 
 	// Empty error
 	r = pretendRead("-\r\n")
 	assert.Equal(t, AppErr, r.typ)
-	assert.Exactly(t, errors.New(""), r.val)
+	assert.Exactly(t, &Error{Msg: ""}, r.val)
 	assert.Equal(t, "", r.Err.Error())
 
 	// Int
@@ -222,3 +225,309 @@ func TestFloat64(t *T) {
 	assert.Equal(t, float64(5.0), f)
 
 }
+
+func TestReadResp3(t *T) {
+
+	// Null
+	r := pretendRead("_\r\n")
+	assert.Equal(t, Nil, r.typ)
+	_, err := r.Str()
+	assert.Equal(t, ErrRespNil, err)
+
+	// Bool
+	r = pretendRead("#t\r\n")
+	assert.Equal(t, Bool, r.typ)
+	bl, err := r.Bool()
+	assert.Nil(t, err)
+	assert.Equal(t, true, bl)
+	r = pretendRead("#f\r\n")
+	bl, err = r.Bool()
+	assert.Nil(t, err)
+	assert.Equal(t, false, bl)
+
+	// Double
+	r = pretendRead(",1.5\r\n")
+	assert.Equal(t, Double, r.typ)
+	f, err := r.Float64()
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, f)
+	r = pretendRead(",-inf\r\n")
+	f, err = r.Float64()
+	assert.Nil(t, err)
+	assert.True(t, math.IsInf(f, -1))
+	for _, nan := range []string{",nan\r\n", ",-nan\r\n"} {
+		r = pretendRead(nan)
+		assert.Equal(t, Double, r.typ)
+		f, err = r.Float64()
+		assert.Nil(t, err)
+		assert.True(t, math.IsNaN(f))
+	}
+
+	// BigNum
+	r = pretendRead("(3492890328409238509324850943850943825024385\r\n")
+	assert.Equal(t, BigNum, r.typ)
+	bi, err := r.BigInt()
+	assert.Nil(t, err)
+	assert.Equal(t, "3492890328409238509324850943850943825024385", bi.String())
+	_, err = r.Int64()
+	assert.NotNil(t, err)
+
+	// Blob error
+	r = pretendRead("!21\r\nSYNTAX invalid syntax\r\n")
+	assert.Equal(t, AppErr, r.typ)
+	assert.Equal(t, "SYNTAX invalid syntax", r.Err.Error())
+
+	// Verbatim string
+	r = pretendRead("=15\r\ntxt:Some string\r\n")
+	assert.Equal(t, Verbatim, r.typ)
+	assert.True(t, r.IsType(Str))
+	s, err := r.Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "Some string", s)
+
+	// Map
+	r = pretendRead("%2\r\n+first\r\n:1\r\n+second\r\n$3\r\ntwo\r\n")
+	assert.Equal(t, Map, r.typ)
+	assert.True(t, r.IsType(Aggregate))
+	assert.Equal(t, 4, len(r.val.([]Resp)))
+	m, err := r.Map()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"first": "1", "second": "two"}, m)
+
+	// Set
+	r = pretendRead("~2\r\n+foo\r\n+bar\r\n")
+	assert.Equal(t, Set, r.typ)
+	l, err := r.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, l)
+
+	// Push
+	r = pretendRead(">3\r\n+message\r\n+chan\r\n+hi\r\n")
+	assert.Equal(t, Push, r.typ)
+	l, err = r.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"message", "chan", "hi"}, l)
+
+	// Attributes are attached to the following reply
+	r = pretendRead("|1\r\n+ttl\r\n:3600\r\n$3\r\nfoo\r\n")
+	assert.Equal(t, BulkStr, r.typ)
+	s, err = r.Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", s)
+	attrs := r.Attributes()
+	require.NotNil(t, attrs)
+	m, err = attrs.Map()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"ttl": "3600"}, m)
+	assert.Nil(t, pretendRead("+foo\r\n").Attributes())
+
+	// Embedded RESP3 types
+	r = pretendRead("*2\r\n%1\r\n+a\r\n#t\r\n,0.25\r\n")
+	assert.Equal(t, Array, r.typ)
+	arr, err := r.Array()
+	require.Nil(t, err)
+	assert.Equal(t, Map, arr[0].typ)
+	assert.Equal(t, Double, arr[1].typ)
+
+	// Bad bool
+	r = pretendRead("#x\r\n")
+	assert.Equal(t, IOErr, r.typ)
+}
+
+func TestWriteResp3(t *T) {
+	tests := []string{
+		"_\r\n",
+		"#t\r\n",
+		"#f\r\n",
+		",1.5\r\n",
+		",inf\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"%2\r\n+first\r\n:1\r\n+second\r\n,2.5\r\n",
+		"~2\r\n+foo\r\n#f\r\n",
+		">2\r\n+message\r\n%1\r\n+a\r\n:1\r\n",
+		"|1\r\n+ttl\r\n:3600\r\n+foo\r\n",
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	for _, test := range tests {
+		buf.Reset()
+		r := pretendRead(test)
+		require.Nil(t, r.Err, test)
+		_, err := r.WriteTo(buf)
+		assert.Nil(t, err)
+
+		// Null is written as a RESP2 nil, since that's understood by
+		// everything
+		if test == "_\r\n" {
+			assert.Equal(t, "$-1\r\n", buf.String())
+			continue
+		}
+		assert.Equal(t, test, buf.String())
+	}
+}
+
+type testArgMarshaler string
+
+func (m testArgMarshaler) MarshalRedisArg() ([]byte, error) {
+	if m == "" {
+		return nil, errors.New("empty")
+	}
+	return []byte("m:" + m), nil
+}
+
+type testArgsMarshaler []interface{}
+
+func (m testArgsMarshaler) MarshalRedisArgs() ([]interface{}, error) {
+	if m == nil {
+		return nil, errors.New("nil")
+	}
+	return m, nil
+}
+
+func TestArgMarshaler(t *T) {
+	args := []interface{}{
+		testArgMarshaler("foo"),
+		testArgsMarshaler{"a", 1, testArgMarshaler("bar"), []string{"b", "c"}},
+		testArgsMarshaler{},
+	}
+	assert.Equal(t, 6, flattenedLength(args...))
+
+	buf := bytes.NewBuffer([]byte{})
+	for _, arg := range args {
+		_, err := writeTo(buf, nil, arg, true, true, false)
+		require.Nil(t, err)
+	}
+	expect := "$5\r\nm:foo\r\n" +
+		"$1\r\na\r\n$1\r\n1\r\n$5\r\nm:bar\r\n$1\r\nb\r\n$1\r\nc\r\n"
+	assert.Equal(t, expect, buf.String())
+
+	// Errors are returned from writeTo
+	_, err := writeTo(buf, nil, testArgMarshaler(""), true, true, false)
+	assert.Equal(t, "empty", err.Error())
+	_, err = writeTo(buf, nil, testArgsMarshaler(nil), true, true, false)
+	assert.Equal(t, "nil", err.Error())
+
+	r := NewRespFlattenedStrings(args)
+	l, err := r.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"m:foo", "a", "1", "m:bar", "b", "c"}, l)
+
+	key, err := KeyFromArgs(testArgsMarshaler{testArgMarshaler("key")})
+	require.Nil(t, err)
+	assert.Equal(t, "m:key", key)
+}
+
+type testStructArgInner struct {
+	C string `redis:"c"`
+}
+
+type testStructArg struct {
+	A    string  `redis:"a"`
+	B    int     `redis:"b,omitempty"`
+	Skip string  `redis:"-"`
+	F    float64 `redis:"f,omitempty"`
+	testStructArgInner
+	T time.Time
+
+	unexported string
+}
+
+func TestStructArgs(t *T) {
+	ts := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := testStructArg{
+		A: "foo", Skip: "bar", F: 1.5, T: ts, unexported: "baz",
+		testStructArgInner: testStructArgInner{C: "inner"},
+	}
+	assert.Equal(t, 9, flattenedLength("key", s))
+	assert.Equal(t, 9, flattenedLength("key", &s))
+
+	expectL := []string{"key", "a", "foo", "f", "1.5", "T", ts.String(), "c", "inner"}
+	for _, arg := range []interface{}{s, &s} {
+		buf := bytes.NewBuffer([]byte{})
+		for _, a := range []interface{}{"key", arg} {
+			_, err := writeTo(buf, nil, a, true, true, false)
+			require.Nil(t, err)
+		}
+		var expect string
+		for _, str := range expectL {
+			expect += fmt.Sprintf("$%d\r\n%s\r\n", len(str), str)
+		}
+		assert.Equal(t, expect, buf.String())
+
+		l, err := NewRespFlattenedStrings([]interface{}{"key", arg}).List()
+		require.Nil(t, err)
+		assert.Equal(t, expectL, l)
+
+		key, err := KeyFromArgs(arg)
+		require.Nil(t, err)
+		assert.Equal(t, "a", key)
+	}
+
+	// Not flattened, a struct is an array of its fields
+	m := map[string]string{}
+	require.Nil(t, NewResp(s).Unmarshal(&m))
+	assert.Equal(t, map[string]string{
+		"a": "foo", "f": "1.5", "c": "inner", "T": ts.String(),
+	}, m)
+
+	// A nil pointer to a struct is not flattened
+	assert.Equal(t, 1, flattenedLength((*testStructArg)(nil)))
+}
+
+type testPtrStructArg struct {
+	S    *string  `redis:"s"`
+	I    *int     `redis:"i,omitempty"`
+	F    *float64 `redis:"f,omitempty"`
+	Nil  *string  `redis:"nil"`
+	Omit *int     `redis:"omit,omitempty"`
+}
+
+func TestPtrStructArgs(t *T) {
+	str, i, f := "foo", 5, 1.5
+	s := testPtrStructArg{S: &str, I: &i, F: &f}
+
+	// Pointers are written as what they point to, nil ones as empty
+	expectL := []string{"s", "foo", "i", "5", "f", "1.5", "nil", ""}
+	assert.Equal(t, len(expectL), flattenedLength(s))
+	assert.Equal(t, []interface{}{"s", "foo", "i", 5, "f", 1.5, "nil", nil}, flatten(s))
+
+	buf := bytes.NewBuffer([]byte{})
+	_, err := writeTo(buf, nil, s, true, false, false)
+	require.Nil(t, err)
+	l, err := NewRespReader(buf).Read().List()
+	require.Nil(t, err)
+	assert.Equal(t, expectL, l)
+
+	// What's written can be decoded back into the struct, as HGETALL would
+	// return it
+	var s2 testPtrStructArg
+	require.Nil(t, NewResp(l).Unmarshal(&s2))
+	assert.Equal(t, str, *s2.S)
+	assert.Equal(t, i, *s2.I)
+	assert.Equal(t, f, *s2.F)
+	assert.Equal(t, "", *s2.Nil)
+	assert.Nil(t, s2.Omit)
+
+	key, err := KeyFromArgs(&str)
+	require.Nil(t, err)
+	assert.Equal(t, "foo", key)
+}
+
+func TestSortMapArgs(t *T) {
+	m := map[string]int{"c": 3, "a": 1, "d": 4, "b": 2, "e": 5}
+	expect := "$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n" +
+		"$1\r\nd\r\n$1\r\n4\r\n$1\r\ne\r\n$1\r\n5\r\n"
+	for i := 0; i < 10; i++ {
+		buf := bytes.NewBuffer([]byte{})
+		n, err := writeTo(buf, nil, m, true, true, true)
+		require.Nil(t, err)
+		assert.Equal(t, int64(buf.Len()), n)
+		assert.Equal(t, expect, buf.String())
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	_, err := writeTo(buf, nil, map[int]string{10: "b", 2: "a"}, true, false, true)
+	require.Nil(t, err)
+	assert.Equal(t, "*4\r\n$1\r\n2\r\n$1\r\na\r\n$2\r\n10\r\n$1\r\nb\r\n", buf.String())
+}
@@ -0,0 +1,290 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+var errStreamClosed = errors.New("read from closed BulkReader")
+
+// BulkReader reads the body of a single bulk string reply directly off of the
+// connection, rather than reading it into memory all at once. This is useful
+// for very large values, e.g. the output of DUMP, which can be copied straight
+// into a file or http response:
+//
+//	br, err := client.CmdStream("GET", "big-blob")
+//	if err != nil {
+//		// handle err
+//	}
+//	defer br.Close()
+//	_, err = io.Copy(w, br)
+//
+// Until the BulkReader has been read to the end or closed the rest of the
+// reply is still sitting on the connection. It will be discarded automatically
+// when the next reply is read, but the BulkReader can't be used after that.
+type BulkReader struct {
+	r      io.Reader
+	size   int64
+	left   int64
+	trail  bool // whether the \r\n after the body still needs to be read
+	closed bool
+	err    error
+
+	// optional, see Client.CmdStream
+	beforeRead func()
+	onErr      func(error)
+}
+
+func newBulkReader(r io.Reader, size int64, trail bool) *BulkReader {
+	return &BulkReader{r: r, size: size, left: size, trail: trail}
+}
+
+// Len returns the total length of the bulk string, in bytes, regardless of
+// how much of it has been read
+func (br *BulkReader) Len() int64 {
+	return br.size
+}
+
+// Read implements the io.Reader interface. It returns io.EOF once the whole
+// bulk string has been read.
+func (br *BulkReader) Read(p []byte) (int, error) {
+	if br.err != nil {
+		return 0, br.err
+	} else if br.closed {
+		return 0, errStreamClosed
+	} else if br.left == 0 {
+		if err := br.finish(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	} else if len(p) == 0 {
+		return 0, nil
+	}
+
+	if int64(len(p)) > br.left {
+		p = p[:br.left]
+	}
+	if br.beforeRead != nil {
+		br.beforeRead()
+	}
+	n, err := br.r.Read(p)
+	br.left -= int64(n)
+	if err == io.EOF && br.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		return n, br.fail(err)
+	}
+
+	// read past the trailing delimiter as soon as the body is done, so the
+	// connection is ready for the next reply even if Read is never called
+	// again
+	if br.left == 0 {
+		if err := br.finish(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close discards whatever is left of the bulk string, leaving the connection
+// ready for the next reply. It is safe to call Close more than once, and once
+// the whole bulk string has already been read Close does nothing.
+func (br *BulkReader) Close() error {
+	if br.err != nil {
+		return br.err
+	} else if br.closed {
+		return nil
+	}
+
+	if br.left > 0 {
+		if br.beforeRead != nil {
+			br.beforeRead()
+		}
+		n, err := io.CopyN(ioutil.Discard, br.r, br.left)
+		br.left -= n
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return br.fail(err)
+		}
+	}
+	if err := br.finish(); err != nil {
+		return err
+	}
+	br.closed = true
+	return nil
+}
+
+// finish reads the trailing delimiter, once the body has been fully read
+func (br *BulkReader) finish() error {
+	if !br.trail {
+		return nil
+	}
+	if br.beforeRead != nil {
+		br.beforeRead()
+	}
+	var trail [2]byte
+	if _, err := io.ReadFull(br.r, trail[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return br.fail(err)
+	}
+	br.trail = false
+	return nil
+}
+
+// fail records the given error as the BulkReader's error, which will be
+// returned by all further calls. A failed read leaves the connection in an
+// unknown state, so it can't be used anymore
+func (br *BulkReader) fail(err error) error {
+	br.err = err
+	if br.onErr != nil {
+		br.onErr(err)
+	}
+	return err
+}
+
+// ReadStream reads the next reply off of the io.Reader. If it is a bulk string
+// only its header is read, and a BulkReader which reads the rest of it is
+// returned. Other string replies are read in full and returned as a
+// BulkReader anyway. An error is returned for any other reply, including Nil
+// (ErrRespNil), or if the read fails.
+//
+// Any BulkReader previously returned by ReadStream which hasn't been fully
+// read yet is discarded first, as it is by Read.
+//
+// The MaxBulkLen of the RespReader's limits doesn't apply to bulk strings read
+// this way, since they are never held in memory as a whole.
+func (rr *RespReader) ReadStream() (*BulkReader, error) {
+	br, r := rr.readStream()
+	if r != nil {
+		return bulkReaderFromResp(r)
+	}
+	return br, nil
+}
+
+// readStream is like ReadStream, but if the reply isn't a bulk string it is
+// returned as-is instead of being converted into a BulkReader or error
+func (rr *RespReader) readStream() (*BulkReader, *Resp) {
+	if err := rr.ready(); err != nil {
+		return nil, NewRespIOErr(err)
+	}
+
+	b, err := rr.r.Peek(1)
+	if err != nil {
+		return nil, NewRespIOErr(err)
+	} else if b[0] != bulkStrPrefix[0] {
+		return nil, rr.Read()
+	}
+
+	size, err := readLineInt(rr.r)
+	if err != nil {
+		return nil, NewRespIOErr(err)
+	} else if size < 0 {
+		return nil, &Resp{typ: Nil}
+	}
+
+	rr.stream = newBulkReader(rr.r, size, true)
+	return rr.stream, nil
+}
+
+// discardStream discards the rest of the BulkReader last returned from
+// ReadStream, if there is one
+func (rr *RespReader) discardStream() error {
+	if rr.stream == nil {
+		return nil
+	}
+	br := rr.stream
+	rr.stream = nil
+	err := br.Close()
+	br.closed = true
+	return err
+}
+
+func bulkReaderFromResp(r *Resp) (*BulkReader, error) {
+	b, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return newBulkReader(bytes.NewReader(b), int64(len(b)), false), nil
+}
+
+// StreamArg is a command argument whose value is streamed from an io.Reader,
+// see ReaderArg
+type StreamArg struct {
+	r    io.Reader
+	size int64
+}
+
+// ReaderArg returns a command argument which, rather than being encoded into
+// memory along with the rest of the command, is copied straight from r onto
+// the connection. This is useful for very large values, which would otherwise
+// have to be held in memory in full:
+//
+//	f, err := os.Open("artifact.tar.gz")
+//	// handle err
+//	fi, err := f.Stat()
+//	// handle err
+//	err = client.Cmd("SET", "artifact", redis.ReaderArg(f, fi.Size())).Err
+//
+// Exactly size bytes will be read from r. If r returns an error, or fewer than
+// size bytes, before they've all been written the command can't be completed,
+// so the connection is closed and an IOErr is returned.
+//
+// A StreamArg can only be used once, as r is consumed by writing it. This also
+// means that commands using one can't be retried, e.g. by Cluster when
+// following a redirect. A StreamArg can't be used as a command's key.
+func ReaderArg(r io.Reader, size int64) *StreamArg {
+	return &StreamArg{r: r, size: size}
+}
+
+// MarshalRedisArg implements the ArgMarshaler interface by reading the whole
+// of the argument into memory. It's used when a StreamArg is passed somewhere
+// other than a command, e.g. NewResp.
+func (sa *StreamArg) MarshalRedisArg() ([]byte, error) {
+	b := make([]byte, sa.size)
+	if _, err := io.ReadFull(sa.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// streamWriter is implemented by io.Writers which handle writing StreamArgs
+// themselves, rather than having them copied in by writeTo
+type streamWriter interface {
+	writeStream(*StreamArg) (int64, error)
+}
+
+func writeStreamArg(w io.Writer, buf []byte, sa *StreamArg) (int64, error) {
+	var err error
+	var written int64
+	buf = strconv.AppendInt(buf[:0], sa.size, 10)
+
+	written, err = writeBytesHelper(w, bulkStrPrefix, written, err)
+	written, err = writeBytesHelper(w, buf, written, err)
+	written, err = writeBytesHelper(w, delim, written, err)
+	if err != nil {
+		return written, err
+	}
+
+	var n int64
+	if sw, ok := w.(streamWriter); ok {
+		n, err = sw.writeStream(sa)
+	} else {
+		n, err = io.CopyN(w, sa.r, sa.size)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	written += n
+	if err != nil {
+		return written, err
+	}
+	return writeBytesHelper(w, delim, written, err)
+}
@@ -0,0 +1,203 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadStream(t *T) {
+	buf := bytes.NewBufferString(
+		"$11\r\nhello world\r\n" + // read fully
+			"$3\r\nfoo\r\n" + // read partially then closed
+			"$3\r\nbar\r\n" + // read partially then discarded
+			"+OK\r\n" +
+			"$-1\r\n" +
+			"-ERR bad\r\n" +
+			":1\r\n" +
+			"$0\r\n\r\n" +
+			"$3\r\nbaz",
+	)
+	rr := NewRespReader(buf)
+
+	br, err := rr.ReadStream()
+	require.Nil(t, err)
+	assert.Equal(t, int64(11), br.Len())
+	b, err := ioutil.ReadAll(br)
+	require.Nil(t, err)
+	assert.Equal(t, "hello world", string(b))
+	require.Nil(t, br.Close())
+
+	br, err = rr.ReadStream()
+	require.Nil(t, err)
+	b = make([]byte, 1)
+	_, err = io.ReadFull(br, b)
+	require.Nil(t, err)
+	assert.Equal(t, "f", string(b))
+	require.Nil(t, br.Close())
+	require.Nil(t, br.Close())
+	_, err = br.Read(b)
+	assert.Equal(t, errStreamClosed, err)
+
+	br, err = rr.ReadStream()
+	require.Nil(t, err)
+	_, err = io.ReadFull(br, b)
+	require.Nil(t, err)
+	assert.Equal(t, "b", string(b))
+
+	// the rest of the previous stream is discarded
+	s, err := rr.Read().Str()
+	require.Nil(t, err)
+	assert.Equal(t, "OK", s)
+	_, err = br.Read(b)
+	assert.Equal(t, errStreamClosed, err)
+
+	_, err = rr.ReadStream()
+	assert.Equal(t, ErrRespNil, err)
+	_, err = rr.ReadStream()
+	assert.Equal(t, "ERR bad", err.Error())
+	_, err = rr.ReadStream()
+	assert.Equal(t, errBadType, err)
+
+	br, err = rr.ReadStream()
+	require.Nil(t, err)
+	b, err = ioutil.ReadAll(br)
+	require.Nil(t, err)
+	assert.Len(t, b, 0)
+
+	// a truncated body is an error
+	br, err = rr.ReadStream()
+	require.Nil(t, err)
+	_, err = ioutil.ReadAll(br)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, io.ErrUnexpectedEOF, br.Close())
+}
+
+func assertPong(t *T, c *Client) {
+	s, err := c.Cmd("PING").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", s)
+}
+
+func TestCmdStream(t *T) {
+	big := strings.Repeat("0123456789", 100000)
+	addr := cmdServer(t, func(args []string) *Resp {
+		switch args[0] {
+		case "GET":
+			return NewResp(big)
+		case "PING":
+			return NewRespSimple("PONG")
+		case "ERR":
+			return NewResp(errors.New("ERR bad"))
+		}
+		return NewResp(nil)
+	})
+	c, err := Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+
+	br, err := c.CmdStream("GET", "foo")
+	require.Nil(t, err)
+	assert.Equal(t, int64(len(big)), br.Len())
+	buf := new(bytes.Buffer)
+	_, err = io.Copy(buf, br)
+	require.Nil(t, err)
+	assert.Equal(t, big, buf.String())
+	assertPong(t, c)
+
+	// partially read and closed
+	br, err = c.CmdStream("GET", "foo")
+	require.Nil(t, err)
+	_, err = io.CopyN(ioutil.Discard, br, 1000)
+	require.Nil(t, err)
+	require.Nil(t, br.Close())
+	assertPong(t, c)
+
+	// partially read and abandoned
+	br, err = c.CmdStream("GET", "foo")
+	require.Nil(t, err)
+	_, err = io.CopyN(ioutil.Discard, br, 1000)
+	require.Nil(t, err)
+	assertPong(t, c)
+
+	// Other replies
+	br, err = c.CmdStream("PING")
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(br)
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", string(b))
+	_, err = c.CmdStream("ERR")
+	assert.Equal(t, "ERR bad", err.Error())
+	_, err = c.CmdStream("NIL")
+	assert.Equal(t, ErrRespNil, err)
+	assert.Nil(t, c.LastCritical)
+	assertPong(t, c)
+}
+
+func TestWriteReaderArg(t *T) {
+	buf := new(bytes.Buffer)
+	arg := ReaderArg(strings.NewReader("hello world"), 5)
+	n, err := writeTo(buf, nil, []interface{}{"foo", arg}, true, true, false)
+	require.Nil(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, "$3\r\nfoo\r\n$5\r\nhello\r\n", buf.String())
+
+	// too short
+	arg = ReaderArg(strings.NewReader("hi"), 5)
+	_, err = writeTo(buf, nil, arg, true, true, false)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	b, err := NewResp(ReaderArg(strings.NewReader("hello world"), 5)).Bytes()
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = KeyFromArgs(ReaderArg(strings.NewReader("key"), 3))
+	assert.Equal(t, errBadCmdNoKey, err)
+}
+
+func TestCmdReaderArg(t *T) {
+	m := map[string]string{}
+	addr := cmdServer(t, func(args []string) *Resp {
+		switch args[0] {
+		case "SET":
+			m[args[1]] = args[2]
+			return NewRespSimple("OK")
+		case "GET":
+			return NewResp(m[args[1]])
+		}
+		return NewRespSimple("PONG")
+	})
+	c, err := Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+
+	big := strings.Repeat("0123456789", 100000)
+	require.Nil(t, c.Cmd("SET", "foo", ReaderArg(strings.NewReader(big), int64(len(big)))).Err)
+	s, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, big, s)
+
+	// Multiple streams in a pipeline
+	c.PipeAppend("SET", "a", ReaderArg(strings.NewReader("aaa"), 3))
+	c.PipeAppend("PING")
+	c.PipeAppend("SET", "b", ReaderArg(strings.NewReader("bbb"), 3))
+	c.PipeAppend("GET", "a")
+	c.PipeAppend("GET", "b")
+	for _, expect := range []string{"OK", "PONG", "OK", "aaa", "bbb"} {
+		s, err := c.PipeResp().Str()
+		require.Nil(t, err)
+		assert.Equal(t, expect, s)
+	}
+
+	// A short stream means the command can't be completed
+	r := c.Cmd("SET", "foo", ReaderArg(strings.NewReader("short"), 10))
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, io.ErrUnexpectedEOF, r.Err)
+	assert.Equal(t, io.ErrUnexpectedEOF, c.LastCritical)
+}
@@ -0,0 +1,164 @@
+package redis
+
+import (
+	"errors"
+)
+
+// maxTxAttempts is the number of times Transaction will run a transaction
+// before giving up, if its watched keys keep being changed
+const maxTxAttempts = 16
+
+var (
+	// ErrTxAborted is returned from Transaction when the transaction was
+	// aborted because one of its watched keys was changed, and the same
+	// happened on every retry as well
+	ErrTxAborted = errors.New("transaction aborted, watched keys changed too many times")
+
+	errTxPanic = errors.New("transaction callback panicked")
+)
+
+// Tx is passed into the callback given to Transaction. It's used to read the
+// current state of the watched keys, and then to queue up the commands which
+// make up the transaction itself.
+type Tx struct {
+	c    *Client
+	reqs []request
+	cmds []*PipelineCmd
+}
+
+// Cmd calls the given command immediately, outside of the transaction, and
+// returns its reply. It's meant for reading the values of watched keys, which
+// the queued commands can then be based on.
+func (tx *Tx) Cmd(cmd string, args ...interface{}) *Resp {
+	return tx.c.Cmd(cmd, args...)
+}
+
+// Queue adds the given command to the transaction. None of the queued commands
+// are sent until the callback returns, at which point they are all run
+// atomically, inside MULTI/EXEC. The returned handle can be used to retrieve
+// the command's reply once Transaction has returned.
+func (tx *Tx) Queue(cmd string, args ...interface{}) *PipelineCmd {
+	pc := new(PipelineCmd)
+	tx.reqs = append(tx.reqs, request{cmd, args})
+	tx.cmds = append(tx.cmds, pc)
+	return pc
+}
+
+// Transaction runs a transaction using redis's optimistic locking. It WATCHes
+// the given keys and calls fn, which may read their current values using the
+// Tx's Cmd method and queue up the commands making up the transaction using
+// Queue. Once fn returns the queued commands are run inside MULTI/EXEC. If any
+// of the watched keys were changed in the meantime the transaction is
+// aborted, and the whole thing, including fn, is run again. This is done up
+// to 16 times, after which ErrTxAborted is returned.
+//
+//	var incr *redis.PipelineCmd
+//	err := client.Transaction([]string{"counter"}, func(tx *redis.Tx) error {
+//		n, err := tx.Cmd("GET", "counter").Int()
+//		if err != nil {
+//			return err
+//		}
+//		incr = tx.Queue("SET", "counter", n*2)
+//		return nil
+//	})
+//
+// If fn returns an error the keys are unwatched, nothing is run, and the
+// error is returned. If fn doesn't queue anything nothing is run either.
+//
+// If the transaction was run, the reply to each queued command is available
+// through its handle. If redis refused to run the transaction (e.g. because
+// one of the commands was malformed) its error is returned, and is the reply
+// to all of the queued commands which didn't have an error of their own.
+//
+// The connection is always left as it was found, with no keys being watched
+// and outside of MULTI, so it's safe to put it back in a Pool afterwards. If
+// that can't be ensured (e.g. a network error, or fn panicking) the connection
+// is closed and LastCritical is set, as with any other critical error.
+func (c *Client) Transaction(keys []string, fn func(*Tx) error) error {
+	for i := 0; i < maxTxAttempts; i++ {
+		ok, err := c.tryTransaction(keys, fn)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return ErrTxAborted
+}
+
+// tryTransaction makes a single attempt at running a transaction. It returns
+// false if the transaction was aborted because a watched key was changed
+func (c *Client) tryTransaction(keys []string, fn func(*Tx) error) (bool, error) {
+	if len(keys) > 0 {
+		if err := c.Cmd("WATCH", keys).Err; err != nil {
+			return false, err
+		}
+	}
+
+	tx := &Tx{c: c}
+	var done bool
+	defer func() {
+		if !done {
+			// fn panicked, and who knows what state the connection's in
+			c.LastCritical = errTxPanic
+			c.Close()
+		}
+	}()
+	err := fn(tx)
+	done = true
+
+	if err != nil || len(tx.reqs) == 0 {
+		if len(keys) > 0 && c.LastCritical == nil {
+			if uerr := c.Cmd("UNWATCH").Err; uerr != nil && err == nil {
+				err = uerr
+			}
+		}
+		return true, err
+	}
+
+	reqs := make([]request, 0, len(tx.reqs)+2)
+	reqs = append(reqs, request{cmd: "MULTI"})
+	reqs = append(reqs, tx.reqs...)
+	reqs = append(reqs, request{cmd: "EXEC"})
+	if r := c.writeRequest(reqs...); r != nil {
+		if !r.IsType(IOErr) && len(keys) > 0 {
+			// nothing was sent, the keys are still being watched
+			c.Cmd("UNWATCH")
+		}
+		return true, r.Err
+	}
+
+	// the replies to MULTI and to each queued command, followed by EXEC
+	rr := make([]*Resp, len(reqs))
+	for i := range rr {
+		if rr[i] = c.readReply(reqs[i].cmd); rr[i].IsType(IOErr) {
+			return true, rr[i].Err
+		}
+	}
+	if err := rr[0].Err; err != nil {
+		return true, err
+	}
+
+	execR := rr[len(rr)-1]
+	if execR.IsType(Nil) {
+		return false, nil
+	} else if execR.Err != nil {
+		for i, pc := range tx.cmds {
+			if queuedR := rr[i+1]; queuedR.Err != nil {
+				pc.r = queuedR
+			} else {
+				pc.r = execR
+			}
+		}
+		return true, execR.Err
+	}
+
+	l, err := execR.Array()
+	if err != nil {
+		return true, err
+	} else if len(l) != len(tx.cmds) {
+		return true, errors.New("wrong number of replies from EXEC")
+	}
+	for i, pc := range tx.cmds {
+		pc.r = l[i]
+	}
+	return true, nil
+}
@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// txServer returns the address of a server which implements just enough of
+// GET/SET/WATCH/MULTI/EXEC for testing Transaction, for a single connection.
+// The first `conflicts` EXECs are aborted as if a watched key had been
+// changed. Every command received is sent to cmdCh.
+func txServer(t *T, conflicts int, cmdCh chan<- []string) string {
+	m := map[string]string{}
+	var multi bool
+	var queued [][]string
+	run := func(args []string) *Resp {
+		switch args[0] {
+		case "GET":
+			v, ok := m[args[1]]
+			if !ok {
+				return NewResp(nil)
+			}
+			return NewResp(v)
+		case "SET":
+			m[args[1]] = args[2]
+			return NewRespSimple("OK")
+		}
+		return NewResp(errors.New("ERR unknown command"))
+	}
+
+	return cmdServer(t, func(args []string) *Resp {
+		cmdCh <- args
+		switch args[0] {
+		case "WATCH", "UNWATCH":
+			return NewRespSimple("OK")
+		case "MULTI":
+			multi = true
+			return NewRespSimple("OK")
+		case "EXEC":
+			multi = false
+			q := queued
+			queued = nil
+			if conflicts > 0 {
+				conflicts--
+				return NewResp(nil)
+			}
+			for _, args := range q {
+				if args[0] == "BAD" {
+					return NewResp(errors.New("EXECABORT bad command"))
+				}
+			}
+			rl := make([]*Resp, len(q))
+			for i := range q {
+				rl[i] = run(q[i])
+			}
+			return NewResp(rl)
+		}
+		if multi {
+			queued = append(queued, args)
+			if args[0] == "BAD" {
+				return NewResp(errors.New("ERR unknown command"))
+			}
+			return NewRespSimple("QUEUED")
+		}
+		return run(args)
+	})
+}
+
+func TestTransaction(t *T) {
+	cmdCh := make(chan []string, 100)
+	c, err := Dial("tcp", txServer(t, 2, cmdCh))
+	require.Nil(t, err)
+	defer c.Close()
+
+	assertCmds := func(expected ...[]string) {
+		for _, e := range expected {
+			assert.Equal(t, e, <-cmdCh)
+		}
+		assert.Len(t, cmdCh, 0)
+	}
+
+	var calls int
+	var set, get *PipelineCmd
+	err = c.Transaction([]string{"foo"}, func(tx *Tx) error {
+		calls++
+		n, err := tx.Cmd("GET", "foo").Int()
+		if err != nil && err != ErrRespNil {
+			return err
+		}
+		set = tx.Queue("SET", "foo", n+1)
+		get = tx.Queue("GET", "foo")
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Nil(t, set.Resp().Err)
+	s, err := get.Resp().Str()
+	require.Nil(t, err)
+	assert.Equal(t, "1", s)
+
+	attempt := [][]string{
+		{"WATCH", "foo"}, {"GET", "foo"}, {"MULTI"},
+		{"SET", "foo", "1"}, {"GET", "foo"}, {"EXEC"},
+	}
+	assertCmds(append(append(attempt, attempt...), attempt...)...)
+
+	// An error from the callback unwatches the keys and runs nothing
+	err = c.Transaction([]string{"foo", "bar"}, func(tx *Tx) error {
+		tx.Queue("SET", "foo", "2")
+		return errors.New("nope")
+	})
+	assert.Equal(t, "nope", err.Error())
+	assertCmds([]string{"WATCH", "foo", "bar"}, []string{"UNWATCH"})
+
+	// Errors from redis are on the commands' Resps
+	err = c.Transaction(nil, func(tx *Tx) error {
+		set = tx.Queue("SET", "foo", "3")
+		get = tx.Queue("BAD")
+		return nil
+	})
+	assert.Equal(t, "EXECABORT bad command", err.Error())
+	assert.Equal(t, err, set.Resp().Err)
+	assert.Equal(t, "ERR unknown command", get.Resp().Err.Error())
+	assertCmds([]string{"MULTI"}, []string{"SET", "foo", "3"}, []string{"BAD"}, []string{"EXEC"})
+
+	n, err := c.Cmd("GET", "foo").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assertCmds([]string{"GET", "foo"})
+	assert.Nil(t, c.LastCritical)
+}
+
+func TestTransactionAborted(t *T) {
+	cmdCh := make(chan []string, 1000)
+	c, err := Dial("tcp", txServer(t, maxTxAttempts, cmdCh))
+	require.Nil(t, err)
+	defer c.Close()
+
+	var calls int
+	err = c.Transaction([]string{"foo"}, func(tx *Tx) error {
+		calls++
+		tx.Queue("SET", "foo", strconv.Itoa(calls))
+		return nil
+	})
+	assert.Equal(t, ErrTxAborted, err)
+	assert.Equal(t, maxTxAttempts, calls)
+
+	// A panic closes the connection
+	assert.Panics(t, func() {
+		c.Transaction([]string{"foo"}, func(tx *Tx) error {
+			panic("oh no")
+		})
+	})
+	assert.Equal(t, errTxPanic, c.LastCritical)
+}
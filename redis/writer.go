@@ -0,0 +1,169 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+var errBadSimpleStr = errors.New("simple strings and errors can't contain CR or LF")
+
+var nilArrayFormatted = []byte("*-1\r\n")
+
+// RespWriter is a wrapper around an io.Writer which will write Resp messages
+// to the io.Writer. It's the counterpart to RespReader, for use when writing
+// servers and proxies which speak the redis protocol.
+//
+// Everything written is buffered, Flush must be called for it to actually be
+// written to the io.Writer. Once writing to the io.Writer has failed every
+// method will return the same error.
+type RespWriter struct {
+	w *bufio.Writer
+
+	// used for encoding integers and lengths
+	buf []byte
+}
+
+// NewRespWriter creates and returns a new RespWriter which will write to the
+// given io.Writer. If it's a *bufio.Writer it will be used directly, rather
+// than being wrapped in another
+func NewRespWriter(w io.Writer) *RespWriter {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	return &RespWriter{w: bw, buf: make([]byte, 0, 64)}
+}
+
+// line writes a single line with the given prefix, e.g. a simple string or an
+// array header
+func (rw *RespWriter) line(prefix []byte, b []byte) error {
+	rw.w.Write(prefix)
+	rw.w.Write(b)
+	_, err := rw.w.Write(delim)
+	return err
+}
+
+func (rw *RespWriter) lineInt(prefix []byte, i int64) error {
+	rw.buf = strconv.AppendInt(rw.buf[:0], i, 10)
+	return rw.line(prefix, rw.buf)
+}
+
+// WriteSimpleStr writes a simple string, e.g. "+OK". The string can't contain
+// a CR or LF, use WriteBulkStr for those.
+func (rw *RespWriter) WriteSimpleStr(s string) error {
+	rw.buf = append(rw.buf[:0], s...)
+	if bytes.ContainsAny(rw.buf, "\r\n") {
+		return errBadSimpleStr
+	}
+	return rw.line(simpleStrPrefix, rw.buf)
+}
+
+// WriteError writes an error reply with the message of the given error, e.g.
+// "-ERR unknown command". By convention the message starts with an upper-case
+// prefix indicating the kind of error (see Error). Like with WriteSimpleStr,
+// the message can't contain a CR or LF.
+func (rw *RespWriter) WriteError(err error) error {
+	rw.buf = append(rw.buf[:0], err.Error()...)
+	if bytes.ContainsAny(rw.buf, "\r\n") {
+		return errBadSimpleStr
+	}
+	return rw.line(errPrefix, rw.buf)
+}
+
+// WriteInt writes an integer reply
+func (rw *RespWriter) WriteInt(i int64) error {
+	return rw.lineInt(intPrefix, i)
+}
+
+// WriteBulk writes a bulk string. A nil slice is written as an empty bulk
+// string, use WriteNil for a nil reply.
+func (rw *RespWriter) WriteBulk(b []byte) error {
+	rw.lineInt(bulkStrPrefix, int64(len(b)))
+	rw.w.Write(b)
+	_, err := rw.w.Write(delim)
+	return err
+}
+
+// WriteBulkStr is like WriteBulk, but takes a string
+func (rw *RespWriter) WriteBulkStr(s string) error {
+	rw.lineInt(bulkStrPrefix, int64(len(s)))
+	rw.w.WriteString(s)
+	_, err := rw.w.Write(delim)
+	return err
+}
+
+// WriteNil writes a nil reply ("$-1")
+func (rw *RespWriter) WriteNil() error {
+	_, err := rw.w.Write(nilFormatted)
+	return err
+}
+
+// WriteArrayHeader writes the header of an array with the given number of
+// elements, which must then each be written separately. This allows for
+// streaming arrays whose elements aren't all in memory at once:
+//
+//	rw.WriteArrayHeader(len(keys))
+//	for _, k := range keys {
+//		rw.WriteBulkStr(k)
+//	}
+func (rw *RespWriter) WriteArrayHeader(n int) error {
+	return rw.lineInt(arrayPrefix, int64(n))
+}
+
+// WriteNilArray writes a nil array reply ("*-1"), as is returned by EXEC for
+// an aborted transaction
+func (rw *RespWriter) WriteNilArray() error {
+	_, err := rw.w.Write(nilArrayFormatted)
+	return err
+}
+
+// WriteArray writes an array of bulk strings
+func (rw *RespWriter) WriteArray(l []string) error {
+	err := rw.WriteArrayHeader(len(l))
+	for _, s := range l {
+		err = rw.WriteBulkStr(s)
+	}
+	return err
+}
+
+// WriteValue writes the given value encoded as NewResp would encode it, so
+// strings and byte slices are written as bulk strings, errors as error
+// replies, slices and maps as arrays, and so on.
+func (rw *RespWriter) WriteValue(v interface{}) error {
+	_, err := writeTo(rw.w, rw.buf[:0], v, false, false, false)
+	return err
+}
+
+// WriteCmd writes a command, encoded exactly as a Client would send it (see
+// Cmd), for use when writing clients or proxies. Maps in the arguments always
+// have their keys sorted, as with the Client's SortMapArgs, so that the same
+// arguments always produce the same command.
+func (rw *RespWriter) WriteCmd(cmd string, args ...interface{}) error {
+	rb := newRequestBuf()
+	if err := rb.appendRequest(request{cmd, args}, true); err != nil {
+		return err
+	}
+	_, err := rb.WriteTo(rw.w)
+	return err
+}
+
+// WriteResp writes the given Resp as-is, including any of the RESP3 types and
+// attributes. IOErr Resps are written as error replies.
+func (rw *RespWriter) WriteResp(r *Resp) error {
+	_, err := r.WriteTo(rw.w)
+	return err
+}
+
+// Flush writes any buffered data to the underlying io.Writer
+func (rw *RespWriter) Flush() error {
+	return rw.w.Flush()
+}
+
+// Buffered returns the number of bytes which have been written but not yet
+// flushed
+func (rw *RespWriter) Buffered() int {
+	return rw.w.Buffered()
+}
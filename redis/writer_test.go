@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespWriter(t *T) {
+	buf := new(bytes.Buffer)
+	rw := NewRespWriter(buf)
+
+	assert.Nil(t, rw.WriteSimpleStr("OK"))
+	assert.Nil(t, rw.WriteError(errors.New("ERR bad")))
+	assert.Nil(t, rw.WriteInt(-5))
+	assert.Nil(t, rw.WriteBulk([]byte("foo\r\nbar")))
+	assert.Nil(t, rw.WriteBulk(nil))
+	assert.Nil(t, rw.WriteBulkStr("baz"))
+	assert.Nil(t, rw.WriteNil())
+	assert.Nil(t, rw.WriteNilArray())
+	assert.Nil(t, rw.WriteArray([]string{"a", "bc"}))
+	assert.Nil(t, rw.WriteArrayHeader(2))
+	assert.Nil(t, rw.WriteInt(1))
+	assert.Nil(t, rw.WriteArrayHeader(1))
+	assert.Nil(t, rw.WriteBulkStr("x"))
+	assert.Nil(t, rw.WriteValue(map[string]int{"a": 1}))
+	assert.Nil(t, rw.WriteResp(NewRespSimple("PONG")))
+
+	// Nothing is written until Flush
+	assert.Equal(t, 0, buf.Len())
+	assert.NotZero(t, rw.Buffered())
+	require.Nil(t, rw.Flush())
+	assert.Zero(t, rw.Buffered())
+
+	expected := "+OK\r\n" +
+		"-ERR bad\r\n" +
+		":-5\r\n" +
+		"$8\r\nfoo\r\nbar\r\n" +
+		"$0\r\n\r\n" +
+		"$3\r\nbaz\r\n" +
+		"$-1\r\n" +
+		"*-1\r\n" +
+		"*2\r\n$1\r\na\r\n$2\r\nbc\r\n" +
+		"*2\r\n:1\r\n*1\r\n$1\r\nx\r\n" +
+		"*2\r\n$1\r\na\r\n:1\r\n" +
+		"+PONG\r\n"
+	assert.Equal(t, expected, buf.String())
+
+	// Everything written can be read back
+	rr := NewRespReader(buf)
+	for _, expected := range []*Resp{
+		NewRespSimple("OK"),
+		NewResp(&Error{Msg: "ERR bad", Prefix: "ERR"}),
+		NewResp(-5),
+		NewResp([]byte("foo\r\nbar")),
+		NewResp([]byte{}),
+		NewResp([]byte("baz")),
+		NewResp(nil),
+		NewResp(nil),
+		NewResp([]string{"a", "bc"}),
+		NewResp([]interface{}{1, []string{"x"}}),
+		NewResp([]interface{}{"a", 1}),
+		NewRespSimple("PONG"),
+	} {
+		r := rr.Read()
+		assert.Equal(t, expected.String(), r.String())
+	}
+
+	// CR and LF aren't allowed in simple strings or errors
+	assert.Equal(t, errBadSimpleStr, rw.WriteSimpleStr("foo\r\n+bar"))
+	assert.Equal(t, errBadSimpleStr, rw.WriteError(errors.New("ERR\nbad")))
+	assert.Zero(t, rw.Buffered())
+}
+
+func TestRespWriterCmd(t *T) {
+	buf := new(bytes.Buffer)
+	rw := NewRespWriter(buf)
+	m := map[string]int{"c": 3, "a": 1, "d": 4, "b": 2, "e": 5}
+	require.Nil(t, rw.WriteCmd("HSET", "h", m, nil, []byte("x")))
+	require.Nil(t, rw.Flush())
+
+	// Written the same as a Client would write it, with the map sorted
+	rb := newRequestBuf()
+	require.Nil(t, rb.appendRequest(request{
+		cmd: "HSET", args: []interface{}{"h", m, nil, []byte("x")},
+	}, true))
+	assert.Equal(t, string(rb.buf), buf.String())
+
+	args, err := NewRespReader(buf).ReadRequest()
+	require.Nil(t, err)
+	assert.Equal(t, byteArgs(
+		"HSET", "h", "a", "1", "b", "2", "c", "3", "d", "4", "e", "5", "", "x",
+	), args)
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestRespWriterErr(t *T) {
+	// Using a small buffer so writes go through to the failing writer
+	bw := bufio.NewWriterSize(failWriter{}, 16)
+	rw := NewRespWriter(bw)
+	assert.Nil(t, rw.WriteSimpleStr("OK"))
+	assert.NotNil(t, rw.WriteBulkStr("this is longer than the buffer"))
+
+	// The error is sticky
+	assert.NotNil(t, rw.WriteInt(1))
+	assert.NotNil(t, rw.Flush())
+}
@@ -0,0 +1,334 @@
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kevwan/radix.v2/cluster"
+)
+
+// Cluster is a fake redis cluster made up of a number of Fakes, each listening
+// on its own port. The nodes answer CLUSTER SLOTS and reply to commands for
+// keys in slots they don't own with MOVED, or ASK while a slot is being
+// migrated, just as a real cluster does. Slots can be moved and nodes added
+// or killed at any point, to test how clients cope with the cluster's
+// topology changing.
+//
+// Each node only has a single database, as in a real cluster, and there are
+// no replicas.
+type Cluster struct {
+	// mu protects everything below. It's held for the duration of every
+	// command run on any of the nodes, and is always taken before the lock on
+	// any individual Fake.
+	mu    sync.Mutex
+	nodes map[string]*Fake
+	slots [cluster.NumSlots]*Fake
+
+	// the nodes slots are being migrated to
+	migrating map[uint16]*Fake
+}
+
+// StartCluster starts a Cluster of n nodes, with the slots split evenly
+// between them in the order given by Addrs
+func StartCluster(n int) (*Cluster, error) {
+	if n < 1 {
+		return nil, errors.New("redistest: a cluster needs at least one node")
+	}
+	cl := &Cluster{
+		nodes:     map[string]*Fake{},
+		migrating: map[uint16]*Fake{},
+	}
+	nodes := make([]*Fake, n)
+	for i := range nodes {
+		f, err := cl.AddNode()
+		if err != nil {
+			cl.Close()
+			return nil, err
+		}
+		nodes[i] = f
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Addr() < nodes[j].Addr()
+	})
+	for i := range cl.slots {
+		cl.slots[i] = nodes[((i+1)*n-1)/cluster.NumSlots]
+	}
+	return cl, nil
+}
+
+// Addr returns the address of one of the nodes in the Cluster, for passing to
+// cluster.New
+func (cl *Cluster) Addr() string {
+	return cl.Addrs()[0]
+}
+
+// Addrs returns the addresses of all nodes in the Cluster, including any which
+// have been killed but still own slots, sorted
+func (cl *Cluster) Addrs() []string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	addrs := make([]string, 0, len(cl.nodes))
+	for addr := range cl.nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Node returns the node with the given address, or nil if there isn't one
+func (cl *Cluster) Node(addr string) *Fake {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.nodes[addr]
+}
+
+// AddrForSlot returns the address of the node which owns the given slot, or an
+// empty string if no node does
+func (cl *Cluster) AddrForSlot(slot uint16) string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if f := cl.slots[slot]; f != nil {
+		return f.Addr()
+	}
+	return ""
+}
+
+// AddNode starts a new node and adds it to the Cluster. It doesn't own any
+// slots until some are moved to it using MoveSlots.
+func (cl *Cluster) AddNode() (*Fake, error) {
+	f, err := Start()
+	if err != nil {
+		return nil, err
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	f.cluster = cl
+	cl.nodes[f.Addr()] = f
+	return f, nil
+}
+
+// KillNode closes the node with the given address, as if it had crashed. Any
+// slots it owns are still assigned to it (so CLUSTER SLOTS will still return
+// it) until they're moved to another node using MoveSlots.
+func (cl *Cluster) KillNode(addr string) error {
+	f := cl.Node(addr)
+	if f == nil {
+		return fmt.Errorf("redistest: unknown node %q", addr)
+	}
+	return f.Close()
+}
+
+func (cl *Cluster) node(addr string) (*Fake, error) {
+	f := cl.nodes[addr]
+	if f == nil {
+		return nil, fmt.Errorf("redistest: unknown node %q", addr)
+	}
+	return f, nil
+}
+
+// MoveSlots moves all slots from start to end (inclusive) to the node with the
+// given address, along with all keys in them. Any migrations of the slots
+// which are in progress are finished. Keys are moved even if the node owning
+// them has been killed, as if a replica of it had been promoted in its place.
+func (cl *Cluster) MoveSlots(start, end uint16, addr string) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	to, err := cl.node(addr)
+	if err != nil {
+		return err
+	}
+	for slot := int(start); slot <= int(end); slot++ {
+		if from := cl.slots[slot]; from != nil && from != to {
+			moveKeys(from, to, func(key string) bool {
+				return cluster.Slot(key) == uint16(slot)
+			})
+		}
+		cl.slots[slot] = to
+		delete(cl.migrating, uint16(slot))
+	}
+	return nil
+}
+
+// StartMigration marks the given slot as being migrated to the node with the
+// given address. Until the migration is finished using MoveSlots the slot's
+// owner replies to commands for keys it doesn't have with ASK, and the node
+// being migrated to accepts commands preceded by ASKING. Individual keys can
+// be moved in the meantime using MigrateKey.
+func (cl *Cluster) StartMigration(slot uint16, addr string) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	to, err := cl.node(addr)
+	if err != nil {
+		return err
+	} else if cl.slots[slot] == to {
+		return fmt.Errorf("redistest: slot %d is already owned by %q", slot, addr)
+	}
+	cl.migrating[slot] = to
+	return nil
+}
+
+// MigrateKey moves the given key to the node its slot is being migrated to,
+// see StartMigration
+func (cl *Cluster) MigrateKey(key string) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	slot := cluster.Slot(key)
+	to, ok := cl.migrating[slot]
+	if !ok {
+		return fmt.Errorf("redistest: slot %d isn't being migrated", slot)
+	}
+	moveKeys(cl.slots[slot], to, func(k string) bool { return k == key })
+	return nil
+}
+
+// moveKeys moves the keys for which fn returns true from one node to another.
+// The Cluster's lock must be held.
+func moveKeys(from, to *Fake, fn func(key string) bool) {
+	from.mu.Lock()
+	defer from.mu.Unlock()
+	to.mu.Lock()
+	defer to.mu.Unlock()
+	fromDB, toDB := from.dbs[0], to.dbs[0]
+	for key, e := range fromDB.keys {
+		if !fn(key) {
+			continue
+		}
+		fromDB.del(key)
+		toDB.keys[key] = e
+		toDB.touch(key)
+	}
+}
+
+// Close closes all nodes in the Cluster
+func (cl *Cluster) Close() error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var err error
+	for _, f := range cl.nodes {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// route checks whether the command can be run on the node it was sent to,
+// writing a redirect or error and returning false if it can't. The Cluster's
+// lock must be held.
+func (cl *Cluster) route(c *cmd, ci cmdInfo) bool {
+	asking := c.cs.asking
+	c.cs.asking = false
+	if ci.keys == nil {
+		return true
+	}
+	keys := ci.keys(c.args)
+	if len(keys) == 0 {
+		return true
+	}
+
+	slot := cluster.Slot(string(keys[0]))
+	for _, key := range keys[1:] {
+		if cluster.Slot(string(key)) != slot {
+			c.writeErr("CROSSSLOT Keys in request don't hash to the same slot")
+			return false
+		}
+	}
+
+	owner, to := cl.slots[slot], cl.migrating[slot]
+	switch {
+	case owner == nil:
+		c.writeErr("CLUSTERDOWN Hash slot not served")
+		return false
+	case owner == c.f && to != nil:
+		// keys which have already been migrated, or which don't exist yet,
+		// are handled by the node being migrated to
+		for _, key := range keys {
+			if c.db().get(string(key), c.now) == nil {
+				c.writeErr(redirect("ASK", slot, to))
+				return false
+			}
+		}
+		return true
+	case owner == c.f, to == c.f && asking:
+		return true
+	}
+	c.writeErr(redirect("MOVED", slot, owner))
+	return false
+}
+
+func redirect(prefix string, slot uint16, f *Fake) string {
+	return prefix + " " + strconv.Itoa(int(slot)) + " " + f.Addr()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"CLUSTER": {fn: cmdCluster, arity: -2},
+		"ASKING":  {fn: cmdAsking, arity: 1},
+	})
+}
+
+const errNoCluster = "ERR This instance has cluster support disabled"
+
+func cmdAsking(c *cmd) {
+	if c.f.cluster == nil {
+		c.writeErr(errNoCluster)
+		return
+	}
+	c.cs.asking = true
+	cmdOK(c)
+}
+
+func cmdCluster(c *cmd) {
+	cl := c.f.cluster
+	if cl == nil {
+		c.writeErr(errNoCluster)
+		return
+	}
+
+	switch sub := strings.ToUpper(c.str(0)); {
+	case sub == "SLOTS" && len(c.args) == 1:
+		cl.writeSlots(c)
+	case sub == "KEYSLOT" && len(c.args) == 2:
+		c.w.WriteInt(int64(cluster.Slot(c.str(1))))
+	default:
+		c.writeErr("ERR Unknown subcommand or wrong number of arguments for '" +
+			c.str(0) + "'")
+	}
+}
+
+// writeSlots writes the reply to CLUSTER SLOTS
+func (cl *Cluster) writeSlots(c *cmd) {
+	type slotRange struct {
+		start, end int
+		f          *Fake
+	}
+	var ranges []slotRange
+	for i, f := range cl.slots {
+		if f == nil {
+			continue
+		} else if l := len(ranges); l > 0 && ranges[l-1].f == f && ranges[l-1].end == i-1 {
+			ranges[l-1].end = i
+			continue
+		}
+		ranges = append(ranges, slotRange{start: i, end: i, f: f})
+	}
+
+	c.w.WriteArrayHeader(len(ranges))
+	for _, r := range ranges {
+		host, portStr, _ := net.SplitHostPort(r.f.Addr())
+		port, _ := strconv.ParseInt(portStr, 10, 64)
+		c.w.WriteArrayHeader(3)
+		c.w.WriteInt(int64(r.start))
+		c.w.WriteInt(int64(r.end))
+		c.w.WriteArrayHeader(2)
+		c.w.WriteBulkStr(host)
+		c.w.WriteInt(port)
+	}
+}
@@ -0,0 +1,146 @@
+package redistest
+
+import (
+	"errors"
+	"strconv"
+	. "testing"
+
+	"github.com/kevwan/radix.v2/cluster"
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCluster(t *T, n int) *Cluster {
+	cl, err := StartCluster(n)
+	require.Nil(t, err)
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func dialNode(t *T, addr string) *redis.Client {
+	c, err := redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// keyForSlot returns a key which hashes to the given slot
+func keyForSlot(slot uint16) string {
+	for i := 0; ; i++ {
+		if k := "key" + strconv.Itoa(i); cluster.Slot(k) == slot {
+			return k
+		}
+	}
+}
+
+func assertRedirect(t *T, r *redis.Resp, prefix string, slot uint16, addr string) {
+	t.Helper()
+	var rerr *redis.Error
+	require.True(t, errors.As(r.Err, &rerr), "err:%v", r.Err)
+	assert.Equal(t, prefix, rerr.Prefix)
+	assert.Equal(t, int(slot), rerr.Slot)
+	assert.Equal(t, addr, rerr.Addr)
+}
+
+func TestClusterSlots(t *T) {
+	cl := testCluster(t, 3)
+	addrs := cl.Addrs()
+	require.Len(t, addrs, 3)
+
+	c := dialNode(t, addrs[0])
+	var slots [][]interface{}
+	require.Nil(t, c.Cmd("CLUSTER", "SLOTS").Unmarshal(&slots))
+	require.Len(t, slots, 3)
+	assert.Equal(t, int64(0), slots[0][0])
+	assert.Equal(t, int64(5460), slots[0][1])
+	assert.Equal(t, int64(5461), slots[1][0])
+	assert.Equal(t, int64(cluster.NumSlots-1), slots[2][1])
+	for i := range slots {
+		hostPort := slots[i][2].([]interface{})
+		port := strconv.FormatInt(hostPort[1].(int64), 10)
+		assert.Equal(t, addrs[i], hostPort[0].(string)+":"+port)
+	}
+
+	assertCmd(t, c, int64(cluster.Slot("foo")), "CLUSTER", "KEYSLOT", "foo")
+	assertErr(t, c, "ERR SELECT is not allowed in cluster mode", "SELECT", 1)
+
+	// a Fake which isn't part of a cluster doesn't support it
+	_, c2 := testClient(t)
+	assertErr(t, c2, "ERR This instance has cluster support disabled",
+		"CLUSTER", "SLOTS")
+}
+
+func TestClusterMoved(t *T) {
+	cl := testCluster(t, 2)
+	addrs := cl.Addrs()
+	c1, c2 := dialNode(t, addrs[0]), dialNode(t, addrs[1])
+
+	k := keyForSlot(0)
+	assertCmd(t, c1, "OK", "SET", k, "foo")
+	assertRedirect(t, c2.Cmd("GET", k), "MOVED", 0, addrs[0])
+
+	// commands without keys can go anywhere, those with keys in different
+	// slots can't
+	assertCmd(t, c2, "PONG", "PING")
+	assertErr(t, c1, "CROSSSLOT Keys in request don't hash to the same slot",
+		"MGET", k, keyForSlot(1))
+	assertCmd(t, c1, []interface{}{nil, "foo"},
+		"MGET", "{"+k+"}", k)
+
+	require.Nil(t, cl.MoveSlots(0, 0, addrs[1]))
+	assert.Equal(t, addrs[1], cl.AddrForSlot(0))
+	assertRedirect(t, c1.Cmd("GET", k), "MOVED", 0, addrs[1])
+	assertCmd(t, c2, "foo", "GET", k)
+}
+
+func TestClusterAsk(t *T) {
+	cl := testCluster(t, 2)
+	addrs := cl.Addrs()
+	c1, c2 := dialNode(t, addrs[0]), dialNode(t, addrs[1])
+
+	slot := uint16(1000)
+	tag := "{" + keyForSlot(slot) + "}"
+	ka, kb := tag+"1", tag+"2"
+	assertCmd(t, c1, "OK", "MSET", ka, "1", kb, "2")
+
+	require.Nil(t, cl.StartMigration(slot, addrs[1]))
+	require.Nil(t, cl.MigrateKey(ka))
+
+	// keys which have been migrated get ASK, the others are still served
+	assertRedirect(t, c1.Cmd("GET", ka), "ASK", slot, addrs[1])
+	assertCmd(t, c1, "2", "GET", kb)
+
+	// the node being migrated to only serves the slot after ASKING
+	assertRedirect(t, c2.Cmd("GET", ka), "MOVED", slot, addrs[0])
+	assertCmd(t, c2, "OK", "ASKING")
+	assertCmd(t, c2, "1", "GET", ka)
+	assertRedirect(t, c2.Cmd("GET", ka), "MOVED", slot, addrs[0])
+
+	require.Nil(t, cl.MoveSlots(slot, slot, addrs[1]))
+	assertCmd(t, c2, []interface{}{"1", "2"}, "MGET", ka, kb)
+	assertRedirect(t, c1.Cmd("GET", kb), "MOVED", slot, addrs[1])
+}
+
+func TestClusterNodes(t *T) {
+	cl := testCluster(t, 1)
+	addr := cl.Addr()
+	c1 := dialNode(t, addr)
+	k := keyForSlot(100)
+	assertCmd(t, c1, "OK", "SET", k, "foo")
+
+	f, err := cl.AddNode()
+	require.Nil(t, err)
+	assert.Len(t, cl.Addrs(), 2)
+	assert.Equal(t, f, cl.Node(f.Addr()))
+
+	// the old node's data survives it being killed, as if it had failed over
+	// to a replica
+	require.Nil(t, cl.KillNode(addr))
+	assert.True(t, c1.Cmd("PING").IsType(redis.IOErr))
+	require.Nil(t, cl.MoveSlots(0, cluster.NumSlots-1, f.Addr()))
+
+	c2 := dialNode(t, f.Addr())
+	assertCmd(t, c2, "foo", "GET", k)
+	assert.Nil(t, cl.Close())
+}
@@ -0,0 +1,469 @@
+package redistest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type entry struct {
+	// one of []byte, hashVal, *listVal, setVal or zsetVal
+	val interface{}
+
+	// zero if the key doesn't expire
+	expireAt time.Time
+}
+
+type hashVal map[string][]byte
+
+type listVal struct {
+	l [][]byte
+}
+
+type setVal map[string]struct{}
+
+type zsetVal map[string]float64
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case []byte:
+		return "string"
+	case hashVal:
+		return "hash"
+	case *listVal:
+		return "list"
+	case setVal:
+		return "set"
+	case zsetVal:
+		return "zset"
+	}
+	return "none"
+}
+
+// db is a single database of keys
+type db struct {
+	keys map[string]*entry
+
+	// every key has a version which is incremented whenever the key is
+	// modified, and the db as a whole has one which is incremented when it's
+	// flushed. These are used to implement WATCH.
+	versions map[string]uint64
+	epoch    uint64
+}
+
+func newDB() *db {
+	return &db{
+		keys:     map[string]*entry{},
+		versions: map[string]uint64{},
+	}
+}
+
+// get returns the entry for the given key, or nil if it doesn't exist (or has
+// expired, in which case it's removed)
+func (d *db) get(key string, now time.Time) *entry {
+	e, ok := d.keys[key]
+	if !ok {
+		return nil
+	} else if !e.expireAt.IsZero() && !now.Before(e.expireAt) {
+		d.del(key)
+		return nil
+	}
+	return e
+}
+
+// set sets the value of the given key, clearing any TTL it had
+func (d *db) set(key string, val interface{}) {
+	d.keys[key] = &entry{val: val}
+	d.touch(key)
+}
+
+// put adds a new key with the given value, which must not already exist, and
+// returns its entry
+func (d *db) put(key string, val interface{}) *entry {
+	e := &entry{val: val}
+	d.keys[key] = e
+	d.touch(key)
+	return e
+}
+
+func (d *db) del(key string) bool {
+	if _, ok := d.keys[key]; !ok {
+		return false
+	}
+	delete(d.keys, key)
+	d.touch(key)
+	return true
+}
+
+// touch marks the key as having been modified
+func (d *db) touch(key string) {
+	d.versions[key]++
+}
+
+func (d *db) flush() {
+	d.keys = map[string]*entry{}
+	d.epoch++
+}
+
+// liveKeys returns all keys which haven't expired, sorted
+func (d *db) liveKeys(now time.Time) []string {
+	keys := make([]string, 0, len(d.keys))
+	for k := range d.keys {
+		if d.get(k, now) != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// removeIfEmpty removes the key if its value is an empty container, as redis
+// does
+func (d *db) removeIfEmpty(key string, e *entry) {
+	var l int
+	switch v := e.val.(type) {
+	case hashVal:
+		l = len(v)
+	case *listVal:
+		l = len(v.l)
+	case setVal:
+		l = len(v)
+	case zsetVal:
+		l = len(v)
+	default:
+		return
+	}
+	if l == 0 {
+		d.del(key)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"PING":     {fn: cmdPing, arity: -1, subOK: true},
+		"ECHO":     {fn: cmdEcho, arity: 2},
+		"SELECT":   {fn: cmdSelect, arity: 2},
+		"QUIT":     {fn: cmdQuit, arity: 1, subOK: true},
+		"AUTH":     {fn: cmdOK, arity: -2},
+		"CLIENT":   {fn: cmdOK, arity: -2},
+		"FLUSHDB":  {fn: cmdFlushDB, arity: -1},
+		"FLUSHALL": {fn: cmdFlushAll, arity: -1},
+		"DBSIZE":   {fn: cmdDBSize, arity: 1},
+		"TIME":     {fn: cmdTime, arity: 1},
+
+		"DEL":       {fn: cmdDel, arity: -2, keys: allKeys},
+		"UNLINK":    {fn: cmdDel, arity: -2, keys: allKeys},
+		"EXISTS":    {fn: cmdExists, arity: -2, keys: allKeys},
+		"TYPE":      {fn: cmdType, arity: 2, keys: firstKey},
+		"KEYS":      {fn: cmdKeys, arity: 2},
+		"RENAME":    {fn: cmdRename, arity: 3, keys: allKeys},
+		"EXPIRE":    {fn: cmdExpire, arity: 3, keys: firstKey},
+		"PEXPIRE":   {fn: cmdExpire, arity: 3, keys: firstKey},
+		"EXPIREAT":  {fn: cmdExpire, arity: 3, keys: firstKey},
+		"PEXPIREAT": {fn: cmdExpire, arity: 3, keys: firstKey},
+		"TTL":       {fn: cmdTTL, arity: 2, keys: firstKey},
+		"PTTL":      {fn: cmdTTL, arity: 2, keys: firstKey},
+		"PERSIST":   {fn: cmdPersist, arity: 2, keys: firstKey},
+		"SCAN":      {fn: cmdScan, arity: -2},
+	})
+}
+
+func cmdOK(c *cmd) {
+	c.w.WriteSimpleStr("OK")
+}
+
+func cmdPing(c *cmd) {
+	if c.cs.subscribed() {
+		var msg string
+		if len(c.args) > 0 {
+			msg = c.str(0)
+		}
+		c.w.WriteArrayHeader(2)
+		c.w.WriteBulkStr("pong")
+		c.w.WriteBulkStr(msg)
+	} else if len(c.args) == 0 {
+		c.w.WriteSimpleStr("PONG")
+	} else if len(c.args) == 1 {
+		c.w.WriteBulk(c.args[0])
+	} else {
+		c.writeErr("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func cmdEcho(c *cmd) {
+	c.w.WriteBulk(c.args[0])
+}
+
+func cmdSelect(c *cmd) {
+	i, err := strconv.Atoi(c.str(0))
+	if c.f.cluster != nil {
+		c.writeErr("ERR SELECT is not allowed in cluster mode")
+		return
+	} else if err != nil {
+		c.writeErr(errNotInt)
+		return
+	} else if i < 0 || i >= numDBs {
+		c.writeErr(errDBOutOfRange)
+		return
+	}
+	c.cs.db = i
+	cmdOK(c)
+}
+
+func cmdQuit(c *cmd) {
+	c.cs.conn.CloseAfterReply()
+	cmdOK(c)
+}
+
+func cmdFlushDB(c *cmd) {
+	c.db().flush()
+	cmdOK(c)
+}
+
+func cmdFlushAll(c *cmd) {
+	for _, d := range c.f.dbs {
+		d.flush()
+	}
+	cmdOK(c)
+}
+
+func cmdDBSize(c *cmd) {
+	c.w.WriteInt(int64(len(c.db().liveKeys(c.now))))
+}
+
+func cmdTime(c *cmd) {
+	us := c.now.UnixNano() / 1e3
+	c.w.WriteArray([]string{
+		strconv.FormatInt(us/1e6, 10), strconv.FormatInt(us%1e6, 10),
+	})
+}
+
+func cmdDel(c *cmd) {
+	var n int64
+	for i := range c.args {
+		if c.db().get(c.str(i), c.now) != nil && c.db().del(c.str(i)) {
+			n++
+		}
+	}
+	c.w.WriteInt(n)
+}
+
+func cmdExists(c *cmd) {
+	var n int64
+	for i := range c.args {
+		if c.db().get(c.str(i), c.now) != nil {
+			n++
+		}
+	}
+	c.w.WriteInt(n)
+}
+
+func cmdType(c *cmd) {
+	var t string
+	if e := c.db().get(c.str(0), c.now); e != nil {
+		t = typeName(e.val)
+	} else {
+		t = "none"
+	}
+	c.w.WriteSimpleStr(t)
+}
+
+func cmdKeys(c *cmd) {
+	var keys []string
+	for _, k := range c.db().liveKeys(c.now) {
+		if globMatch(c.str(0), k) {
+			keys = append(keys, k)
+		}
+	}
+	c.w.WriteArray(keys)
+}
+
+func cmdRename(c *cmd) {
+	from, to := c.str(0), c.str(1)
+	e := c.db().get(from, c.now)
+	if e == nil {
+		c.writeErr(errNoSuchKey)
+		return
+	}
+	c.db().del(from)
+	c.db().keys[to] = e
+	c.db().touch(to)
+	cmdOK(c)
+}
+
+func cmdExpire(c *cmd) {
+	n, ok := c.int(1)
+	if !ok {
+		return
+	}
+	var at time.Time
+	switch c.name {
+	case "EXPIRE":
+		at = c.now.Add(time.Duration(n) * time.Second)
+	case "PEXPIRE":
+		at = c.now.Add(time.Duration(n) * time.Millisecond)
+	case "EXPIREAT":
+		at = time.Unix(n, 0)
+	case "PEXPIREAT":
+		at = time.Unix(0, n*int64(time.Millisecond))
+	}
+
+	key := c.str(0)
+	e := c.db().get(key, c.now)
+	if e == nil {
+		c.w.WriteInt(0)
+		return
+	}
+	if !at.After(c.now) {
+		c.db().del(key)
+	} else {
+		e.expireAt = at
+		c.db().touch(key)
+	}
+	c.w.WriteInt(1)
+}
+
+func cmdTTL(c *cmd) {
+	e := c.db().get(c.str(0), c.now)
+	if e == nil {
+		c.w.WriteInt(-2)
+		return
+	} else if e.expireAt.IsZero() {
+		c.w.WriteInt(-1)
+		return
+	}
+	ttl := e.expireAt.Sub(c.now)
+	if c.name == "TTL" {
+		// redis rounds to the nearest second
+		c.w.WriteInt(int64((ttl + 500*time.Millisecond) / time.Second))
+	} else {
+		c.w.WriteInt(int64(ttl / time.Millisecond))
+	}
+}
+
+func cmdPersist(c *cmd) {
+	key := c.str(0)
+	e := c.db().get(key, c.now)
+	if e == nil || e.expireAt.IsZero() {
+		c.w.WriteInt(0)
+		return
+	}
+	e.expireAt = time.Time{}
+	c.db().touch(key)
+	c.w.WriteInt(1)
+}
+
+// scanOpts are the options shared by SCAN, HSCAN, SSCAN and ZSCAN
+type scanOpts struct {
+	cursor int
+	match  string
+	count  int
+	typ    string
+}
+
+// parseScan parses the cursor and options of a SCAN command starting at the
+// given argument, writing an error and returning false if they're invalid
+func (c *cmd) parseScan(i int) (scanOpts, bool) {
+	o := scanOpts{match: "*", count: 10}
+	cursor, err := strconv.Atoi(c.str(i))
+	if err != nil || cursor < 0 {
+		c.writeErr("ERR invalid cursor")
+		return o, false
+	}
+	o.cursor = cursor
+
+	for i++; i < len(c.args); i += 2 {
+		if i+1 >= len(c.args) {
+			c.writeErr(errSyntax)
+			return o, false
+		}
+		switch strings.ToUpper(c.str(i)) {
+		case "MATCH":
+			o.match = c.str(i + 1)
+		case "COUNT":
+			n, err := strconv.Atoi(c.str(i + 1))
+			if err != nil {
+				c.writeErr(errNotInt)
+				return o, false
+			} else if n < 1 {
+				c.writeErr(errSyntax)
+				return o, false
+			}
+			o.count = n
+		case "TYPE":
+			if c.name != "SCAN" {
+				c.writeErr(errSyntax)
+				return o, false
+			}
+			o.typ = strings.ToLower(c.str(i + 1))
+		default:
+			c.writeErr(errSyntax)
+			return o, false
+		}
+	}
+	return o, true
+}
+
+// writeScan writes the reply to a SCAN command over the given sorted
+// elements. The cursor is simply an index into them. If pairs is set the
+// elements are key/value pairs, of which only the keys are matched, and the
+// cursor indexes pairs.
+func (c *cmd) writeScan(o scanOpts, elems []string, pairs bool, keep func(string) bool) {
+	stride := 1
+	if pairs {
+		stride = 2
+	}
+	n := len(elems) / stride
+
+	start := o.cursor
+	if start > n {
+		start = n
+	}
+	end := start + o.count
+	next := end
+	if end >= n {
+		end, next = n, 0
+	}
+
+	var matched []string
+	for i := start; i < end; i++ {
+		k := elems[i*stride]
+		if !globMatch(o.match, k) || (keep != nil && !keep(k)) {
+			continue
+		}
+		matched = append(matched, elems[i*stride:i*stride+stride]...)
+	}
+
+	c.w.WriteArrayHeader(2)
+	c.w.WriteBulkStr(strconv.Itoa(next))
+	c.w.WriteArray(matched)
+}
+
+func cmdScan(c *cmd) {
+	o, ok := c.parseScan(0)
+	if !ok {
+		return
+	}
+	var keep func(string) bool
+	if o.typ != "" {
+		keep = func(k string) bool {
+			return typeName(c.db().keys[k].val) == o.typ
+		}
+	}
+	c.writeScan(o, c.db().liveKeys(c.now), false, keep)
+}
+
+// lookup returns the entry for the given key, or nil if it doesn't exist. If
+// it exists but doesn't hold a value of the given type WRONGTYPE is written
+// and false returned.
+func (c *cmd) lookup(key, typ string) (*entry, bool) {
+	e := c.db().get(key, c.now)
+	if e != nil && typeName(e.val) != typ {
+		c.writeErr(errWrongType)
+		return nil, false
+	}
+	return e, true
+}
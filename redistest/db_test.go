@@ -0,0 +1,106 @@
+package redistest
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeys(t *T) {
+	_, c := testClient(t)
+	require.Nil(t, c.Cmd("MSET", "foo", "1", "bar", "2", "baz", "3").Err)
+	require.Nil(t, c.Cmd("LPUSH", "list", "a").Err)
+
+	assertCmd(t, c, int64(4), "DBSIZE")
+	assertCmd(t, c, int64(2), "EXISTS", "foo", "bar", "nope")
+	assertCmd(t, c, "string", "TYPE", "foo")
+	assertCmd(t, c, "list", "TYPE", "list")
+	assertCmd(t, c, "none", "TYPE", "nope")
+	assertCmd(t, c, []interface{}{"bar", "baz"}, "KEYS", "ba*")
+
+	assertCmd(t, c, "OK", "RENAME", "foo", "qux")
+	assertCmd(t, c, "1", "GET", "qux")
+	assertErr(t, c, "ERR no such key", "RENAME", "foo", "qux")
+
+	assertCmd(t, c, int64(2), "DEL", "qux", "bar", "nope")
+	assertCmd(t, c, []interface{}{"baz", "list"}, "KEYS", "*")
+}
+
+func TestSelect(t *T) {
+	f, c := testClient(t)
+	require.Nil(t, c.Cmd("SET", "foo", "0").Err)
+	assertCmd(t, c, "OK", "SELECT", 1)
+	assertCmd(t, c, nil, "GET", "foo")
+	require.Nil(t, c.Cmd("SET", "foo", "1").Err)
+	assertErr(t, c, "ERR DB index is out of range", "SELECT", 16)
+
+	// the selected db is per-connection
+	c2 := f.Client()
+	defer c2.Close()
+	assertCmd(t, c2, "0", "GET", "foo")
+
+	assertCmd(t, c, "OK", "FLUSHDB")
+	assertCmd(t, c, int64(0), "DBSIZE")
+	assertCmd(t, c2, int64(1), "DBSIZE")
+}
+
+func TestExpire(t *T) {
+	f, c := testClient(t)
+	now := time.Unix(1500000000, 0)
+	f.SetTime(now)
+
+	require.Nil(t, c.Cmd("MSET", "a", "1", "b", "2", "c", "3", "d", "4").Err)
+	assertCmd(t, c, int64(-1), "TTL", "a")
+	assertCmd(t, c, int64(1), "EXPIRE", "a", 10)
+	assertCmd(t, c, int64(1), "PEXPIRE", "b", 1500)
+	assertCmd(t, c, int64(1), "EXPIREAT", "c", now.Unix()+20)
+	assertCmd(t, c, int64(0), "EXPIRE", "nope", 10)
+	assertCmd(t, c, int64(10), "TTL", "a")
+	assertCmd(t, c, int64(1500), "PTTL", "b")
+	assertCmd(t, c, int64(20), "TTL", "c")
+
+	f.Advance(2 * time.Second)
+	assertCmd(t, c, nil, "GET", "b")
+	assertCmd(t, c, int64(8), "TTL", "a")
+	assertCmd(t, c, int64(1), "PERSIST", "a")
+	assertCmd(t, c, int64(-1), "TTL", "a")
+
+	// setting a key clears its TTL
+	require.Nil(t, c.Cmd("SET", "c", "x").Err)
+	assertCmd(t, c, int64(-1), "TTL", "c")
+
+	// expiring in the past deletes the key
+	assertCmd(t, c, int64(1), "EXPIRE", "d", -1)
+	assertCmd(t, c, int64(0), "EXISTS", "d")
+}
+
+func TestScan(t *T) {
+	_, c := testClient(t)
+	var expected []string
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		require.Nil(t, c.Cmd("SET", k, "1").Err)
+		expected = append(expected, k)
+	}
+	require.Nil(t, c.Cmd("SADD", "set", "x").Err)
+
+	var keys []string
+	cursor := "0"
+	for {
+		var r []interface{}
+		require.Nil(t, c.Cmd("SCAN", cursor, "COUNT", 2, "TYPE", "string").
+			Unmarshal(&r))
+		cursor = r[0].(string)
+		for _, k := range r[1].([]interface{}) {
+			keys = append(keys, k.(string))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, expected, keys)
+
+	assertCmd(t, c, []interface{}{"0", []interface{}{"set"}},
+		"SCAN", 0, "MATCH", "s*", "COUNT", 100)
+}
@@ -0,0 +1,69 @@
+// Package redistest implements an in-memory fake redis server, for unit
+// testing code which uses redis without needing a real redis-server running.
+//
+// Start returns a Fake listening on a random port on the loopback interface,
+// which can be connected to like any other redis server:
+//
+//	f, err := redistest.Start()
+//	if err != nil {
+//		// handle err
+//	}
+//	defer f.Close()
+//
+//	p, err := pool.New("tcp", f.Addr(), 10, 10)
+//
+// Alternatively New returns a Fake which doesn't listen at all, and whose
+// connections are made over a net.Pipe using Client or Dial:
+//
+//	f := redistest.New()
+//	defer f.Close()
+//
+//	client := f.Client()
+//	p, err := pool.NewCustom("tcp", "fake", 10, 10, f.Dial)
+//
+// The Fake supports the common commands for strings, hashes, lists, sets and
+// sorted sets, as well as key expiry, SCAN, pub/sub, MULTI/EXEC/WATCH and
+// SELECT. Commands it doesn't support return an "unknown command" error.
+//
+// Keys with a TTL are expired according to the Fake's clock, which is the
+// real time by default. SetTime freezes it, after which it only moves when
+// Advance is called, so that expiry can be tested without sleeping:
+//
+//	f.SetTime(time.Now())
+//	client.Cmd("SET", "foo", "bar", "EX", 10)
+//	f.Advance(11 * time.Second)
+//	// foo has now expired
+//
+// StartCluster starts a fake redis cluster made up of a number of Fakes, which
+// reply to commands for keys they don't own with MOVED and ASK. Slots can be
+// migrated, and nodes added or killed, while a test is running:
+//
+//	fc, err := redistest.StartCluster(3)
+//	if err != nil {
+//		// handle err
+//	}
+//	defer fc.Close()
+//
+//	c, err := cluster.New(fc.Addr())
+//	...
+//	fc.MoveSlots(0, 100, fc.Addrs()[1])
+//
+// Similarly StartSentinel starts a fake sentinel, which answers the SENTINEL
+// commands for whatever masters it's told about, and publishes +switch-master
+// when Failover is called:
+//
+//	s, err := redistest.StartSentinel()
+//	if err != nil {
+//		// handle err
+//	}
+//	defer s.Close()
+//
+//	s.AddMaster("test", master.Addr(), slave.Addr())
+//	c, err := sentinel.NewClient("tcp", s.Addr(), 10, "test")
+//	...
+//	s.Failover("test", slave.Addr())
+//
+// There's no lua interpreter, so EVAL and EVALSHA can only run scripts which
+// have been given a go implementation using RegisterScript. Scripts run this
+// way work with util.LuaEval as normal.
+package redistest
@@ -0,0 +1,402 @@
+package redistest
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/kevwan/radix.v2/server"
+)
+
+// numDBs is the number of databases which can be SELECTed
+const numDBs = 16
+
+// Fake is an in-memory fake redis server. It implements the common commands
+// for each of the data types, as well as expiry, pub/sub, transactions and
+// (to a limited extent, see RegisterScript) scripting. All commands are run
+// atomically with respect to each other, as they are in redis.
+type Fake struct {
+	s *server.Server
+	l net.Listener
+
+	// set if the Fake is a node in a Cluster, or is a Sentinel
+	cluster  *Cluster
+	sentinel *Sentinel
+
+	// mu protects everything below, and is held for the duration of every
+	// command
+	mu      sync.Mutex
+	dbs     [numDBs]*db
+	clock   *time.Time
+	subs    map[string]map[*connState]struct{}
+	psubs   map[string]map[*connState]struct{}
+	scripts map[string]*script
+}
+
+// New returns a Fake which isn't listening on any address. Connections can be
+// made to it using Pipe, Client or Dial.
+func New() *Fake {
+	f := &Fake{
+		subs:    map[string]map[*connState]struct{}{},
+		psubs:   map[string]map[*connState]struct{}{},
+		scripts: map[string]*script{},
+	}
+	for i := range f.dbs {
+		f.dbs[i] = newDB()
+	}
+	f.s = &server.Server{Handler: f}
+	return f
+}
+
+// Start returns a Fake which is listening on a random port on the loopback
+// interface, see Addr
+func Start() (*Fake, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	f := New()
+	f.l = l
+	go f.s.Serve(l)
+	return f, nil
+}
+
+// Addr returns the address the Fake is listening on, or an empty string if it
+// was created using New
+func (f *Fake) Addr() string {
+	if f.l == nil {
+		return ""
+	}
+	return f.l.Addr().String()
+}
+
+// Pipe returns a new connection to the Fake, over a net.Pipe
+func (f *Fake) Pipe() net.Conn {
+	cconn, sconn := net.Pipe()
+	if err := f.s.ServeConn(sconn); err != nil {
+		cconn.Close()
+	}
+	return cconn
+}
+
+// Client returns a new Client connected to the Fake over a net.Pipe
+func (f *Fake) Client() *redis.Client {
+	return redis.NewClient(f.Pipe())
+}
+
+// Dial is like Client, but has the signature of a dial function so it can be
+// used with pool.NewCustom, cluster.Opts, etc... The network and address are
+// ignored.
+func (f *Fake) Dial(network, addr string) (*redis.Client, error) {
+	return f.Client(), nil
+}
+
+// Close closes all connections to the Fake, and stops it listening
+func (f *Fake) Close() error {
+	return f.s.Close()
+}
+
+// Now returns the time the Fake is currently using, see SetTime
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now()
+}
+
+func (f *Fake) now() time.Time {
+	if f.clock != nil {
+		return *f.clock
+	}
+	return time.Now()
+}
+
+// SetTime freezes the time used by the Fake, for expiring keys and the like, at
+// the given time. It will then only change when SetTime or Advance are
+// called, so that TTLs can be tested deterministically.
+func (f *Fake) SetTime(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clock = &t
+}
+
+// Advance moves the time used by the Fake forward by the given duration,
+// expiring any keys whose TTL has run out. If the time hasn't been frozen
+// using SetTime it is frozen at the current time first.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := f.now().Add(d)
+	f.clock = &t
+}
+
+// FlushAll removes all keys from all databases
+func (f *Fake) FlushAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, d := range f.dbs {
+		d.flush()
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// connState is the state the Fake keeps for each connection
+type connState struct {
+	conn *server.Conn
+	db   int
+
+	// set during MULTI
+	multi      bool
+	multiErr   bool
+	multiQueue []*cmd
+
+	// set by ASKING, and cleared by the next command
+	asking bool
+
+	watched map[watchKey]uint64
+
+	subs, psubs map[string]struct{}
+
+	// pub/sub messages waiting to be written to the connection, see push
+	pushL   sync.Mutex
+	pushQ   []func(*redis.RespWriter) error
+	pushing bool
+}
+
+type connStateKey struct{}
+
+func (f *Fake) getConnState(c *server.Conn) *connState {
+	cs, ok := c.Get(connStateKey{}).(*connState)
+	if !ok {
+		cs = &connState{conn: c}
+		c.Set(connStateKey{}, cs)
+		c.OnClose(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.unsubscribeAll(cs)
+		})
+	}
+	return cs
+}
+
+func (cs *connState) subscribed() bool {
+	return len(cs.subs)+len(cs.psubs) > 0
+}
+
+// cmd is a single command being run
+type cmd struct {
+	f    *Fake
+	cs   *connState
+	w    *redis.RespWriter
+	name string
+	args [][]byte
+	now  time.Time
+}
+
+func (c *cmd) db() *db {
+	return c.f.dbs[c.cs.db]
+}
+
+func (c *cmd) str(i int) string {
+	return string(c.args[i])
+}
+
+// writeErr writes an error reply with the given message
+func (c *cmd) writeErr(msg string) {
+	c.w.WriteError(simpleError(msg))
+}
+
+// simpleError is an error whose message is exactly the given string
+type simpleError string
+
+func (e simpleError) Error() string {
+	return string(e)
+}
+
+const (
+	errWrongType    = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errNotInt       = "ERR value is not an integer or out of range"
+	errNotFloat     = "ERR value is not a valid float"
+	errSyntax       = "ERR syntax error"
+	errOverflow     = "ERR increment or decrement would overflow"
+	errNoSuchKey    = "ERR no such key"
+	errOutOfRange   = "ERR index out of range"
+	errInvalidExp   = "ERR invalid expire time in '%s' command"
+	errDBOutOfRange = "ERR DB index is out of range"
+)
+
+func (c *cmd) int(i int) (int64, bool) {
+	n, err := strconv.ParseInt(c.str(i), 10, 64)
+	if err != nil {
+		c.writeErr(errNotInt)
+		return 0, false
+	}
+	return n, true
+}
+
+func (c *cmd) float(i int) (float64, bool) {
+	f, err := parseFloat(c.str(i))
+	if err != nil {
+		c.writeErr(errNotFloat)
+		return 0, false
+	}
+	return f, true
+}
+
+func parseFloat(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		s = "+Inf"
+	case "-inf":
+		s = "-Inf"
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	switch s {
+	case "+Inf":
+		return "inf"
+	case "-Inf":
+		return "-inf"
+	}
+	return s
+}
+
+// cmdInfo describes one of the commands the Fake implements
+type cmdInfo struct {
+	fn func(*cmd)
+
+	// the number of arguments (including the command name) the command takes,
+	// or if negative the minimum number
+	arity int
+
+	// whether the command may be run while the connection is subscribed to
+	// pub/sub channels
+	subOK bool
+
+	// whether the command is run immediately during a MULTI, rather than being
+	// queued
+	multiCmd bool
+
+	// returns the keys in the command's arguments, for checking which slot
+	// the command belongs to in a Cluster. nil if the command takes no keys.
+	keys func(args [][]byte) [][]byte
+}
+
+func firstKey(args [][]byte) [][]byte {
+	return args[:1]
+}
+
+func allKeys(args [][]byte) [][]byte {
+	return args
+}
+
+// pairKeys returns the keys of alternating key/values, as given to MSET
+func pairKeys(args [][]byte) [][]byte {
+	keys := make([][]byte, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// evalKeys returns the keys given to EVAL and EVALSHA
+func evalKeys(args [][]byte) [][]byte {
+	n, err := strconv.Atoi(string(args[1]))
+	if err != nil || n < 0 || n > len(args)-2 {
+		return nil
+	}
+	return args[2 : 2+n]
+}
+
+var cmds = map[string]cmdInfo{}
+
+func addCmds(m map[string]cmdInfo) {
+	for name, ci := range m {
+		cmds[name] = ci
+	}
+}
+
+// ServeRESP implements the server.Handler interface
+func (f *Fake) ServeRESP(w *redis.RespWriter, r *server.Request) {
+	c := &cmd{
+		f:    f,
+		cs:   f.getConnState(r.Conn),
+		w:    w,
+		name: strings.ToUpper(r.Cmd),
+		args: r.Args,
+	}
+
+	ci, ok := cmds[c.name]
+	if !ok {
+		c.writeErr("ERR unknown command '" + r.Cmd + "'")
+		c.cs.multiErr = c.cs.multi
+		return
+	} else if (ci.arity > 0 && len(c.args)+1 != ci.arity) ||
+		(ci.arity < 0 && len(c.args)+1 < -ci.arity) {
+		server.WrongArgs(w, r)
+		c.cs.multiErr = c.cs.multi
+		return
+	} else if c.cs.subscribed() && !ci.subOK {
+		c.writeErr(
+			"ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context",
+		)
+		return
+	}
+
+	if f.cluster != nil {
+		f.cluster.mu.Lock()
+		defer f.cluster.mu.Unlock()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.now = f.now()
+
+	if f.cluster != nil && !f.cluster.route(c, ci) {
+		c.cs.multiErr = c.cs.multi
+		return
+	} else if c.cs.multi && !ci.multiCmd {
+		c.cs.multiQueue = append(c.cs.multiQueue, c)
+		w.WriteSimpleStr("QUEUED")
+		return
+	}
+	ci.fn(c)
+}
+
+// run runs a command with the Fake's lock already held, as part of parent
+func (c *cmd) run(parent *cmd) {
+	c.now = parent.now
+	ci, ok := cmds[c.name]
+	if !ok {
+		c.writeErr("ERR unknown command '" + c.name + "'")
+		return
+	} else if (ci.arity > 0 && len(c.args)+1 != ci.arity) ||
+		(ci.arity < 0 && len(c.args)+1 < -ci.arity) {
+		c.writeErr("ERR wrong number of arguments for '" +
+			strings.ToLower(c.name) + "' command")
+		return
+	}
+	ci.fn(c)
+}
+
+// call runs the given command with the Fake's lock already held, as part of
+// parent, returning its reply
+func (parent *cmd) call(args [][]byte) *redis.Resp {
+	buf := new(bytes.Buffer)
+	c := &cmd{
+		f:    parent.f,
+		cs:   parent.cs,
+		w:    redis.NewRespWriter(buf),
+		name: strings.ToUpper(string(args[0])),
+		args: args[1:],
+	}
+	c.run(parent)
+	c.w.Flush()
+	return redis.NewRespReader(buf).Read()
+}
@@ -0,0 +1,120 @@
+package redistest
+
+import (
+	"errors"
+	. "testing"
+	"time"
+
+	"github.com/kevwan/radix.v2/pool"
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient returns a new Fake and a Client connected to it, both of which
+// are closed when the test is done
+func testClient(t *T) (*Fake, *redis.Client) {
+	f := New()
+	c := f.Client()
+	t.Cleanup(func() {
+		c.Close()
+		f.Close()
+	})
+	return f, c
+}
+
+// assertCmd runs the command and asserts that its reply, as decoded into an
+// interface{} by Unmarshal, is the expected one
+func assertCmd(t *T, c *redis.Client, expected interface{}, cmd string, args ...interface{}) {
+	t.Helper()
+	var got interface{}
+	require.Nil(t, c.Cmd(cmd, args...).Unmarshal(&got), "%s %v", cmd, args)
+	assert.Equal(t, expected, got, "%s %v", cmd, args)
+}
+
+// assertErr runs the command and asserts that it returns an error with the
+// given message
+func assertErr(t *T, c *redis.Client, msg string, cmd string, args ...interface{}) {
+	t.Helper()
+	err := c.Cmd(cmd, args...).Err
+	require.NotNil(t, err, "%s %v", cmd, args)
+	assert.Equal(t, msg, err.Error(), "%s %v", cmd, args)
+}
+
+func TestStart(t *T) {
+	f, err := Start()
+	require.Nil(t, err)
+	defer f.Close()
+
+	c, err := redis.Dial("tcp", f.Addr())
+	require.Nil(t, err)
+	defer c.Close()
+	assertCmd(t, c, "OK", "SET", "foo", "bar")
+
+	p, err := pool.New("tcp", f.Addr(), 2, 2)
+	require.Nil(t, err)
+	defer p.Empty()
+	assertPoolCmd := func(expected interface{}, cmd string, args ...interface{}) {
+		var got interface{}
+		require.Nil(t, p.Cmd(cmd, args...).Unmarshal(&got))
+		assert.Equal(t, expected, got)
+	}
+	assertPoolCmd("bar", "GET", "foo")
+	assertPoolCmd(int64(1), "DEL", "foo")
+}
+
+func TestNew(t *T) {
+	f := New()
+	defer f.Close()
+	assert.Equal(t, "", f.Addr())
+
+	p, err := pool.NewCustom("tcp", "fake", 2, 2, f.Dial)
+	require.Nil(t, err)
+	defer p.Empty()
+	require.Nil(t, p.Cmd("SET", "foo", "bar").Err)
+
+	// all connections share the same data
+	c := f.Client()
+	defer c.Close()
+	assertCmd(t, c, "bar", "GET", "foo")
+}
+
+func TestCommandErrors(t *T) {
+	_, c := testClient(t)
+	assertErr(t, c, "ERR unknown command 'NOPE'", "NOPE")
+	assertErr(t, c, "ERR wrong number of arguments for 'get' command", "GET")
+	assertErr(t, c, "ERR wrong number of arguments for 'get' command",
+		"GET", "a", "b")
+
+	require.Nil(t, c.Cmd("LPUSH", "list", "a").Err)
+	err := c.Cmd("GET", "list").Err
+	assert.True(t, errors.Is(err, redis.ErrWrongType), "err:%v", err)
+
+	// the connection is still usable
+	assertCmd(t, c, "PONG", "PING")
+}
+
+func TestTime(t *T) {
+	f, c := testClient(t)
+	now := time.Unix(1500000000, 0)
+	f.SetTime(now)
+	assert.Equal(t, now, f.Now())
+	assertCmd(t, c, []interface{}{"1500000000", "0"}, "TIME")
+
+	require.Nil(t, c.Cmd("SET", "foo", "bar", "EX", 10).Err)
+	f.Advance(9 * time.Second)
+	assertCmd(t, c, int64(1), "TTL", "foo")
+	assertCmd(t, c, "bar", "GET", "foo")
+
+	f.Advance(time.Second)
+	assert.Equal(t, now.Add(10*time.Second), f.Now())
+	assertCmd(t, c, nil, "GET", "foo")
+	assertCmd(t, c, int64(-2), "TTL", "foo")
+}
+
+func TestFlushAll(t *T) {
+	f, c := testClient(t)
+	require.Nil(t, c.Cmd("SET", "foo", "bar").Err)
+	f.FlushAll()
+	assertCmd(t, c, int64(0), "DBSIZE")
+}
@@ -0,0 +1,35 @@
+package redistest
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"*", "foo", true},
+		{"foo", "foo", true},
+		{"foo", "fo", false},
+		{"f*", "foo", true},
+		{"*o", "foo", true},
+		{"*x*", "foo", false},
+		{"f?o", "foo", true},
+		{"f?o", "fo", false},
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, globMatch(c.pattern, c.s), "%q %q", c.pattern, c.s)
+	}
+}
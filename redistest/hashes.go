@@ -0,0 +1,214 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"HSET":    {fn: cmdHSet, arity: -4, keys: firstKey},
+		"HMSET":   {fn: cmdHSet, arity: -4, keys: firstKey},
+		"HSETNX":  {fn: cmdHSetNX, arity: 4, keys: firstKey},
+		"HGET":    {fn: cmdHGet, arity: 3, keys: firstKey},
+		"HMGET":   {fn: cmdHMGet, arity: -3, keys: firstKey},
+		"HGETALL": {fn: cmdHGetAll, arity: 2, keys: firstKey},
+		"HDEL":    {fn: cmdHDel, arity: -3, keys: firstKey},
+		"HEXISTS": {fn: cmdHExists, arity: 3, keys: firstKey},
+		"HLEN":    {fn: cmdHLen, arity: 2, keys: firstKey},
+		"HKEYS":   {fn: cmdHGetAll, arity: 2, keys: firstKey},
+		"HVALS":   {fn: cmdHGetAll, arity: 2, keys: firstKey},
+		"HINCRBY": {fn: cmdHIncrBy, arity: 4, keys: firstKey},
+		"HSCAN":   {fn: cmdHScan, arity: -3, keys: firstKey},
+	})
+}
+
+// getHash returns the hash stored at the given key. If it doesn't exist and
+// create is set a new one is created, otherwise nil is returned.
+func (c *cmd) getHash(key string, create bool) (hashVal, bool) {
+	e, ok := c.lookup(key, "hash")
+	if !ok {
+		return nil, false
+	} else if e == nil {
+		if !create {
+			return nil, true
+		}
+		e = c.db().put(key, hashVal{})
+	}
+	return e.val.(hashVal), true
+}
+
+func (h hashVal) sortedFields() []string {
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func cmdHSet(c *cmd) {
+	if len(c.args)%2 != 1 {
+		c.writeErr("ERR wrong number of arguments for '" + strings.ToLower(c.name) + "' command")
+		return
+	}
+	key := c.str(0)
+	h, ok := c.getHash(key, true)
+	if !ok {
+		return
+	}
+	var n int64
+	for i := 1; i < len(c.args); i += 2 {
+		if _, ok := h[c.str(i)]; !ok {
+			n++
+		}
+		h[c.str(i)] = copyBytes(c.args[i+1])
+	}
+	c.db().touch(key)
+	if c.name == "HMSET" {
+		cmdOK(c)
+	} else {
+		c.w.WriteInt(n)
+	}
+}
+
+func cmdHSetNX(c *cmd) {
+	key, field := c.str(0), c.str(1)
+	h, ok := c.getHash(key, true)
+	if !ok {
+		return
+	} else if _, ok := h[field]; ok {
+		c.w.WriteInt(0)
+		return
+	}
+	h[field] = copyBytes(c.args[2])
+	c.db().touch(key)
+	c.w.WriteInt(1)
+}
+
+func cmdHGet(c *cmd) {
+	h, ok := c.getHash(c.str(0), false)
+	if ok {
+		c.writeBulkOrNil(h[c.str(1)])
+	}
+}
+
+func cmdHMGet(c *cmd) {
+	h, ok := c.getHash(c.str(0), false)
+	if !ok {
+		return
+	}
+	c.w.WriteArrayHeader(len(c.args) - 1)
+	for i := 1; i < len(c.args); i++ {
+		c.writeBulkOrNil(h[c.str(i)])
+	}
+}
+
+// cmdHGetAll implements HGETALL, HKEYS and HVALS
+func cmdHGetAll(c *cmd) {
+	h, ok := c.getHash(c.str(0), false)
+	if !ok {
+		return
+	}
+	fields := h.sortedFields()
+	if c.name == "HGETALL" {
+		c.w.WriteArrayHeader(len(fields) * 2)
+	} else {
+		c.w.WriteArrayHeader(len(fields))
+	}
+	for _, f := range fields {
+		if c.name != "HVALS" {
+			c.w.WriteBulkStr(f)
+		}
+		if c.name != "HKEYS" {
+			c.w.WriteBulk(h[f])
+		}
+	}
+}
+
+func cmdHDel(c *cmd) {
+	key := c.str(0)
+	h, ok := c.getHash(key, false)
+	if !ok {
+		return
+	}
+	var n int64
+	for i := 1; i < len(c.args); i++ {
+		if _, ok := h[c.str(i)]; ok {
+			delete(h, c.str(i))
+			n++
+		}
+	}
+	if n > 0 {
+		c.db().touch(key)
+		c.db().removeIfEmpty(key, c.db().keys[key])
+	}
+	c.w.WriteInt(n)
+}
+
+func cmdHExists(c *cmd) {
+	h, ok := c.getHash(c.str(0), false)
+	if !ok {
+		return
+	}
+	if _, ok := h[c.str(1)]; ok {
+		c.w.WriteInt(1)
+	} else {
+		c.w.WriteInt(0)
+	}
+}
+
+func cmdHLen(c *cmd) {
+	h, ok := c.getHash(c.str(0), false)
+	if ok {
+		c.w.WriteInt(int64(len(h)))
+	}
+}
+
+func cmdHIncrBy(c *cmd) {
+	by, ok := c.int(2)
+	if !ok {
+		return
+	}
+	key, field := c.str(0), c.str(1)
+	h, ok := c.getHash(key, true)
+	if !ok {
+		return
+	}
+	var n int64
+	if v, ok := h[field]; ok {
+		var err error
+		if n, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+			c.writeErr("ERR hash value is not an integer")
+			c.db().removeIfEmpty(key, c.db().keys[key])
+			return
+		}
+	}
+	if (by > 0 && n > math.MaxInt64-by) || (by < 0 && n < math.MinInt64-by) {
+		c.writeErr(errOverflow)
+		c.db().removeIfEmpty(key, c.db().keys[key])
+		return
+	}
+	n += by
+	h[field] = []byte(strconv.FormatInt(n, 10))
+	c.db().touch(key)
+	c.w.WriteInt(n)
+}
+
+func cmdHScan(c *cmd) {
+	o, ok := c.parseScan(1)
+	if !ok {
+		return
+	}
+	h, ok := c.getHash(c.str(0), false)
+	if !ok {
+		return
+	}
+	var elems []string
+	for _, f := range h.sortedFields() {
+		elems = append(elems, f, string(h[f]))
+	}
+	c.writeScan(o, elems, true, nil)
+}
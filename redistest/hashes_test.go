@@ -0,0 +1,34 @@
+package redistest
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash(t *T) {
+	_, c := testClient(t)
+	assertCmd(t, c, int64(2), "HSET", "h", "a", "1", "b", "2")
+	assertCmd(t, c, int64(0), "HSET", "h", "a", "3")
+	assertCmd(t, c, "OK", "HMSET", "h", "c", "4")
+	assertCmd(t, c, int64(0), "HSETNX", "h", "a", "5")
+	assertCmd(t, c, "3", "HGET", "h", "a")
+	assertCmd(t, c, nil, "HGET", "h", "nope")
+	assertCmd(t, c, []interface{}{"3", nil}, "HMGET", "h", "a", "nope")
+	assertCmd(t, c, int64(3), "HLEN", "h")
+	assertCmd(t, c, int64(1), "HEXISTS", "h", "b")
+	assertCmd(t, c, []interface{}{"a", "b", "c"}, "HKEYS", "h")
+	assertCmd(t, c, []interface{}{"3", "2", "4"}, "HVALS", "h")
+	assertCmd(t, c, int64(13), "HINCRBY", "h", "a", 10)
+
+	var m map[string]string
+	require.Nil(t, c.Cmd("HGETALL", "h").Unmarshal(&m))
+	require.Equal(t, map[string]string{"a": "13", "b": "2", "c": "4"}, m)
+
+	assertCmd(t, c, []interface{}{"0", []interface{}{"b", "2"}},
+		"HSCAN", "h", 0, "MATCH", "b")
+
+	// the key is removed once the hash is empty
+	assertCmd(t, c, int64(3), "HDEL", "h", "a", "b", "c", "nope")
+	assertCmd(t, c, int64(0), "EXISTS", "h")
+}
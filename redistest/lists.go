@@ -0,0 +1,284 @@
+package redistest
+
+import (
+	"bytes"
+)
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"LPUSH":  {fn: cmdPush, arity: -3, keys: firstKey},
+		"RPUSH":  {fn: cmdPush, arity: -3, keys: firstKey},
+		"LPUSHX": {fn: cmdPush, arity: -3, keys: firstKey},
+		"RPUSHX": {fn: cmdPush, arity: -3, keys: firstKey},
+		"LPOP":   {fn: cmdPop, arity: -2, keys: firstKey},
+		"RPOP":   {fn: cmdPop, arity: -2, keys: firstKey},
+		"LLEN":   {fn: cmdLLen, arity: 2, keys: firstKey},
+		"LRANGE": {fn: cmdLRange, arity: 4, keys: firstKey},
+		"LINDEX": {fn: cmdLIndex, arity: 3, keys: firstKey},
+		"LSET":   {fn: cmdLSet, arity: 4, keys: firstKey},
+		"LREM":   {fn: cmdLRem, arity: 4, keys: firstKey},
+		"LTRIM":  {fn: cmdLTrim, arity: 4, keys: firstKey},
+	})
+}
+
+// getList returns the list stored at the given key. If it doesn't exist and
+// create is set a new one is created, otherwise nil is returned.
+func (c *cmd) getList(key string, create bool) (*listVal, bool) {
+	e, ok := c.lookup(key, "list")
+	if !ok {
+		return nil, false
+	} else if e == nil {
+		if !create {
+			return nil, true
+		}
+		e = c.db().put(key, &listVal{})
+	}
+	return e.val.(*listVal), true
+}
+
+// llen returns the length of the list, which may be nil
+func (l *listVal) llen() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.l)
+}
+
+// listRange converts the (possibly negative) start and stop indexes given to
+// a command like LRANGE into the indexes of a slice of a list of length n. ok
+// is false if the range is empty.
+func listRange(start, stop int64, n int) (int, int, bool) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop || start >= int64(n) {
+		return 0, 0, false
+	}
+	return int(start), int(stop) + 1, true
+}
+
+func cmdPush(c *cmd) {
+	key := c.str(0)
+	x := c.name == "LPUSHX" || c.name == "RPUSHX"
+	l, ok := c.getList(key, !x)
+	if !ok {
+		return
+	} else if l == nil {
+		c.w.WriteInt(0)
+		return
+	}
+	for _, v := range c.args[1:] {
+		if c.name[0] == 'L' {
+			l.l = append([][]byte{copyBytes(v)}, l.l...)
+		} else {
+			l.l = append(l.l, copyBytes(v))
+		}
+	}
+	c.db().touch(key)
+	c.w.WriteInt(int64(len(l.l)))
+}
+
+func cmdPop(c *cmd) {
+	if len(c.args) > 2 {
+		c.writeErr(errSyntax)
+		return
+	}
+	count, withCount := int64(1), len(c.args) == 2
+	if withCount {
+		var ok bool
+		if count, ok = c.int(1); !ok {
+			return
+		} else if count < 0 {
+			c.writeErr("ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	key := c.str(0)
+	l, ok := c.getList(key, false)
+	if !ok {
+		return
+	} else if l == nil {
+		c.w.WriteNil()
+		return
+	}
+
+	if count > int64(len(l.l)) {
+		count = int64(len(l.l))
+	}
+	popped := make([][]byte, count)
+	for i := range popped {
+		if c.name == "LPOP" {
+			popped[i], l.l = l.l[0], l.l[1:]
+		} else {
+			popped[i], l.l = l.l[len(l.l)-1], l.l[:len(l.l)-1]
+		}
+	}
+	c.db().touch(key)
+	c.db().removeIfEmpty(key, c.db().keys[key])
+
+	if !withCount {
+		c.w.WriteBulk(popped[0])
+		return
+	}
+	c.w.WriteArrayHeader(len(popped))
+	for _, v := range popped {
+		c.w.WriteBulk(v)
+	}
+}
+
+func cmdLLen(c *cmd) {
+	l, ok := c.getList(c.str(0), false)
+	if ok {
+		c.w.WriteInt(int64(l.llen()))
+	}
+}
+
+func cmdLRange(c *cmd) {
+	start, ok := c.int(1)
+	if !ok {
+		return
+	}
+	stop, ok := c.int(2)
+	if !ok {
+		return
+	}
+	l, ok := c.getList(c.str(0), false)
+	if !ok {
+		return
+	}
+	i, j, ok := listRange(start, stop, l.llen())
+	if !ok {
+		c.w.WriteArrayHeader(0)
+		return
+	}
+	c.w.WriteArrayHeader(j - i)
+	for _, v := range l.l[i:j] {
+		c.w.WriteBulk(v)
+	}
+}
+
+func cmdLIndex(c *cmd) {
+	i, ok := c.int(1)
+	if !ok {
+		return
+	}
+	l, ok := c.getList(c.str(0), false)
+	if !ok {
+		return
+	}
+	if i < 0 {
+		i += int64(l.llen())
+	}
+	if i < 0 || i >= int64(l.llen()) {
+		c.w.WriteNil()
+		return
+	}
+	c.w.WriteBulk(l.l[i])
+}
+
+func cmdLSet(c *cmd) {
+	i, ok := c.int(1)
+	if !ok {
+		return
+	}
+	key := c.str(0)
+	l, ok := c.getList(key, false)
+	if !ok {
+		return
+	} else if l == nil {
+		c.writeErr(errNoSuchKey)
+		return
+	}
+	if i < 0 {
+		i += int64(l.llen())
+	}
+	if i < 0 || i >= int64(l.llen()) {
+		c.writeErr(errOutOfRange)
+		return
+	}
+	l.l[i] = copyBytes(c.args[2])
+	c.db().touch(key)
+	cmdOK(c)
+}
+
+func cmdLRem(c *cmd) {
+	count, ok := c.int(1)
+	if !ok {
+		return
+	}
+	key, v := c.str(0), c.args[2]
+	l, ok := c.getList(key, false)
+	if !ok {
+		return
+	} else if l == nil {
+		c.w.WriteInt(0)
+		return
+	}
+
+	// removing from the tail is the same as removing from the head of the
+	// reversed list
+	reverse := func() {
+		for i, j := 0, len(l.l)-1; i < j; i, j = i+1, j-1 {
+			l.l[i], l.l[j] = l.l[j], l.l[i]
+		}
+	}
+	neg := count < 0
+	if neg {
+		reverse()
+		count = -count
+	}
+	var removed int64
+	kept := l.l[:0]
+	for _, e := range l.l {
+		if bytes.Equal(e, v) && (count == 0 || removed < count) {
+			removed++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	l.l = kept
+	if neg {
+		reverse()
+	}
+
+	if removed > 0 {
+		c.db().touch(key)
+		c.db().removeIfEmpty(key, c.db().keys[key])
+	}
+	c.w.WriteInt(removed)
+}
+
+func cmdLTrim(c *cmd) {
+	start, ok := c.int(1)
+	if !ok {
+		return
+	}
+	stop, ok := c.int(2)
+	if !ok {
+		return
+	}
+	key := c.str(0)
+	l, ok := c.getList(key, false)
+	if !ok {
+		return
+	} else if l != nil {
+		if i, j, ok := listRange(start, stop, l.llen()); ok {
+			l.l = l.l[i:j]
+		} else {
+			l.l = nil
+		}
+		c.db().touch(key)
+		c.db().removeIfEmpty(key, c.db().keys[key])
+	}
+	cmdOK(c)
+}
@@ -0,0 +1,42 @@
+package redistest
+
+import (
+	. "testing"
+)
+
+func TestList(t *T) {
+	_, c := testClient(t)
+	assertCmd(t, c, int64(0), "LPUSHX", "l", "a")
+	assertCmd(t, c, int64(2), "RPUSH", "l", "b", "c")
+	assertCmd(t, c, int64(4), "LPUSH", "l", "z", "a")
+	assertCmd(t, c, []interface{}{"a", "z", "b", "c"}, "LRANGE", "l", 0, -1)
+	assertCmd(t, c, []interface{}{"b", "c"}, "LRANGE", "l", -2, 100)
+	assertCmd(t, c, []interface{}{}, "LRANGE", "l", 5, 10)
+	assertCmd(t, c, int64(4), "LLEN", "l")
+	assertCmd(t, c, "c", "LINDEX", "l", -1)
+	assertCmd(t, c, nil, "LINDEX", "l", 10)
+
+	assertCmd(t, c, "OK", "LSET", "l", 1, "y")
+	assertErr(t, c, "ERR index out of range", "LSET", "l", 10, "y")
+	assertErr(t, c, "ERR no such key", "LSET", "nope", 0, "y")
+
+	assertCmd(t, c, "a", "LPOP", "l")
+	assertCmd(t, c, "c", "RPOP", "l")
+	assertCmd(t, c, []interface{}{"y", "b"}, "LPOP", "l", 5)
+	assertCmd(t, c, int64(0), "EXISTS", "l")
+	assertCmd(t, c, nil, "LPOP", "l")
+}
+
+func TestLRemTrim(t *T) {
+	_, c := testClient(t)
+	assertCmd(t, c, int64(6), "RPUSH", "l", "a", "b", "a", "c", "a", "d")
+	assertCmd(t, c, int64(1), "LREM", "l", -1, "a")
+	assertCmd(t, c, []interface{}{"a", "b", "a", "c", "d"}, "LRANGE", "l", 0, -1)
+	assertCmd(t, c, int64(2), "LREM", "l", 0, "a")
+	assertCmd(t, c, []interface{}{"b", "c", "d"}, "LRANGE", "l", 0, -1)
+
+	assertCmd(t, c, "OK", "LTRIM", "l", 1, -1)
+	assertCmd(t, c, []interface{}{"c", "d"}, "LRANGE", "l", 0, -1)
+	assertCmd(t, c, "OK", "LTRIM", "l", 5, 10)
+	assertCmd(t, c, int64(0), "EXISTS", "l")
+}
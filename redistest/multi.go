@@ -0,0 +1,97 @@
+package redistest
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"MULTI":   {fn: cmdMulti, arity: 1, multiCmd: true},
+		"EXEC":    {fn: cmdExec, arity: 1, multiCmd: true},
+		"DISCARD": {fn: cmdDiscard, arity: 1, multiCmd: true},
+		"WATCH":   {fn: cmdWatch, arity: -2, multiCmd: true, keys: allKeys},
+		"UNWATCH": {fn: cmdUnwatch, arity: 1, multiCmd: true},
+	})
+}
+
+// watchKey identifies a key which has been WATCHed
+type watchKey struct {
+	db  int
+	key string
+}
+
+// version returns a value which changes whenever the key is modified, or its
+// db flushed
+func (f *Fake) version(k watchKey) uint64 {
+	d := f.dbs[k.db]
+	return d.epoch<<32 + d.versions[k.key]
+}
+
+func (cs *connState) resetMulti() {
+	cs.multi, cs.multiErr, cs.multiQueue = false, false, nil
+	cs.watched = nil
+}
+
+func cmdMulti(c *cmd) {
+	if c.cs.multi {
+		c.writeErr("ERR MULTI calls can not be nested")
+		return
+	}
+	c.cs.multi = true
+	cmdOK(c)
+}
+
+func cmdExec(c *cmd) {
+	if !c.cs.multi {
+		c.writeErr("ERR EXEC without MULTI")
+		return
+	}
+	queue, multiErr, watched := c.cs.multiQueue, c.cs.multiErr, c.cs.watched
+	c.cs.resetMulti()
+
+	if multiErr {
+		c.writeErr("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	for k, v := range watched {
+		if c.f.version(k) != v {
+			c.w.WriteNilArray()
+			return
+		}
+	}
+
+	c.w.WriteArrayHeader(len(queue))
+	for _, qc := range queue {
+		qc.w = c.w
+		qc.run(c)
+	}
+}
+
+func cmdDiscard(c *cmd) {
+	if !c.cs.multi {
+		c.writeErr("ERR DISCARD without MULTI")
+		return
+	}
+	c.cs.resetMulti()
+	cmdOK(c)
+}
+
+func cmdWatch(c *cmd) {
+	if c.cs.multi {
+		c.writeErr("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+	if c.cs.watched == nil {
+		c.cs.watched = map[watchKey]uint64{}
+	}
+	for i := range c.args {
+		k := watchKey{db: c.cs.db, key: c.str(i)}
+		// an expired key counts as having been modified
+		c.db().get(k.key, c.now)
+		if _, ok := c.cs.watched[k]; !ok {
+			c.cs.watched[k] = c.f.version(k)
+		}
+	}
+	cmdOK(c)
+}
+
+func cmdUnwatch(c *cmd) {
+	c.cs.watched = nil
+	cmdOK(c)
+}
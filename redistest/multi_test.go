@@ -0,0 +1,77 @@
+package redistest
+
+import (
+	. "testing"
+
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMulti(t *T) {
+	_, c := testClient(t)
+	assertCmd(t, c, "OK", "MULTI")
+	assertCmd(t, c, "QUEUED", "SET", "foo", "1")
+	assertCmd(t, c, "QUEUED", "INCR", "foo")
+	assertCmd(t, c, "QUEUED", "LPUSH", "foo", "a")
+	assertCmd(t, c, "QUEUED", "GET", "foo")
+
+	r := c.Cmd("EXEC")
+	require.Nil(t, r.Err)
+	l, err := r.Array()
+	require.Nil(t, err)
+	require.Len(t, l, 4)
+	ok, _ := l[0].Str()
+	assert.Equal(t, "OK", ok)
+	n, _ := l[1].Int()
+	assert.Equal(t, 2, n)
+	assert.True(t, l[2].IsType(redis.AppErr))
+	s, _ := l[3].Str()
+	assert.Equal(t, "2", s)
+
+	assertCmd(t, c, "OK", "MULTI")
+	assertCmd(t, c, "QUEUED", "SET", "foo", "3")
+	assertCmd(t, c, "OK", "DISCARD")
+	assertCmd(t, c, "2", "GET", "foo")
+	assertErr(t, c, "ERR EXEC without MULTI", "EXEC")
+
+	// an error while queueing aborts the whole transaction
+	assertCmd(t, c, "OK", "MULTI")
+	assertCmd(t, c, "QUEUED", "SET", "foo", "3")
+	assertErr(t, c, "ERR unknown command 'NOPE'", "NOPE")
+	assertErr(t, c, "EXECABORT Transaction discarded because of previous errors.",
+		"EXEC")
+	assertCmd(t, c, "2", "GET", "foo")
+}
+
+func TestWatch(t *T) {
+	f, c := testClient(t)
+	c2 := f.Client()
+	defer c2.Close()
+
+	assertCmd(t, c, "OK", "WATCH", "foo")
+	assertCmd(t, c, "OK", "MULTI")
+	assertCmd(t, c, "QUEUED", "SET", "foo", "1")
+	require.Nil(t, c2.Cmd("SET", "foo", "2").Err)
+	assertCmd(t, c, nil, "EXEC")
+	assertCmd(t, c, "2", "GET", "foo")
+
+	// the watch is cleared by EXEC, and flushing counts as modifying
+	assertCmd(t, c, "OK", "WATCH", "foo")
+	require.Nil(t, c2.Cmd("FLUSHALL").Err)
+	assertCmd(t, c, "OK", "MULTI")
+	assertCmd(t, c, nil, "EXEC")
+
+	// and works with Transaction
+	require.Nil(t, c.Cmd("SET", "foo", "1").Err)
+	err := c.Transaction([]string{"foo"}, func(tx *redis.Tx) error {
+		n, err := tx.Cmd("GET", "foo").Int()
+		if err != nil {
+			return err
+		}
+		tx.Queue("SET", "foo", n*2)
+		return nil
+	})
+	require.Nil(t, err)
+	assertCmd(t, c, "2", "GET", "foo")
+}
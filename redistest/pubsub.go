@@ -0,0 +1,199 @@
+package redistest
+
+import (
+	"sort"
+
+	"github.com/kevwan/radix.v2/redis"
+)
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"SUBSCRIBE":    {fn: cmdSubscribe, arity: -2, subOK: true},
+		"PSUBSCRIBE":   {fn: cmdSubscribe, arity: -2, subOK: true},
+		"UNSUBSCRIBE":  {fn: cmdUnsubscribe, arity: -1, subOK: true},
+		"PUNSUBSCRIBE": {fn: cmdUnsubscribe, arity: -1, subOK: true},
+		"PUBLISH":      {fn: cmdPublish, arity: 3},
+	})
+}
+
+// subMaps returns the Fake's and the connection's subscriptions which the
+// command is working on, i.e. either those to channels or to patterns
+func (c *cmd) subMaps() (map[string]map[*connState]struct{}, map[string]struct{}) {
+	if c.name[0] == 'P' {
+		if c.cs.psubs == nil {
+			c.cs.psubs = map[string]struct{}{}
+		}
+		return c.f.psubs, c.cs.psubs
+	}
+	if c.cs.subs == nil {
+		c.cs.subs = map[string]struct{}{}
+	}
+	return c.f.subs, c.cs.subs
+}
+
+// writeSubReply writes one of the replies to (P)(UN)SUBSCRIBE, which redis
+// sends for each channel given
+func (c *cmd) writeSubReply(kind, channel string, isNil bool) {
+	c.w.WriteArrayHeader(3)
+	c.w.WriteBulkStr(kind)
+	if isNil {
+		c.w.WriteNil()
+	} else {
+		c.w.WriteBulkStr(channel)
+	}
+	c.w.WriteInt(int64(len(c.cs.subs) + len(c.cs.psubs)))
+}
+
+func cmdSubscribe(c *cmd) {
+	all, mine := c.subMaps()
+	kind := map[string]string{
+		"SUBSCRIBE": "subscribe", "PSUBSCRIBE": "psubscribe",
+	}[c.name]
+	for i := range c.args {
+		ch := c.str(i)
+		mine[ch] = struct{}{}
+		if all[ch] == nil {
+			all[ch] = map[*connState]struct{}{}
+		}
+		all[ch][c.cs] = struct{}{}
+		c.writeSubReply(kind, ch, false)
+	}
+}
+
+func cmdUnsubscribe(c *cmd) {
+	all, mine := c.subMaps()
+	kind := map[string]string{
+		"UNSUBSCRIBE": "unsubscribe", "PUNSUBSCRIBE": "punsubscribe",
+	}[c.name]
+
+	chs := make([]string, len(c.args))
+	for i := range c.args {
+		chs[i] = c.str(i)
+	}
+	if len(chs) == 0 {
+		for ch := range mine {
+			chs = append(chs, ch)
+		}
+		sort.Strings(chs)
+		if len(chs) == 0 {
+			c.writeSubReply(kind, "", true)
+			return
+		}
+	}
+
+	for _, ch := range chs {
+		delete(mine, ch)
+		c.f.unsubscribe(all, ch, c.cs)
+		c.writeSubReply(kind, ch, false)
+	}
+}
+
+func (f *Fake) unsubscribe(all map[string]map[*connState]struct{}, ch string, cs *connState) {
+	delete(all[ch], cs)
+	if len(all[ch]) == 0 {
+		delete(all, ch)
+	}
+}
+
+// unsubscribeAll removes all of the connection's subscriptions, once it's been
+// closed or a push to it has failed
+func (f *Fake) unsubscribeAll(cs *connState) {
+	for ch := range cs.subs {
+		f.unsubscribe(f.subs, ch, cs)
+	}
+	for pat := range cs.psubs {
+		f.unsubscribe(f.psubs, pat, cs)
+	}
+	cs.subs, cs.psubs = nil, nil
+}
+
+func cmdPublish(c *cmd) {
+	c.w.WriteInt(int64(c.f.publish(c.str(0), copyBytes(c.args[1]))))
+}
+
+// Publish publishes the message on the given channel, as PUBLISH does, and
+// returns the number of subscribers it was sent to. It's useful for sending
+// events a real server would publish itself, e.g. those of a Sentinel.
+func (f *Fake) Publish(channel, msg string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.publish(channel, []byte(msg))
+}
+
+// publish does the work of Publish, with the Fake's lock already held
+func (f *Fake) publish(ch string, msg []byte) int {
+	var n int
+	for cs := range f.subs[ch] {
+		f.push(cs, message(ch, "", msg))
+		n++
+	}
+	for pat, css := range f.psubs {
+		if !globMatch(pat, ch) {
+			continue
+		}
+		for cs := range css {
+			f.push(cs, message(ch, pat, msg))
+			n++
+		}
+	}
+	return n
+}
+
+// message returns a function which writes a pub/sub message, or a pmessage if
+// pat is set
+func message(ch, pat string, msg []byte) func(*redis.RespWriter) error {
+	return func(w *redis.RespWriter) error {
+		if pat == "" {
+			w.WriteArrayHeader(3)
+			w.WriteBulkStr("message")
+		} else {
+			w.WriteArrayHeader(4)
+			w.WriteBulkStr("pmessage")
+			w.WriteBulkStr(pat)
+		}
+		w.WriteBulkStr(ch)
+		return w.WriteBulk(msg)
+	}
+}
+
+// push queues a message to be written to the connection. Messages are written
+// in the background, in order, so that a subscriber which isn't reading
+// doesn't hold up the publisher (or the whole Fake). If the connection turns
+// out to be closed its subscriptions are removed.
+func (f *Fake) push(cs *connState, fn func(*redis.RespWriter) error) {
+	cs.pushL.Lock()
+	defer cs.pushL.Unlock()
+	cs.pushQ = append(cs.pushQ, fn)
+	if !cs.pushing {
+		cs.pushing = true
+		go f.writePushes(cs)
+	}
+}
+
+func (f *Fake) writePushes(cs *connState) {
+	for {
+		cs.pushL.Lock()
+		q := cs.pushQ
+		cs.pushQ = nil
+		if len(q) == 0 {
+			cs.pushing = false
+			cs.pushL.Unlock()
+			return
+		}
+		cs.pushL.Unlock()
+
+		err := cs.conn.Write(func(w *redis.RespWriter) error {
+			for _, fn := range q {
+				if err := fn(w); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			f.mu.Lock()
+			f.unsubscribeAll(cs)
+			f.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package redistest
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/kevwan/radix.v2/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPubSub(t *T) {
+	f, c := testClient(t)
+	sub := pubsub.NewSubClient(f.Client())
+	defer sub.Client.Close()
+
+	sr := sub.Subscribe("foo")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, pubsub.Subscribe, sr.Type)
+	assert.Equal(t, 1, sr.SubCount)
+	sr = sub.PSubscribe("f*")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, 2, sr.SubCount)
+
+	// only pub/sub commands are allowed while subscribed
+	sr = sub.Ping()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, pubsub.Pong, sr.Type)
+
+	assertCmd(t, c, int64(2), "PUBLISH", "foo", "hi")
+	assertCmd(t, c, int64(0), "PUBLISH", "bar", "hi")
+
+	sr = sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, pubsub.Message, sr.Type)
+	assert.Equal(t, "foo", sr.Channel)
+	assert.Equal(t, "", sr.Pattern)
+	assert.Equal(t, "hi", sr.Message)
+
+	sr = sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, pubsub.Message, sr.Type)
+	assert.Equal(t, "foo", sr.Channel)
+	assert.Equal(t, "f*", sr.Pattern)
+	assert.Equal(t, "hi", sr.Message)
+
+	sr = sub.Unsubscribe("foo")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, 1, sr.SubCount)
+	sr = sub.PUnsubscribe("f*")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, 0, sr.SubCount)
+	assertCmd(t, c, int64(0), "PUBLISH", "foo", "hi")
+}
+
+func TestPubSubClosed(t *T) {
+	f, c := testClient(t)
+	sub := f.Client()
+	require.Nil(t, sub.Cmd("SUBSCRIBE", "foo").Err)
+	sub.Close()
+
+	// the subscriber is removed once its connection is closed, without
+	// needing a message to fail to be written to it first
+	assert.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.subs) == 0
+	}, time.Second, time.Millisecond)
+	assertCmd(t, c, int64(0), "PUBLISH", "foo", "hi")
+	assert.Equal(t, 0, f.Publish("foo", "hi"))
+}
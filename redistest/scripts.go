@@ -0,0 +1,151 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/kevwan/radix.v2/redis"
+)
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"EVAL":    {fn: cmdEval, arity: -3, keys: evalKeys},
+		"EVALSHA": {fn: cmdEval, arity: -3, keys: evalKeys},
+		"SCRIPT":  {fn: cmdScript, arity: -2},
+	})
+}
+
+// ScriptFunc implements a lua script in go, see RegisterScript. call runs a
+// command in the same way as redis.call does, and keys and args are the KEYS
+// and ARGV the script was called with. The returned value is written as the
+// reply, as with RespWriter's WriteValue, unless it's a *redis.Resp in which
+// case it's written as-is.
+type ScriptFunc func(
+	call func(args ...string) *redis.Resp, keys, args []string,
+) interface{}
+
+type script struct {
+	fn ScriptFunc
+
+	// whether the script has been loaded by EVAL or SCRIPT LOAD, and so can
+	// be run by EVALSHA
+	loaded bool
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// RegisterScript registers a go function as the implementation of the given
+// lua script, since the Fake doesn't include a lua interpreter. When the
+// script is run by EVAL or EVALSHA the function is called instead, atomically
+// with respect to other commands. Running a script which hasn't been registered
+// returns an error.
+//
+//	f.RegisterScript(`return redis.call('GET', KEYS[1])`,
+//		func(call func(...string) *redis.Resp, keys, args []string) interface{} {
+//			return call("GET", keys[0])
+//		},
+//	)
+func (f *Fake) RegisterScript(src string, fn ScriptFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sha := sha1Hex(src)
+	if s, ok := f.scripts[sha]; ok {
+		s.fn = fn
+	} else {
+		f.scripts[sha] = &script{fn: fn}
+	}
+}
+
+// loadScript marks the given script as loaded, returning its sha
+func (f *Fake) loadScript(src string) string {
+	sha := sha1Hex(src)
+	s, ok := f.scripts[sha]
+	if !ok {
+		s = &script{}
+		f.scripts[sha] = s
+	}
+	s.loaded = true
+	return sha
+}
+
+func cmdEval(c *cmd) {
+	var s *script
+	if c.name == "EVAL" {
+		s = c.f.scripts[c.f.loadScript(c.str(0))]
+	} else if s = c.f.scripts[strings.ToLower(c.str(0))]; s == nil || !s.loaded {
+		c.writeErr("NOSCRIPT No matching script. Please use EVAL.")
+		return
+	}
+
+	numKeys, err := strconv.Atoi(c.str(1))
+	if err != nil {
+		c.writeErr(errNotInt)
+		return
+	} else if numKeys < 0 {
+		c.writeErr("ERR Number of keys can't be negative")
+		return
+	} else if numKeys > len(c.args)-2 {
+		c.writeErr("ERR Number of keys can't be greater than number of args")
+		return
+	} else if s.fn == nil {
+		c.writeErr("ERR redistest: script hasn't been registered using RegisterScript")
+		return
+	}
+
+	var keys, args []string
+	for i := 2; i < len(c.args); i++ {
+		if i < numKeys+2 {
+			keys = append(keys, c.str(i))
+		} else {
+			args = append(args, c.str(i))
+		}
+	}
+
+	call := func(cmdArgs ...string) *redis.Resp {
+		bargs := make([][]byte, len(cmdArgs))
+		for i := range cmdArgs {
+			bargs[i] = []byte(cmdArgs[i])
+		}
+		return c.call(bargs)
+	}
+
+	switch ret := s.fn(call, keys, args).(type) {
+	case *redis.Resp:
+		c.w.WriteResp(ret)
+	default:
+		c.w.WriteValue(ret)
+	}
+}
+
+func cmdScript(c *cmd) {
+	switch sub := strings.ToUpper(c.str(0)); {
+	case sub == "LOAD" && len(c.args) == 2:
+		c.w.WriteBulkStr(c.f.loadScript(c.str(1)))
+	case sub == "EXISTS":
+		c.w.WriteArrayHeader(len(c.args) - 1)
+		for i := 1; i < len(c.args); i++ {
+			var n int64
+			if s := c.f.scripts[strings.ToLower(c.str(i))]; s != nil && s.loaded {
+				n = 1
+			}
+			c.w.WriteInt(n)
+		}
+	case sub == "FLUSH":
+		for sha, s := range c.f.scripts {
+			if s.fn == nil {
+				delete(c.f.scripts, sha)
+			} else {
+				s.loaded = false
+			}
+		}
+		cmdOK(c)
+	default:
+		c.writeErr("ERR Unknown subcommand or wrong number of arguments for '" +
+			c.str(0) + "'")
+	}
+}
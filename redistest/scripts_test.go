@@ -0,0 +1,57 @@
+package redistest
+
+import (
+	. "testing"
+
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/kevwan/radix.v2/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const getSetScript = `
+	local old = redis.call('GET', KEYS[1])
+	redis.call('SET', KEYS[1], ARGV[1])
+	return old
+`
+
+func getSet(call func(...string) *redis.Resp, keys, args []string) interface{} {
+	old := call("GET", keys[0])
+	call("SET", keys[0], args[0])
+	return old
+}
+
+func TestLuaEval(t *T) {
+	f, c := testClient(t)
+	f.RegisterScript(getSetScript, getSet)
+
+	r := util.LuaEval(c, getSetScript, 1, "foo", "bar")
+	require.Nil(t, r.Err)
+	assert.True(t, r.IsType(redis.Nil))
+	s, err := util.LuaEval(c, getSetScript, 1, "foo", "baz").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	assertCmd(t, c, "baz", "GET", "foo")
+
+	err = util.LuaEval(c, "return 1", 0).Err
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "RegisterScript")
+}
+
+func TestScript(t *T) {
+	f, c := testClient(t)
+	f.RegisterScript(getSetScript, getSet)
+	sha := sha1Hex(getSetScript)
+
+	assertErr(t, c, "NOSCRIPT No matching script. Please use EVAL.",
+		"EVALSHA", sha, 1, "foo", "bar")
+	assertCmd(t, c, []interface{}{int64(0)}, "SCRIPT", "EXISTS", sha)
+	assertCmd(t, c, sha, "SCRIPT", "LOAD", getSetScript)
+	assertCmd(t, c, []interface{}{int64(1)}, "SCRIPT", "EXISTS", sha)
+	assertCmd(t, c, nil, "EVALSHA", sha, 1, "foo", "bar")
+	assertCmd(t, c, "OK", "SCRIPT", "FLUSH")
+	assertCmd(t, c, []interface{}{int64(0)}, "SCRIPT", "EXISTS", sha)
+
+	assertErr(t, c, "ERR Number of keys can't be greater than number of args",
+		"EVAL", getSetScript, 2, "foo")
+}
@@ -0,0 +1,245 @@
+package redistest
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Sentinel is a fake redis sentinel. It answers the SENTINEL commands used by
+// clients to discover masters, their slaves and other sentinels, and publishes
+// events like +switch-master to its subscribers. The masters it reports are
+// only addresses, which will usually be those of other Fakes.
+//
+// Apart from SENTINEL, a Sentinel is a normal Fake, so it can be used to
+// publish any other event on demand using PUBLISH or Publish.
+type Sentinel struct {
+	*Fake
+
+	mu      sync.Mutex
+	masters map[string]*sentinelMaster
+}
+
+type sentinelMaster struct {
+	addr      string
+	slaves    []string
+	sentinels []string
+}
+
+// StartSentinel returns a Sentinel which is listening on a random port on the
+// loopback interface, and which isn't monitoring any masters yet
+func StartSentinel() (*Sentinel, error) {
+	f, err := Start()
+	if err != nil {
+		return nil, err
+	}
+	s := &Sentinel{
+		Fake:    f,
+		masters: map[string]*sentinelMaster{},
+	}
+	f.sentinel = s
+	return s, nil
+}
+
+// AddMaster adds a master with the given name and address for the Sentinel to
+// monitor, along with the addresses of its slaves. If there's already a master
+// with the name it's replaced.
+func (s *Sentinel) AddMaster(name, addr string, slaves ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.masters[name] = &sentinelMaster{
+		addr:   addr,
+		slaves: append([]string(nil), slaves...),
+	}
+}
+
+// SetSentinels sets the addresses of the other sentinels which are monitoring
+// the master with the given name, as returned by SENTINEL SENTINELS
+func (s *Sentinel) SetSentinels(name string, addrs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.master(name)
+	if err != nil {
+		return err
+	}
+	m.sentinels = append([]string(nil), addrs...)
+	return nil
+}
+
+// MasterAddr returns the address of the master with the given name, or an
+// empty string if there's no master with that name
+func (s *Sentinel) MasterAddr(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.masters[name]; ok {
+		return m.addr
+	}
+	return ""
+}
+
+func (s *Sentinel) master(name string) (*sentinelMaster, error) {
+	m, ok := s.masters[name]
+	if !ok {
+		return nil, simpleError("ERR No such master with that name")
+	}
+	return m, nil
+}
+
+// Failover fails the master with the given name over to the slave with the
+// given address, which the old master then becomes a slave of, and publishes
+// +switch-master. If addr is empty the first slave is used.
+func (s *Sentinel) Failover(name, addr string) error {
+	s.mu.Lock()
+	msg, err := s.failover(name, addr)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.Publish("+switch-master", msg)
+	return nil
+}
+
+// failover does the work of Failover, returning the +switch-master message
+// which should be published. The Sentinel's lock must be held.
+func (s *Sentinel) failover(name, addr string) (string, error) {
+	m, err := s.master(name)
+	if err != nil {
+		return "", err
+	} else if len(m.slaves) == 0 {
+		return "", simpleError("NOGOODSLAVE No suitable replica to promote")
+	}
+
+	i := 0
+	if addr != "" {
+		for i = range m.slaves {
+			if m.slaves[i] == addr {
+				break
+			}
+		}
+		if m.slaves[i] != addr {
+			return "", fmt.Errorf("redistest: %q isn't a slave of %q", addr, name)
+		}
+	}
+
+	oldAddr := m.addr
+	m.addr, m.slaves[i] = m.slaves[i], oldAddr
+	return strings.Join([]string{
+		name, hostPort(oldAddr), hostPort(m.addr),
+	}, " "), nil
+}
+
+// hostPort returns the address with its host and port separated by a space,
+// as they are in sentinel's events
+func hostPort(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host + " " + port
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"SENTINEL": {fn: cmdSentinel, arity: -2},
+	})
+}
+
+// writeInfo writes the flat array of field/values which sentinel uses to
+// describe masters, slaves and sentinels
+func writeInfo(c *cmd, name, addr, flags string, extra ...string) {
+	host, port, _ := net.SplitHostPort(addr)
+	info := []string{
+		"name", name,
+		"ip", host,
+		"port", port,
+		"runid", sha1Hex(addr),
+		"flags", flags,
+	}
+	c.w.WriteArray(append(info, extra...))
+}
+
+func cmdSentinel(c *cmd) {
+	s := c.f.sentinel
+	if s == nil {
+		c.writeErr("ERR unknown command 'SENTINEL'")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := strings.ToUpper(c.str(0))
+	if sub == "MASTERS" && len(c.args) == 1 {
+		names := make([]string, 0, len(s.masters))
+		for name := range s.masters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.w.WriteArrayHeader(len(names))
+		for _, name := range names {
+			s.writeMaster(c, name)
+		}
+		return
+	} else if len(c.args) != 2 {
+		c.writeErr("ERR Unknown sentinel subcommand or wrong number of arguments for '" +
+			c.str(0) + "'")
+		return
+	}
+
+	name := c.str(1)
+	m, err := s.master(name)
+	if err != nil && sub != "GET-MASTER-ADDR-BY-NAME" {
+		c.w.WriteError(err)
+		return
+	}
+
+	switch sub {
+	case "MASTER":
+		s.writeMaster(c, name)
+	case "SLAVES", "REPLICAS":
+		c.w.WriteArrayHeader(len(m.slaves))
+		for _, addr := range m.slaves {
+			host, port, _ := net.SplitHostPort(m.addr)
+			writeInfo(c, addr, addr, "slave",
+				"master-link-status", "ok",
+				"master-host", host,
+				"master-port", port,
+			)
+		}
+	case "SENTINELS":
+		c.w.WriteArrayHeader(len(m.sentinels))
+		for _, addr := range m.sentinels {
+			writeInfo(c, addr, addr, "sentinel")
+		}
+	case "GET-MASTER-ADDR-BY-NAME":
+		if m == nil {
+			c.w.WriteNilArray()
+			return
+		}
+		host, port, _ := net.SplitHostPort(m.addr)
+		c.w.WriteArray([]string{host, port})
+	case "FAILOVER":
+		msg, err := s.failover(name, "")
+		if err != nil {
+			c.w.WriteError(err)
+			return
+		}
+		c.f.publish("+switch-master", []byte(msg))
+		cmdOK(c)
+	default:
+		c.writeErr("ERR Unknown sentinel subcommand or wrong number of arguments for '" +
+			c.str(0) + "'")
+	}
+}
+
+func (s *Sentinel) writeMaster(c *cmd, name string) {
+	m := s.masters[name]
+	writeInfo(c, name, m.addr, "master",
+		"num-slaves", fmt.Sprint(len(m.slaves)),
+		"num-other-sentinels", fmt.Sprint(len(m.sentinels)),
+		"quorum", "2",
+	)
+}
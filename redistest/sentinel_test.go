@@ -0,0 +1,83 @@
+package redistest
+
+import (
+	. "testing"
+
+	"github.com/kevwan/radix.v2/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSentinel(t *T) *Sentinel {
+	s, err := StartSentinel()
+	require.Nil(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSentinelCmds(t *T) {
+	s := testSentinel(t)
+	s.AddMaster("test", "127.0.0.1:8000", "127.0.0.1:8001")
+	require.Nil(t, s.SetSentinels("test", "127.0.0.1:28001"))
+	c := dialNode(t, s.Addr())
+
+	l, err := c.Cmd("SENTINEL", "MASTER", "test").List()
+	require.Nil(t, err)
+	// this is what sentinel.Client relies on
+	assert.Equal(t, "127.0.0.1", l[3])
+	assert.Equal(t, "8000", l[5])
+	var master map[string]string
+	require.Nil(t, c.Cmd("SENTINEL", "MASTER", "test").Unmarshal(&master))
+	assert.Equal(t, "test", master["name"])
+	assert.Equal(t, "master", master["flags"])
+	assert.Equal(t, "1", master["num-slaves"])
+
+	var slaves []map[string]string
+	require.Nil(t, c.Cmd("SENTINEL", "SLAVES", "test").Unmarshal(&slaves))
+	require.Len(t, slaves, 1)
+	assert.Equal(t, "8001", slaves[0]["port"])
+	assert.Equal(t, "8000", slaves[0]["master-port"])
+
+	var sentinels []map[string]string
+	require.Nil(t, c.Cmd("SENTINEL", "SENTINELS", "test").Unmarshal(&sentinels))
+	require.Len(t, sentinels, 1)
+	assert.Equal(t, "28001", sentinels[0]["port"])
+
+	assertCmd(t, c, []interface{}{"127.0.0.1", "8000"},
+		"SENTINEL", "GET-MASTER-ADDR-BY-NAME", "test")
+	assertCmd(t, c, nil, "SENTINEL", "GET-MASTER-ADDR-BY-NAME", "nope")
+	assertErr(t, c, "ERR No such master with that name",
+		"SENTINEL", "MASTER", "nope")
+
+	// a Fake which isn't a Sentinel doesn't support it
+	_, c2 := testClient(t)
+	assertErr(t, c2, "ERR unknown command 'SENTINEL'", "SENTINEL", "MASTERS")
+}
+
+func TestSentinelFailover(t *T) {
+	s := testSentinel(t)
+	s.AddMaster("test", "127.0.0.1:8000", "127.0.0.1:8001")
+	c := dialNode(t, s.Addr())
+	sub := pubsub.NewSubClient(dialNode(t, s.Addr()))
+	require.Nil(t, sub.Subscribe("+switch-master").Err)
+
+	assertReceive := func(msg string) {
+		sr := sub.Receive()
+		require.Nil(t, sr.Err)
+		assert.Equal(t, "+switch-master", sr.Channel)
+		assert.Equal(t, msg, sr.Message)
+	}
+
+	require.Nil(t, s.Failover("test", ""))
+	assert.Equal(t, "127.0.0.1:8001", s.MasterAddr("test"))
+	assertReceive("test 127.0.0.1 8000 127.0.0.1 8001")
+
+	assertCmd(t, c, "OK", "SENTINEL", "FAILOVER", "test")
+	assert.Equal(t, "127.0.0.1:8000", s.MasterAddr("test"))
+	assertReceive("test 127.0.0.1 8001 127.0.0.1 8000")
+
+	assert.NotNil(t, s.Failover("test", "127.0.0.1:9000"))
+	s.AddMaster("lonely", "127.0.0.1:9000")
+	assertErr(t, c, "NOGOODSLAVE No suitable replica to promote",
+		"SENTINEL", "FAILOVER", "lonely")
+}
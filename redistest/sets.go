@@ -0,0 +1,152 @@
+package redistest
+
+import (
+	"sort"
+)
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"SADD":      {fn: cmdSAdd, arity: -3, keys: firstKey},
+		"SREM":      {fn: cmdSRem, arity: -3, keys: firstKey},
+		"SMEMBERS":  {fn: cmdSMembers, arity: 2, keys: firstKey},
+		"SISMEMBER": {fn: cmdSIsMember, arity: 3, keys: firstKey},
+		"SCARD":     {fn: cmdSCard, arity: 2, keys: firstKey},
+		"SINTER":    {fn: cmdSetOp, arity: -2, keys: allKeys},
+		"SUNION":    {fn: cmdSetOp, arity: -2, keys: allKeys},
+		"SDIFF":     {fn: cmdSetOp, arity: -2, keys: allKeys},
+		"SSCAN":     {fn: cmdSScan, arity: -3, keys: firstKey},
+	})
+}
+
+// getSet returns the set stored at the given key. If it doesn't exist and
+// create is set a new one is created, otherwise nil is returned.
+func (c *cmd) getSet(key string, create bool) (setVal, bool) {
+	e, ok := c.lookup(key, "set")
+	if !ok {
+		return nil, false
+	} else if e == nil {
+		if !create {
+			return nil, true
+		}
+		e = c.db().put(key, setVal{})
+	}
+	return e.val.(setVal), true
+}
+
+func (s setVal) sorted() []string {
+	members := make([]string, 0, len(s))
+	for m := range s {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func cmdSAdd(c *cmd) {
+	key := c.str(0)
+	s, ok := c.getSet(key, true)
+	if !ok {
+		return
+	}
+	var n int64
+	for i := 1; i < len(c.args); i++ {
+		if _, ok := s[c.str(i)]; !ok {
+			s[c.str(i)] = struct{}{}
+			n++
+		}
+	}
+	c.db().touch(key)
+	c.w.WriteInt(n)
+}
+
+func cmdSRem(c *cmd) {
+	key := c.str(0)
+	s, ok := c.getSet(key, false)
+	if !ok {
+		return
+	}
+	var n int64
+	for i := 1; i < len(c.args); i++ {
+		if _, ok := s[c.str(i)]; ok {
+			delete(s, c.str(i))
+			n++
+		}
+	}
+	if n > 0 {
+		c.db().touch(key)
+		c.db().removeIfEmpty(key, c.db().keys[key])
+	}
+	c.w.WriteInt(n)
+}
+
+func cmdSMembers(c *cmd) {
+	s, ok := c.getSet(c.str(0), false)
+	if ok {
+		c.w.WriteArray(s.sorted())
+	}
+}
+
+func cmdSIsMember(c *cmd) {
+	s, ok := c.getSet(c.str(0), false)
+	if !ok {
+		return
+	}
+	if _, ok := s[c.str(1)]; ok {
+		c.w.WriteInt(1)
+	} else {
+		c.w.WriteInt(0)
+	}
+}
+
+func cmdSCard(c *cmd) {
+	s, ok := c.getSet(c.str(0), false)
+	if ok {
+		c.w.WriteInt(int64(len(s)))
+	}
+}
+
+// cmdSetOp implements SINTER, SUNION and SDIFF
+func cmdSetOp(c *cmd) {
+	sets := make([]setVal, len(c.args))
+	for i := range c.args {
+		var ok bool
+		if sets[i], ok = c.getSet(c.str(i), false); !ok {
+			return
+		}
+	}
+
+	res := setVal{}
+	for m := range sets[0] {
+		res[m] = struct{}{}
+	}
+	for _, s := range sets[1:] {
+		switch c.name {
+		case "SINTER":
+			for m := range res {
+				if _, ok := s[m]; !ok {
+					delete(res, m)
+				}
+			}
+		case "SUNION":
+			for m := range s {
+				res[m] = struct{}{}
+			}
+		case "SDIFF":
+			for m := range s {
+				delete(res, m)
+			}
+		}
+	}
+	c.w.WriteArray(res.sorted())
+}
+
+func cmdSScan(c *cmd) {
+	o, ok := c.parseScan(1)
+	if !ok {
+		return
+	}
+	s, ok := c.getSet(c.str(0), false)
+	if ok {
+		c.writeScan(o, s.sorted(), false, nil)
+	}
+}
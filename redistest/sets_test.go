@@ -0,0 +1,26 @@
+package redistest
+
+import (
+	. "testing"
+)
+
+func TestSets(t *T) {
+	_, c := testClient(t)
+	assertCmd(t, c, int64(3), "SADD", "s1", "a", "b", "c")
+	assertCmd(t, c, int64(1), "SADD", "s1", "a", "d")
+	assertCmd(t, c, int64(4), "SCARD", "s1")
+	assertCmd(t, c, int64(1), "SISMEMBER", "s1", "a")
+	assertCmd(t, c, int64(0), "SISMEMBER", "s1", "z")
+	assertCmd(t, c, []interface{}{"a", "b", "c", "d"}, "SMEMBERS", "s1")
+
+	assertCmd(t, c, int64(2), "SADD", "s2", "c", "e")
+	assertCmd(t, c, []interface{}{"c"}, "SINTER", "s1", "s2")
+	assertCmd(t, c, []interface{}{"a", "b", "c", "d", "e"}, "SUNION", "s1", "s2")
+	assertCmd(t, c, []interface{}{"a", "b", "d"}, "SDIFF", "s1", "s2")
+	assertCmd(t, c, []interface{}{}, "SINTER", "s1", "nope")
+
+	assertCmd(t, c, []interface{}{"0", []interface{}{"c", "e"}}, "SSCAN", "s2", 0)
+
+	assertCmd(t, c, int64(2), "SREM", "s2", "c", "e", "z")
+	assertCmd(t, c, int64(0), "EXISTS", "s2")
+}
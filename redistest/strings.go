@@ -0,0 +1,308 @@
+package redistest
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"GET":         {fn: cmdGet, arity: 2, keys: firstKey},
+		"SET":         {fn: cmdSet, arity: -3, keys: firstKey},
+		"SETNX":       {fn: cmdSetNX, arity: 3, keys: firstKey},
+		"SETEX":       {fn: cmdSetEX, arity: 4, keys: firstKey},
+		"PSETEX":      {fn: cmdSetEX, arity: 4, keys: firstKey},
+		"GETSET":      {fn: cmdGetSet, arity: 3, keys: firstKey},
+		"MGET":        {fn: cmdMGet, arity: -2, keys: allKeys},
+		"MSET":        {fn: cmdMSet, arity: -3, keys: pairKeys},
+		"INCR":        {fn: cmdIncr, arity: 2, keys: firstKey},
+		"DECR":        {fn: cmdIncr, arity: 2, keys: firstKey},
+		"INCRBY":      {fn: cmdIncr, arity: 3, keys: firstKey},
+		"DECRBY":      {fn: cmdIncr, arity: 3, keys: firstKey},
+		"INCRBYFLOAT": {fn: cmdIncrByFloat, arity: 3, keys: firstKey},
+		"APPEND":      {fn: cmdAppend, arity: 3, keys: firstKey},
+		"STRLEN":      {fn: cmdStrlen, arity: 2, keys: firstKey},
+	})
+}
+
+func (c *cmd) getString(key string) ([]byte, bool) {
+	e, ok := c.lookup(key, "string")
+	if !ok || e == nil {
+		return nil, ok
+	}
+	return e.val.([]byte), true
+}
+
+func cmdGet(c *cmd) {
+	v, ok := c.getString(c.str(0))
+	if !ok {
+		return
+	} else if v == nil {
+		c.w.WriteNil()
+		return
+	}
+	c.w.WriteBulk(v)
+}
+
+func cmdSet(c *cmd) {
+	key := c.str(0)
+	var nx, xx, keepTTL, get bool
+	var expireAt time.Time
+	for i := 2; i < len(c.args); i++ {
+		opt := strings.ToUpper(c.str(i))
+		switch opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "GET":
+			get = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(c.args) || !expireAt.IsZero() {
+				c.writeErr(errSyntax)
+				return
+			}
+			i++
+			n, ok := c.int(i)
+			if !ok {
+				return
+			} else if n <= 0 {
+				c.writeErr("ERR invalid expire time in 'set' command")
+				return
+			}
+			switch opt {
+			case "EX":
+				expireAt = c.now.Add(time.Duration(n) * time.Second)
+			case "PX":
+				expireAt = c.now.Add(time.Duration(n) * time.Millisecond)
+			case "EXAT":
+				expireAt = time.Unix(n, 0)
+			case "PXAT":
+				expireAt = time.Unix(0, n*int64(time.Millisecond))
+			}
+		default:
+			c.writeErr(errSyntax)
+			return
+		}
+	}
+	if (nx && xx) || (keepTTL && !expireAt.IsZero()) {
+		c.writeErr(errSyntax)
+		return
+	}
+
+	e := c.db().get(key, c.now)
+	var old []byte
+	if get {
+		var ok bool
+		if old, ok = c.getString(key); !ok {
+			return
+		}
+	}
+	if (nx && e != nil) || (xx && e == nil) {
+		if get {
+			c.writeBulkOrNil(old)
+		} else {
+			c.w.WriteNil()
+		}
+		return
+	}
+
+	var ttl time.Time
+	if keepTTL && e != nil {
+		ttl = e.expireAt
+	}
+	if !expireAt.IsZero() {
+		ttl = expireAt
+	}
+	c.db().put(key, copyBytes(c.args[1])).expireAt = ttl
+
+	if get {
+		c.writeBulkOrNil(old)
+	} else {
+		cmdOK(c)
+	}
+}
+
+func (c *cmd) writeBulkOrNil(b []byte) {
+	if b == nil {
+		c.w.WriteNil()
+	} else {
+		c.w.WriteBulk(b)
+	}
+}
+
+func copyBytes(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+func cmdSetNX(c *cmd) {
+	key := c.str(0)
+	if c.db().get(key, c.now) != nil {
+		c.w.WriteInt(0)
+		return
+	}
+	c.db().set(key, copyBytes(c.args[1]))
+	c.w.WriteInt(1)
+}
+
+func cmdSetEX(c *cmd) {
+	n, ok := c.int(1)
+	if !ok {
+		return
+	} else if n <= 0 {
+		c.writeErr("ERR invalid expire time in '" + strings.ToLower(c.name) + "' command")
+		return
+	}
+	unit := time.Second
+	if c.name == "PSETEX" {
+		unit = time.Millisecond
+	}
+	e := c.db().put(c.str(0), copyBytes(c.args[2]))
+	e.expireAt = c.now.Add(time.Duration(n) * unit)
+	cmdOK(c)
+}
+
+func cmdGetSet(c *cmd) {
+	key := c.str(0)
+	old, ok := c.getString(key)
+	if !ok {
+		return
+	}
+	c.db().set(key, copyBytes(c.args[1]))
+	c.writeBulkOrNil(old)
+}
+
+func cmdMGet(c *cmd) {
+	c.w.WriteArrayHeader(len(c.args))
+	for i := range c.args {
+		e := c.db().get(c.str(i), c.now)
+		if v, ok := e.value().([]byte); ok {
+			c.w.WriteBulk(v)
+		} else {
+			c.w.WriteNil()
+		}
+	}
+}
+
+// value returns the value of the entry, or nil if the entry is nil
+func (e *entry) value() interface{} {
+	if e == nil {
+		return nil
+	}
+	return e.val
+}
+
+func cmdMSet(c *cmd) {
+	if len(c.args)%2 != 0 {
+		c.writeErr("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	for i := 0; i < len(c.args); i += 2 {
+		c.db().set(c.str(i), copyBytes(c.args[i+1]))
+	}
+	cmdOK(c)
+}
+
+func cmdIncr(c *cmd) {
+	by := int64(1)
+	if len(c.args) > 1 {
+		var ok bool
+		if by, ok = c.int(1); !ok {
+			return
+		}
+	}
+	if c.name == "DECR" || c.name == "DECRBY" {
+		if by == math.MinInt64 {
+			c.writeErr("ERR decrement would overflow")
+			return
+		}
+		by = -by
+	}
+
+	key := c.str(0)
+	e, ok := c.lookup(key, "string")
+	if !ok {
+		return
+	}
+	var n int64
+	if e != nil {
+		var err error
+		if n, err = strconv.ParseInt(string(e.val.([]byte)), 10, 64); err != nil {
+			c.writeErr(errNotInt)
+			return
+		}
+	}
+	if (by > 0 && n > math.MaxInt64-by) || (by < 0 && n < math.MinInt64-by) {
+		c.writeErr(errOverflow)
+		return
+	}
+	n += by
+
+	c.setKeepTTL(key, e, []byte(strconv.FormatInt(n, 10)))
+	c.w.WriteInt(n)
+}
+
+// setKeepTTL sets the value of the key, whose current entry is e (which may be
+// nil), keeping its TTL
+func (c *cmd) setKeepTTL(key string, e *entry, val interface{}) {
+	if e == nil {
+		c.db().set(key, val)
+		return
+	}
+	e.val = val
+	c.db().touch(key)
+}
+
+func cmdIncrByFloat(c *cmd) {
+	by, ok := c.float(1)
+	if !ok {
+		return
+	}
+	key := c.str(0)
+	e, ok := c.lookup(key, "string")
+	if !ok {
+		return
+	}
+	var f float64
+	if e != nil {
+		var err error
+		if f, err = parseFloat(string(e.val.([]byte))); err != nil {
+			c.writeErr(errNotFloat)
+			return
+		}
+	}
+	f += by
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		c.writeErr("ERR increment would produce NaN or Infinity")
+		return
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	c.setKeepTTL(key, e, []byte(s))
+	c.w.WriteBulkStr(s)
+}
+
+func cmdAppend(c *cmd) {
+	key := c.str(0)
+	e, ok := c.lookup(key, "string")
+	if !ok {
+		return
+	}
+	var v []byte
+	if e != nil {
+		v = e.val.([]byte)
+	}
+	v = append(copyBytes(v), c.args[1]...)
+	c.setKeepTTL(key, e, v)
+	c.w.WriteInt(int64(len(v)))
+}
+
+func cmdStrlen(c *cmd) {
+	v, ok := c.getString(c.str(0))
+	if ok {
+		c.w.WriteInt(int64(len(v)))
+	}
+}
@@ -0,0 +1,51 @@
+package redistest
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSet(t *T) {
+	f, c := testClient(t)
+	f.SetTime(time.Unix(1500000000, 0))
+
+	assertCmd(t, c, "OK", "SET", "foo", "bar")
+	assertCmd(t, c, "bar", "GET", "foo")
+	assertCmd(t, c, nil, "SET", "foo", "baz", "NX")
+	assertCmd(t, c, nil, "SET", "nope", "baz", "XX")
+	assertCmd(t, c, "bar", "SET", "foo", "baz", "GET")
+	assertCmd(t, c, "OK", "SET", "foo", "qux", "EX", 10)
+	assertCmd(t, c, int64(10), "TTL", "foo")
+	assertCmd(t, c, "OK", "SET", "foo", "quux", "KEEPTTL")
+	assertCmd(t, c, int64(10), "TTL", "foo")
+	assertErr(t, c, "ERR syntax error", "SET", "foo", "bar", "NX", "XX")
+
+	assertCmd(t, c, int64(0), "SETNX", "foo", "bar")
+	assertCmd(t, c, int64(1), "SETNX", "new", "bar")
+	assertCmd(t, c, "OK", "SETEX", "ex", 5, "bar")
+	assertCmd(t, c, int64(5), "TTL", "ex")
+	assertCmd(t, c, "bar", "GETSET", "ex", "baz")
+	assertCmd(t, c, int64(-1), "TTL", "ex")
+
+	assertCmd(t, c, "OK", "MSET", "a", "1", "b", "2")
+	assertCmd(t, c, []interface{}{"1", nil, "2"}, "MGET", "a", "nope", "b")
+
+	assertCmd(t, c, int64(4), "APPEND", "a", "234")
+	assertCmd(t, c, int64(4), "STRLEN", "a")
+	assertCmd(t, c, int64(0), "STRLEN", "nope")
+}
+
+func TestIncr(t *T) {
+	_, c := testClient(t)
+	assertCmd(t, c, int64(1), "INCR", "n")
+	assertCmd(t, c, int64(11), "INCRBY", "n", 10)
+	assertCmd(t, c, int64(10), "DECR", "n")
+	assertCmd(t, c, int64(0), "DECRBY", "n", 10)
+	assertCmd(t, c, "1.5", "INCRBYFLOAT", "n", "1.5")
+	assertErr(t, c, "ERR value is not an integer or out of range", "INCR", "n")
+
+	require.Nil(t, c.Cmd("SET", "max", "9223372036854775807").Err)
+	assertErr(t, c, "ERR increment or decrement would overflow", "INCR", "max")
+}
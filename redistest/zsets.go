@@ -0,0 +1,391 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	addCmds(map[string]cmdInfo{
+		"ZADD":             {fn: cmdZAdd, arity: -4, keys: firstKey},
+		"ZINCRBY":          {fn: cmdZIncrBy, arity: 4, keys: firstKey},
+		"ZSCORE":           {fn: cmdZScore, arity: 3, keys: firstKey},
+		"ZREM":             {fn: cmdZRem, arity: -3, keys: firstKey},
+		"ZCARD":            {fn: cmdZCard, arity: 2, keys: firstKey},
+		"ZRANK":            {fn: cmdZRank, arity: 3, keys: firstKey},
+		"ZREVRANK":         {fn: cmdZRank, arity: 3, keys: firstKey},
+		"ZRANGE":           {fn: cmdZRange, arity: -4, keys: firstKey},
+		"ZREVRANGE":        {fn: cmdZRange, arity: -4, keys: firstKey},
+		"ZRANGEBYSCORE":    {fn: cmdZRangeByScore, arity: -4, keys: firstKey},
+		"ZREVRANGEBYSCORE": {fn: cmdZRangeByScore, arity: -4, keys: firstKey},
+		"ZCOUNT":           {fn: cmdZRangeByScore, arity: 4, keys: firstKey},
+		"ZSCAN":            {fn: cmdZScan, arity: -3, keys: firstKey},
+	})
+}
+
+// getZSet returns the sorted set stored at the given key. If it doesn't exist
+// and create is set a new one is created, otherwise nil is returned.
+func (c *cmd) getZSet(key string, create bool) (zsetVal, bool) {
+	e, ok := c.lookup(key, "zset")
+	if !ok {
+		return nil, false
+	} else if e == nil {
+		if !create {
+			return nil, true
+		}
+		e = c.db().put(key, zsetVal{})
+	}
+	return e.val.(zsetVal), true
+}
+
+// sorted returns the members of the sorted set in order, by score and then
+// lexicographically
+func (z zsetVal) sorted() []string {
+	members := make([]string, 0, len(z))
+	for m := range z {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := z[members[i]], z[members[j]]
+		if si != sj {
+			return si < sj
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
+
+func reverse(l []string) {
+	for i, j := 0, len(l)-1; i < j; i, j = i+1, j-1 {
+		l[i], l[j] = l[j], l[i]
+	}
+}
+
+func (c *cmd) writeMembers(z zsetVal, members []string, withScores bool) {
+	if !withScores {
+		c.w.WriteArray(members)
+		return
+	}
+	c.w.WriteArrayHeader(len(members) * 2)
+	for _, m := range members {
+		c.w.WriteBulkStr(m)
+		c.w.WriteBulkStr(formatFloat(z[m]))
+	}
+}
+
+func cmdZAdd(c *cmd) {
+	var nx, xx, ch, incr bool
+	i := 1
+opts:
+	for ; i < len(c.args); i++ {
+		switch strings.ToUpper(c.str(i)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break opts
+		}
+	}
+	pairs := c.args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 || (incr && len(pairs) != 2) {
+		c.writeErr(errSyntax)
+		return
+	} else if nx && xx {
+		c.writeErr("ERR XX and NX options at the same time are not compatible")
+		return
+	}
+
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		var ok bool
+		if scores[j], ok = c.float(i + j*2); !ok {
+			return
+		}
+	}
+
+	key := c.str(0)
+	z, ok := c.getZSet(key, !xx)
+	if !ok {
+		return
+	} else if z == nil {
+		if incr {
+			c.w.WriteNil()
+		} else {
+			c.w.WriteInt(0)
+		}
+		return
+	}
+
+	var added, changed int64
+	var last float64
+	for j, score := range scores {
+		m := string(pairs[j*2+1])
+		old, exists := z[m]
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if incr {
+			score += old
+			if math.IsNaN(score) {
+				c.writeErr("ERR resulting score is not a number (NaN)")
+				c.db().removeIfEmpty(key, c.db().keys[key])
+				return
+			}
+		}
+		z[m], last = score, score
+		if !exists {
+			added++
+		} else if old != score {
+			changed++
+		}
+	}
+	if added+changed > 0 {
+		c.db().touch(key)
+	} else {
+		// the key may have just been created
+		c.db().removeIfEmpty(key, c.db().keys[key])
+	}
+
+	if incr {
+		if added+changed == 0 && (nx || xx) {
+			c.w.WriteNil()
+		} else {
+			c.w.WriteBulkStr(formatFloat(last))
+		}
+	} else if ch {
+		c.w.WriteInt(added + changed)
+	} else {
+		c.w.WriteInt(added)
+	}
+}
+
+func cmdZIncrBy(c *cmd) {
+	by, ok := c.float(1)
+	if !ok {
+		return
+	}
+	key := c.str(0)
+	z, ok := c.getZSet(key, true)
+	if !ok {
+		return
+	}
+	m := c.str(2)
+	score := z[m] + by
+	if math.IsNaN(score) {
+		c.writeErr("ERR resulting score is not a number (NaN)")
+		c.db().removeIfEmpty(key, c.db().keys[key])
+		return
+	}
+	z[m] = score
+	c.db().touch(key)
+	c.w.WriteBulkStr(formatFloat(score))
+}
+
+func cmdZScore(c *cmd) {
+	z, ok := c.getZSet(c.str(0), false)
+	if !ok {
+		return
+	}
+	if score, ok := z[c.str(1)]; ok {
+		c.w.WriteBulkStr(formatFloat(score))
+	} else {
+		c.w.WriteNil()
+	}
+}
+
+func cmdZRem(c *cmd) {
+	key := c.str(0)
+	z, ok := c.getZSet(key, false)
+	if !ok {
+		return
+	}
+	var n int64
+	for i := 1; i < len(c.args); i++ {
+		if _, ok := z[c.str(i)]; ok {
+			delete(z, c.str(i))
+			n++
+		}
+	}
+	if n > 0 {
+		c.db().touch(key)
+		c.db().removeIfEmpty(key, c.db().keys[key])
+	}
+	c.w.WriteInt(n)
+}
+
+func cmdZCard(c *cmd) {
+	z, ok := c.getZSet(c.str(0), false)
+	if ok {
+		c.w.WriteInt(int64(len(z)))
+	}
+}
+
+func cmdZRank(c *cmd) {
+	z, ok := c.getZSet(c.str(0), false)
+	if !ok {
+		return
+	}
+	members := z.sorted()
+	if c.name == "ZREVRANK" {
+		reverse(members)
+	}
+	for i, m := range members {
+		if m == c.str(1) {
+			c.w.WriteInt(int64(i))
+			return
+		}
+	}
+	c.w.WriteNil()
+}
+
+// cmdZRange implements ZRANGE and ZREVRANGE, by index only
+func cmdZRange(c *cmd) {
+	start, ok := c.int(1)
+	if !ok {
+		return
+	}
+	stop, ok := c.int(2)
+	if !ok {
+		return
+	}
+	var withScores bool
+	for i := 3; i < len(c.args); i++ {
+		if strings.ToUpper(c.str(i)) != "WITHSCORES" {
+			c.writeErr(errSyntax)
+			return
+		}
+		withScores = true
+	}
+
+	z, ok := c.getZSet(c.str(0), false)
+	if !ok {
+		return
+	}
+	members := z.sorted()
+	if c.name == "ZREVRANGE" {
+		reverse(members)
+	}
+	i, j, ok := listRange(start, stop, len(members))
+	if !ok {
+		c.w.WriteArrayHeader(0)
+		return
+	}
+	c.writeMembers(z, members[i:j], withScores)
+}
+
+// scoreBound is one end of the range given to ZRANGEBYSCORE
+type scoreBound struct {
+	score     float64
+	exclusive bool
+}
+
+func parseScoreBound(s string) (scoreBound, bool) {
+	var b scoreBound
+	if strings.HasPrefix(s, "(") {
+		b.exclusive, s = true, s[1:]
+	}
+	var err error
+	if b.score, err = parseFloat(s); err != nil || math.IsNaN(b.score) {
+		return b, false
+	}
+	return b, true
+}
+
+func (b scoreBound) below(f float64) bool {
+	return b.score < f || (!b.exclusive && b.score == f)
+}
+
+func (b scoreBound) above(f float64) bool {
+	return b.score > f || (!b.exclusive && b.score == f)
+}
+
+// cmdZRangeByScore implements ZRANGEBYSCORE, ZREVRANGEBYSCORE and ZCOUNT
+func cmdZRangeByScore(c *cmd) {
+	minArg, maxArg := c.str(1), c.str(2)
+	if c.name == "ZREVRANGEBYSCORE" {
+		minArg, maxArg = maxArg, minArg
+	}
+	min, ok := parseScoreBound(minArg)
+	max, ok2 := parseScoreBound(maxArg)
+	if !ok || !ok2 {
+		c.writeErr("ERR min or max is not a float")
+		return
+	}
+
+	var withScores bool
+	offset, count := 0, -1
+	for i := 3; i < len(c.args); i++ {
+		switch strings.ToUpper(c.str(i)) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(c.args) {
+				c.writeErr(errSyntax)
+				return
+			}
+			var err, err2 error
+			offset, err = strconv.Atoi(c.str(i + 1))
+			count, err2 = strconv.Atoi(c.str(i + 2))
+			if err != nil || err2 != nil {
+				c.writeErr(errNotInt)
+				return
+			}
+			i += 2
+		default:
+			c.writeErr(errSyntax)
+			return
+		}
+	}
+
+	z, ok := c.getZSet(c.str(0), false)
+	if !ok {
+		return
+	}
+	members := z.sorted()
+	if c.name == "ZREVRANGEBYSCORE" {
+		reverse(members)
+	}
+	var inRange []string
+	for _, m := range members {
+		if min.below(z[m]) && max.above(z[m]) {
+			inRange = append(inRange, m)
+		}
+	}
+
+	if c.name == "ZCOUNT" {
+		c.w.WriteInt(int64(len(inRange)))
+		return
+	}
+	if offset < 0 || offset >= len(inRange) {
+		inRange = nil
+	} else {
+		inRange = inRange[offset:]
+		if count >= 0 && count < len(inRange) {
+			inRange = inRange[:count]
+		}
+	}
+	c.writeMembers(z, inRange, withScores)
+}
+
+func cmdZScan(c *cmd) {
+	o, ok := c.parseScan(1)
+	if !ok {
+		return
+	}
+	z, ok := c.getZSet(c.str(0), false)
+	if !ok {
+		return
+	}
+	var elems []string
+	for _, m := range z.sorted() {
+		elems = append(elems, m, formatFloat(z[m]))
+	}
+	c.writeScan(o, elems, true, nil)
+}
@@ -0,0 +1,46 @@
+package redistest
+
+import (
+	. "testing"
+)
+
+func TestZSet(t *T) {
+	_, c := testClient(t)
+	assertCmd(t, c, int64(3), "ZADD", "z", 1, "a", 2, "b", 3, "c")
+	assertCmd(t, c, int64(0), "ZADD", "z", "NX", 10, "a")
+	assertCmd(t, c, int64(0), "ZADD", "z", "XX", 10, "d")
+	assertCmd(t, c, int64(1), "ZADD", "z", "CH", 4, "c")
+	assertCmd(t, c, "6", "ZADD", "z", "INCR", 5, "a")
+	assertCmd(t, c, "2.5", "ZINCRBY", "z", "0.5", "b")
+	assertCmd(t, c, "2.5", "ZSCORE", "z", "b")
+	assertCmd(t, c, nil, "ZSCORE", "z", "nope")
+	assertCmd(t, c, int64(3), "ZCARD", "z")
+
+	// z is now b:2.5 c:4 a:6
+	assertCmd(t, c, int64(1), "ZRANK", "z", "c")
+	assertCmd(t, c, int64(0), "ZREVRANK", "z", "a")
+	assertCmd(t, c, nil, "ZRANK", "z", "nope")
+	assertCmd(t, c, []interface{}{"b", "c", "a"}, "ZRANGE", "z", 0, -1)
+	assertCmd(t, c, []interface{}{"a", "6", "c", "4"},
+		"ZREVRANGE", "z", 0, 1, "WITHSCORES")
+
+	assertCmd(t, c, []interface{}{"c", "a"}, "ZRANGEBYSCORE", "z", "(2.5", "+inf")
+	assertCmd(t, c, []interface{}{"c", "4"},
+		"ZRANGEBYSCORE", "z", "-inf", "inf", "WITHSCORES", "LIMIT", 1, 1)
+	assertCmd(t, c, []interface{}{"a", "c"}, "ZREVRANGEBYSCORE", "z", 6, 3)
+	assertCmd(t, c, int64(2), "ZCOUNT", "z", 2.5, "(6")
+	assertErr(t, c, "ERR min or max is not a float", "ZCOUNT", "z", "a", 1)
+
+	assertCmd(t, c, []interface{}{"0", []interface{}{"b", "2.5", "c", "4", "a", "6"}},
+		"ZSCAN", "z", 0)
+
+	assertCmd(t, c, int64(3), "ZREM", "z", "a", "b", "c", "d")
+	assertCmd(t, c, int64(0), "EXISTS", "z")
+}
+
+func TestZSetOrder(t *T) {
+	_, c := testClient(t)
+	// members with the same score are ordered lexicographically
+	assertCmd(t, c, int64(3), "ZADD", "z", 1, "c", 1, "a", 0, "b")
+	assertCmd(t, c, []interface{}{"b", "a", "c"}, "ZRANGE", "z", 0, -1)
+}
@@ -0,0 +1,91 @@
+package sentinel
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/kevwan/radix.v2/redistest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests run against a redistest.Sentinel, monitoring a master and slave
+// which are both redistest.Fakes, so that failovers can be triggered on demand.
+// Each Fake has the key "who" set to its own address, so it's possible to tell
+// which one a connection is to.
+
+func fakeSentinel(t *T) (*redistest.Sentinel, *Client, []string) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		f, err := redistest.Start()
+		require.Nil(t, err)
+		t.Cleanup(func() { f.Close() })
+		c := f.Client()
+		require.Nil(t, c.Cmd("SET", "who", f.Addr()).Err)
+		c.Close()
+		addrs = append(addrs, f.Addr())
+	}
+
+	s, err := redistest.StartSentinel()
+	require.Nil(t, err)
+	t.Cleanup(func() { s.Close() })
+	s.AddMaster("test", addrs[0], addrs[1])
+
+	c, err := NewClient("tcp", s.Addr(), 2, "test")
+	require.Nil(t, err)
+	return s, c, addrs
+}
+
+// masterAddr returns the address of the master the client gives out
+// connections to
+func masterAddr(t *T, c *Client) string {
+	conn, err := c.GetMaster("test")
+	require.Nil(t, err)
+	defer c.PutMaster("test", conn)
+	who, err := conn.Cmd("GET", "who").Str()
+	require.Nil(t, err)
+	return who
+}
+
+func assertMasterAddr(t *T, c *Client, addr string) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		return masterAddr(t, c) == addr
+	}, time.Second, time.Millisecond)
+}
+
+func TestFakeFailover(t *T) {
+	s, c, addrs := fakeSentinel(t)
+	assert.Equal(t, addrs[0], masterAddr(t, c))
+
+	require.Nil(t, s.Failover("test", ""))
+	assertMasterAddr(t, c, addrs[1])
+
+	require.Nil(t, s.Failover("test", ""))
+	assertMasterAddr(t, c, addrs[0])
+}
+
+func TestFakeBadMessages(t *T) {
+	s, c, addrs := fakeSentinel(t)
+
+	// none of these should cause the client to switch masters, or break
+	s.Publish("+switch-master", "")
+	s.Publish("+switch-master", "garbage")
+	s.Publish("+switch-master", "unknown 127.0.0.1 1 127.0.0.1 2")
+	s.Publish("+sdown", "master test 127.0.0.1 1")
+	assert.Equal(t, addrs[0], masterAddr(t, c))
+
+	require.Nil(t, s.Failover("test", ""))
+	assertMasterAddr(t, c, addrs[1])
+}
+
+func TestFakeSentinelDown(t *T) {
+	s, c, _ := fakeSentinel(t)
+	require.Nil(t, s.Close())
+
+	assert.Eventually(t, func() bool {
+		_, err := c.GetMaster("test")
+		cerr, ok := err.(*ClientError)
+		return ok && cerr.SentinelErr
+	}, time.Second, time.Millisecond)
+}
@@ -50,6 +50,7 @@
 package sentinel
 
 import (
+	"context"
 	"errors"
 	"strings"
 
@@ -117,6 +118,19 @@ type Client struct {
 	switchMasterCh chan *switchMaster
 }
 
+// The maximum number of active connections to allow to a single master, if
+// poolSize is larger than this then poolSize is used instead. pool.NewCustom
+// requires a maxActive, which sentinel's options have never had a field for,
+// so this matches the pool package's own default.
+const defaultMaxActive = 100
+
+func maxActive(poolSize int) int {
+	if poolSize > defaultMaxActive {
+		return poolSize
+	}
+	return defaultMaxActive
+}
+
 // DialFunc is a function which can be passed into NewClientCustom
 type DialFunc func(network, addr string) (*redis.Client, error)
 
@@ -141,23 +155,70 @@ func NewClientCustom(
 ) (
 	*Client, error,
 ) {
+	return NewClientWithOpts(Opts{
+		Network:  network,
+		Addr:     address,
+		PoolSize: poolSize,
+		Names:    names,
+		Dialer:   df,
+	})
+}
+
+// Opts are options which can be passed into NewClientWithOpts. Dialer and
+// SentinelDialer will default to redis.Dial if not set
+type Opts struct {
+
+	// Required. The network/address of the sentinel instance
+	Network, Addr string
+
+	// The size of the connection pool to use for each master
+	PoolSize int
+
+	// The names of the masters to create pools for
+	Names []string
+
+	// The function which will be used to create all new connections to the
+	// master instances. This can be used to implement authentication, TLS,
+	// custom timeouts, etc...
+	Dialer DialFunc
+
+	// The function which will be used to create the connection to the
+	// sentinel instance itself. This is separate from Dialer, since sentinels
+	// will generally have different credentials than the masters they monitor.
+	// Use this if the sentinel requires authentication or TLS, e.g. by using
+	// redis.DialTLS.
+	SentinelDialer DialFunc
+}
+
+// NewClientWithOpts is the same as NewClient, but with more fine-tuned
+// configuration options. See Opts for more available options
+func NewClientWithOpts(o Opts) (*Client, error) {
+	if o.Dialer == nil {
+		o.Dialer = redis.Dial
+	}
+	if o.SentinelDialer == nil {
+		o.SentinelDialer = redis.Dial
+	}
+	poolSize, df := o.PoolSize, o.Dialer
 
 	// We use this to fetch initial details about masters before we upgrade it
 	// to a pubsub client
-	client, err := redis.Dial(network, address)
+	client, err := o.SentinelDialer(o.Network, o.Addr)
 	if err != nil {
 		return nil, &ClientError{err: err}
 	}
 
 	masterPools := map[string]*pool.Pool{}
-	for _, name := range names {
+	for _, name := range o.Names {
 		r := client.Cmd("SENTINEL", "MASTER", name)
 		l, err := r.List()
 		if err != nil {
 			return nil, &ClientError{err: err, SentinelErr: true}
 		}
 		addr := l[3] + ":" + l[5]
-		pool, err := pool.NewCustom("tcp", addr, poolSize, (pool.DialFunc)(df))
+		pool, err := pool.NewCustom(
+			"tcp", addr, poolSize, maxActive(poolSize), (pool.DialFunc)(df),
+		)
 		if err != nil {
 			return nil, &ClientError{err: err}
 		}
@@ -209,7 +270,13 @@ func (c *Client) subSpin() {
 			alwaysErr(r.Err)
 			return
 		}
+		// +switch-master messages look like:
+		// <master name> <old ip> <old port> <new ip> <new port>
+		// anything else is ignored
 		sMsg := strings.Split(r.Message, " ")
+		if r.Channel != "+switch-master" || len(sMsg) != 5 {
+			continue
+		}
 		name := sMsg[0]
 		newAddr := sMsg[3] + ":" + sMsg[4]
 		select {
@@ -252,7 +319,9 @@ func (c *Client) spin() {
 		case sm := <-c.switchMasterCh:
 			if p, ok := c.masterPools[sm.name]; ok {
 				p.Empty()
-				p, _ = pool.NewCustom("tcp", sm.addr, c.poolSize, c.dialFunc)
+				p, _ = pool.NewCustom(
+					"tcp", sm.addr, c.poolSize, maxActive(c.poolSize), c.dialFunc,
+				)
 				c.masterPools[sm.name] = p
 			}
 
@@ -281,6 +350,42 @@ func (c *Client) GetMaster(name string) (*redis.Client, error) {
 	return ret.conn, nil
 }
 
+// GetMasterContext is like GetMaster, but will stop waiting for the
+// connection once the given context is cancelled, returning the context's
+// error. In that case the connection, if one is eventually retrieved, is put
+// back automatically.
+func (c *Client) GetMasterContext(
+	ctx context.Context, name string,
+) (
+	*redis.Client, error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req := getReq{name, make(chan *getReqRet)}
+	select {
+	case c.getCh <- &req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case ret := <-req.retCh:
+		if ret.err != nil {
+			return nil, ret.err
+		}
+		return ret.conn, nil
+	case <-ctx.Done():
+		go func() {
+			if ret := <-req.retCh; ret.err == nil {
+				c.PutMaster(name, ret.conn)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
 // PutMaster return a connection for a master of a given name
 func (c *Client) PutMaster(name string, client *redis.Client) {
 	c.putCh <- &putReq{name, client}
@@ -0,0 +1,39 @@
+// Package server implements a framework for writing servers which speak the
+// redis protocol, so that any redis client (including this library's) can
+// talk to them.
+//
+// A Server reads requests off of its connections and passes them to its
+// Handler, which writes a reply to each. A ServeMux can be used to route
+// requests to a different Handler for each command:
+//
+//	mux := server.NewServeMux()
+//	mux.HandleFunc("PING", func(w *redis.RespWriter, r *server.Request) {
+//		w.WriteSimpleStr("PONG")
+//	})
+//	mux.HandleFunc("ECHO", func(w *redis.RespWriter, r *server.Request) {
+//		if len(r.Args) != 1 {
+//			server.WrongArgs(w, r)
+//			return
+//		}
+//		w.WriteBulk(r.Args[0])
+//	})
+//
+//	s := &server.Server{Addr: ":6380", Handler: mux}
+//	if err := s.ListenAndServe(); err != server.ErrServerClosed {
+//		// handle err
+//	}
+//
+// Pipelined requests are supported, with their replies being buffered and
+// written all at once. Requests may be sent in either the multibulk format
+// used by clients or the inline format used when typing into telnet.
+//
+// Shutdown stops the Server gracefully, waiting for every connection to reply
+// to the requests it has already been sent before closing it:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	if err := s.Shutdown(ctx); err != nil {
+//		// not all connections could be closed in time, force them closed
+//		s.Close()
+//	}
+package server
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kevwan/radix.v2/redis"
+)
+
+// Request is a single command sent by a client
+type Request struct {
+	// The name of the command, as it was sent by the client
+	Cmd string
+
+	// The arguments to the command, not including its name. These are owned
+	// by the handler, and may be held on to after it returns.
+	Args [][]byte
+
+	// The connection the command was sent on
+	Conn *Conn
+}
+
+// Handler handles the requests sent to a Server. ServeRESP must write one
+// reply to the RespWriter (though that reply may be an array with any number
+// of elements), or more for commands which redis replies to more than once,
+// like SUBSCRIBE. If it writes nothing an error reply is written on its
+// behalf. The RespWriter shouldn't be flushed or held on to after ServeRESP
+// returns, the Server takes care of flushing replies.
+//
+// Requests from the same connection are handled one at a time, in the order
+// they were sent, while requests from different connections are handled
+// concurrently.
+type Handler interface {
+	ServeRESP(w *redis.RespWriter, r *Request)
+}
+
+// HandlerFunc is an adapter which allows a function to be used as a Handler
+type HandlerFunc func(w *redis.RespWriter, r *Request)
+
+// ServeRESP calls fn(w, r)
+func (fn HandlerFunc) ServeRESP(w *redis.RespWriter, r *Request) {
+	fn(w, r)
+}
+
+// ServeMux is a Handler which dispatches each request to the Handler
+// registered for its command. Commands are matched case-insensitively.
+// Requests for commands which have no Handler get an "unknown command" error,
+// unless NotFound is set.
+type ServeMux struct {
+	// If set this is used for requests for commands which have no Handler
+	NotFound Handler
+
+	l sync.RWMutex
+	m map[string]Handler
+}
+
+// NewServeMux returns a new, empty, ServeMux
+func NewServeMux() *ServeMux {
+	return &ServeMux{m: map[string]Handler{}}
+}
+
+// Handle registers the Handler for the given command. It panics if a Handler
+// has already been registered for the command.
+func (mux *ServeMux) Handle(cmd string, h Handler) {
+	if h == nil {
+		panic("server: nil handler")
+	}
+	cmd = strings.ToUpper(cmd)
+
+	mux.l.Lock()
+	defer mux.l.Unlock()
+	if mux.m == nil {
+		mux.m = map[string]Handler{}
+	}
+	if _, ok := mux.m[cmd]; ok {
+		panic("server: multiple registrations for " + cmd)
+	}
+	mux.m[cmd] = h
+}
+
+// HandleFunc registers the handler function for the given command
+func (mux *ServeMux) HandleFunc(cmd string, fn func(w *redis.RespWriter, r *Request)) {
+	mux.Handle(cmd, HandlerFunc(fn))
+}
+
+// Handler returns the Handler which would be used for the given command, or
+// nil if there isn't one
+func (mux *ServeMux) Handler(cmd string) Handler {
+	mux.l.RLock()
+	defer mux.l.RUnlock()
+	return mux.m[strings.ToUpper(cmd)]
+}
+
+// ServeRESP dispatches the request to the Handler registered for its command
+func (mux *ServeMux) ServeRESP(w *redis.RespWriter, r *Request) {
+	if h := mux.Handler(r.Cmd); h != nil {
+		h.ServeRESP(w, r)
+	} else if mux.NotFound != nil {
+		mux.NotFound.ServeRESP(w, r)
+	} else {
+		w.WriteError(fmt.Errorf("ERR unknown command '%s'", r.Cmd))
+	}
+}
+
+// WrongArgs writes the error redis returns when a command is given the wrong
+// number of arguments
+func WrongArgs(w *redis.RespWriter, r *Request) error {
+	return w.WriteError(fmt.Errorf(
+		"ERR wrong number of arguments for '%s' command", strings.ToLower(r.Cmd),
+	))
+}
+
+var errNoReply = errors.New("ERR handler did not reply")
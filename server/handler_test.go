@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	. "testing"
+
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveOne passes a single request to the Handler and returns the reply it
+// wrote
+func serveOne(h Handler, args ...string) *redis.Resp {
+	r := &Request{Cmd: args[0]}
+	for _, arg := range args[1:] {
+		r.Args = append(r.Args, []byte(arg))
+	}
+	buf := new(bytes.Buffer)
+	w := redis.NewRespWriter(buf)
+	h.ServeRESP(w, r)
+	w.Flush()
+	return redis.NewRespReader(buf).Read()
+}
+
+func TestServeMux(t *T) {
+	mux := NewServeMux()
+	mux.HandleFunc("ping", func(w *redis.RespWriter, r *Request) {
+		w.WriteSimpleStr("PONG")
+	})
+	mux.HandleFunc("ECHO", func(w *redis.RespWriter, r *Request) {
+		if len(r.Args) != 1 {
+			WrongArgs(w, r)
+			return
+		}
+		w.WriteBulk(r.Args[0])
+	})
+
+	for _, cmd := range []string{"PING", "ping", "Ping"} {
+		s, err := serveOne(mux, cmd).Str()
+		require.Nil(t, err)
+		assert.Equal(t, "PONG", s)
+	}
+
+	s, err := serveOne(mux, "echo", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+
+	r := serveOne(mux, "ECHO")
+	assert.True(t, r.IsType(redis.AppErr))
+	assert.Equal(t, "ERR wrong number of arguments for 'echo' command", r.Err.Error())
+
+	r = serveOne(mux, "FOO", "bar")
+	assert.True(t, r.IsType(redis.AppErr))
+	assert.Equal(t, "ERR unknown command 'FOO'", r.Err.Error())
+
+	assert.NotNil(t, mux.Handler("Echo"))
+	assert.Nil(t, mux.Handler("FOO"))
+
+	mux.NotFound = HandlerFunc(func(w *redis.RespWriter, r *Request) {
+		w.WriteSimpleStr("not found")
+	})
+	s, err = serveOne(mux, "FOO").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "not found", s)
+
+	assert.Panics(t, func() {
+		mux.HandleFunc("Ping", func(*redis.RespWriter, *Request) {})
+	})
+	assert.Panics(t, func() { mux.Handle("FOO", nil) })
+
+	// The zero value is usable too
+	var zero ServeMux
+	zero.Handle("PING", mux.Handler("PING"))
+	s, err = serveOne(&zero, "PING").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", s)
+}
@@ -0,0 +1,421 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/kevwan/radix.v2/redis"
+)
+
+// ErrServerClosed is returned from Serve and ListenAndServe once Shutdown or
+// Close has been called
+var ErrServerClosed = errors.New("server closed")
+
+// Server serves the redis protocol, passing the requests it reads to a
+// Handler and writing the replies back. The zero value isn't usable, at least
+// the Handler must be set. A Server shouldn't be copied once it's been used.
+type Server struct {
+	// The address to listen on when ListenAndServe is called, defaults to
+	// ":6379"
+	Addr string
+
+	// The Handler all requests are passed to, typically a ServeMux
+	Handler Handler
+
+	// Limits on the size of the requests which will be read, see
+	// redis.ReadLimits. A connection which sends a request exceeding them gets
+	// an error and is closed. Any limits left as zero are taken from
+	// redis.DefaultRequestLimits, as with redis.RespReader's ReadRequest.
+	ReadLimits redis.ReadLimits
+
+	// If set connections which don't send a request for this long are closed
+	IdleTimeout time.Duration
+
+	// If set panics in handlers are logged here, rather than through the log
+	// package's standard logger
+	ErrorLog *log.Logger
+
+	l         sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*Conn]struct{}
+	closing   bool
+	wg        sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP network address s.Addr and then calls
+// Serve. It always returns a non-nil error, ErrServerClosed after Shutdown or
+// Close.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":6379"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the given net.Listener, serving each one in its
+// own go-routine. The net.Listener is closed when Serve returns. It always
+// returns a non-nil error, ErrServerClosed after Shutdown or Close.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l)
+
+	var backoff time.Duration
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				if backoff == 0 {
+					backoff = 5 * time.Millisecond
+				} else if backoff *= 2; backoff > time.Second {
+					backoff = time.Second
+				}
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
+
+		c := s.newConn(nc)
+		if !s.trackConn(c) {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go c.serve()
+	}
+}
+
+// ServeConn serves a single connection which has already been established,
+// e.g. one end of a net.Pipe, in its own go-routine. If the Server has been
+// shut down the connection is closed and ErrServerClosed is returned.
+func (s *Server) ServeConn(nc net.Conn) error {
+	c := s.newConn(nc)
+	if !s.trackConn(c) {
+		nc.Close()
+		return ErrServerClosed
+	}
+	go c.serve()
+	return nil
+}
+
+// Shutdown gracefully shuts down the Server. It stops accepting new
+// connections and closes idle ones. Connections which are busy are closed as
+// soon as they've replied to all the requests they've already been sent.
+// Shutdown returns once all connections are closed, or with the context's
+// error if it's done first, in which case connections may still be open (see
+// Close).
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.l.Lock()
+	s.closing = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.closeIfIdle()
+	}
+	s.l.Unlock()
+
+	doneCh := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes all listeners and connections, without waiting for
+// requests in progress to be replied to
+func (s *Server) Close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.closing = true
+	var err error
+	for l := range s.listeners {
+		if lerr := l.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	return err
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.closing
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = map[net.Listener]struct{}{}
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	l.Close()
+	delete(s.listeners, l)
+}
+
+func (s *Server) trackConn(c *Conn) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = map[*Conn]struct{}{}
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(c *Conn) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.conns, c)
+	s.wg.Done()
+}
+
+// Conn is a single client connection to a Server
+type Conn struct {
+	s  *Server
+	nc net.Conn
+	br *bufio.Reader
+	rr *redis.RespReader
+	cw *countWriter
+
+	// wl protects rw, which is written to by the handlers and by Write
+	wl sync.Mutex
+	rw *redis.RespWriter
+
+	// protected by s.l
+	idle, closed bool
+
+	// only touched by the connection's own go-routine
+	closeAfterReply bool
+	vals            map[interface{}]interface{}
+	onClose         []func()
+}
+
+// countWriter counts the bytes written through it, so that the Server can
+// tell whether a handler wrote a reply
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (s *Server) newConn(nc net.Conn) *Conn {
+	c := &Conn{
+		s:  s,
+		nc: nc,
+		br: bufio.NewReader(nc),
+		cw: &countWriter{w: nc},
+	}
+	c.rr = redis.NewRespReader(c.br)
+	c.rr.SetLimits(s.ReadLimits)
+	c.rw = redis.NewRespWriter(c.cw)
+	return c
+}
+
+// RemoteAddr returns the address of the client
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.nc.RemoteAddr()
+}
+
+// Get returns the value set on the connection for the given key, or nil. Along
+// with Set this can be used by handlers to keep per-connection state (e.g. the
+// selected database). It may only be called from within a handler.
+func (c *Conn) Get(key interface{}) interface{} {
+	return c.vals[key]
+}
+
+// Set sets a value on the connection, see Get. It may only be called from
+// within a handler.
+func (c *Conn) Set(key, val interface{}) {
+	if c.vals == nil {
+		c.vals = map[interface{}]interface{}{}
+	}
+	c.vals[key] = val
+}
+
+// CloseAfterReply causes the connection to be closed once the reply to the
+// current request has been written, e.g. for implementing QUIT. It may only be
+// called from within a handler.
+func (c *Conn) CloseAfterReply() {
+	c.closeAfterReply = true
+}
+
+// OnClose registers fn to be called once the connection has been closed, e.g.
+// to clean up state kept for it elsewhere. Functions are called in the order
+// they were registered, before Shutdown considers the connection gone. It may
+// only be called from within a handler.
+func (c *Conn) OnClose(fn func()) {
+	c.onClose = append(c.onClose, fn)
+}
+
+// Write calls fn with the connection's RespWriter and then flushes it. It's
+// for writing to the connection outside of a handler, e.g. to push pub/sub
+// messages to it, and is safe to call from any go-routine except that of a
+// handler on the same connection (which should write to its own RespWriter).
+func (c *Conn) Write(fn func(w *redis.RespWriter) error) error {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	if err := fn(c.rw); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.nc.Close()
+}
+
+func (c *Conn) flush() error {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	return c.rw.Flush()
+}
+
+// setIdle marks the connection as waiting for a request, returning false if
+// the Server is shutting down and the connection should be closed instead
+func (c *Conn) setIdle(idle bool) bool {
+	c.s.l.Lock()
+	defer c.s.l.Unlock()
+	c.idle = idle
+	return !c.s.closing && !c.closed
+}
+
+// closeIfIdle is called with s.l held
+func (c *Conn) closeIfIdle() {
+	if c.idle {
+		c.closed = true
+		c.nc.Close()
+	}
+}
+
+func (c *Conn) serve() {
+	defer c.s.untrackConn(c)
+	defer func() {
+		for _, fn := range c.onClose {
+			fn()
+		}
+	}()
+	defer c.nc.Close()
+
+	for {
+		if c.closeAfterReply {
+			c.flush()
+			return
+		}
+
+		// Pipelined requests are all handled before their replies are flushed,
+		// and before the connection can be considered idle
+		if c.br.Buffered() == 0 {
+			if err := c.flush(); err != nil || !c.setIdle(true) {
+				return
+			}
+			if c.s.IdleTimeout > 0 {
+				c.nc.SetReadDeadline(time.Now().Add(c.s.IdleTimeout))
+			}
+			_, err := c.br.Peek(1)
+			if !c.setIdle(false) || err != nil {
+				return
+			}
+			if c.s.IdleTimeout > 0 {
+				c.nc.SetReadDeadline(time.Time{})
+			}
+		}
+
+		args, err := c.rr.ReadRequest()
+		if err != nil {
+			if errors.Is(err, redis.ErrProtocol) || errors.Is(err, redis.ErrReadLimit) {
+				c.Write(func(w *redis.RespWriter) error {
+					return w.WriteError(errors.New("ERR " + err.Error()))
+				})
+			}
+			return
+		}
+
+		if !c.handle(args) {
+			return
+		}
+	}
+}
+
+// handle passes a single request to the Server's Handler, returning false if
+// the handler panicked, in which case the panic is logged
+func (c *Conn) handle(args [][]byte) (ok bool) {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	defer func() {
+		if err := recover(); err != nil {
+			c.s.logf(
+				"server: panic serving %v: %v\n%s",
+				c.nc.RemoteAddr(), err, debug.Stack(),
+			)
+			ok = false
+		}
+	}()
+
+	before := c.cw.n + int64(c.rw.Buffered())
+	c.s.Handler.ServeRESP(c.rw, &Request{
+		Cmd:  string(args[0]),
+		Args: args[1:],
+		Conn: c,
+	})
+	if c.cw.n+int64(c.rw.Buffered()) == before {
+		c.rw.WriteError(errNoReply)
+	}
+	return true
+}
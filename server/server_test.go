@@ -0,0 +1,410 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer starts the given Server on a random local port, returning the
+// address it's listening on and a channel which Serve's return is written to
+func testServer(t *T, s *Server) (string, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve(l) }()
+	return l.Addr().String(), errCh
+}
+
+func testMux() *ServeMux {
+	var l sync.Mutex
+	m := map[string][]byte{}
+
+	mux := NewServeMux()
+	mux.HandleFunc("PING", func(w *redis.RespWriter, r *Request) {
+		w.WriteSimpleStr("PONG")
+	})
+	mux.HandleFunc("SET", func(w *redis.RespWriter, r *Request) {
+		if len(r.Args) != 2 {
+			WrongArgs(w, r)
+			return
+		}
+		l.Lock()
+		m[string(r.Args[0])] = r.Args[1]
+		l.Unlock()
+		w.WriteSimpleStr("OK")
+	})
+	mux.HandleFunc("GET", func(w *redis.RespWriter, r *Request) {
+		if len(r.Args) != 1 {
+			WrongArgs(w, r)
+			return
+		}
+		l.Lock()
+		v, ok := m[string(r.Args[0])]
+		l.Unlock()
+		if !ok {
+			w.WriteNil()
+			return
+		}
+		w.WriteBulk(v)
+	})
+	mux.HandleFunc("INCRCONN", func(w *redis.RespWriter, r *Request) {
+		// a per-connection counter
+		n, _ := r.Conn.Get("n").(int64)
+		n++
+		r.Conn.Set("n", n)
+		w.WriteInt(n)
+	})
+	mux.HandleFunc("QUIT", func(w *redis.RespWriter, r *Request) {
+		r.Conn.CloseAfterReply()
+		w.WriteSimpleStr("OK")
+	})
+	mux.HandleFunc("NOREPLY", func(w *redis.RespWriter, r *Request) {})
+	mux.HandleFunc("PANIC", func(w *redis.RespWriter, r *Request) {
+		panic("oh no")
+	})
+	return mux
+}
+
+// logBuffer is a bytes.Buffer which a Server's ErrorLog can write to while
+// a test reads it
+type logBuffer struct {
+	l   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *logBuffer) Write(b []byte) (int, error) {
+	lb.l.Lock()
+	defer lb.l.Unlock()
+	return lb.buf.Write(b)
+}
+
+func (lb *logBuffer) String() string {
+	lb.l.Lock()
+	defer lb.l.Unlock()
+	return lb.buf.String()
+}
+
+func TestServer(t *T) {
+	logBuf := new(logBuffer)
+	s := &Server{Handler: testMux(), ErrorLog: log.New(logBuf, "", 0)}
+	defer s.Close()
+	addr, _ := testServer(t, s)
+
+	c, err := redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+
+	str, err := c.Cmd("PING").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", str)
+
+	require.Nil(t, c.Cmd("SET", "foo", "bar\r\nbaz").Err)
+	str, err = c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar\r\nbaz", str)
+	assert.True(t, c.Cmd("GET", "nope").IsType(redis.Nil))
+
+	var rerr *redis.Error
+	r := c.Cmd("NOPE")
+	require.True(t, errors.As(r.Err, &rerr))
+	assert.Equal(t, "ERR", rerr.Prefix)
+
+	r = c.Cmd("NOREPLY")
+	assert.True(t, r.IsType(redis.AppErr))
+	assert.Equal(t, errNoReply.Error(), r.Err.Error())
+
+	// Pipelined commands
+	for i := 0; i < 100; i++ {
+		c.PipeAppend("SET", "key"+strconv.Itoa(i), i)
+		c.PipeAppend("GET", "key"+strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		require.Nil(t, c.PipeResp().Err)
+		n, err := c.PipeResp().Int()
+		require.Nil(t, err)
+		assert.Equal(t, i, n)
+	}
+
+	// Per-connection state
+	c2, err := redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c2.Close()
+	for i := 1; i <= 3; i++ {
+		n, err := c.Cmd("INCRCONN").Int()
+		require.Nil(t, err)
+		assert.Equal(t, i, n)
+	}
+	n, err := c2.Cmd("INCRCONN").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// QUIT replies and then closes the connection
+	require.Nil(t, c2.Cmd("QUIT").Err)
+	assert.True(t, c2.Cmd("PING").IsType(redis.IOErr))
+
+	// A panicking handler closes the connection, but not the server, and the
+	// panic is logged along with where it happened
+	assert.True(t, c.Cmd("PANIC").IsType(redis.IOErr))
+	logged := logBuf.String()
+	assert.Contains(t, logged, "panic serving")
+	assert.Contains(t, logged, "oh no")
+	assert.Contains(t, logged, "server_test.go")
+	c, err = redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+	require.Nil(t, c.Cmd("PING").Err)
+}
+
+func TestServerRaw(t *T) {
+	s := &Server{
+		Handler:    testMux(),
+		ReadLimits: redis.ReadLimits{MaxBulkLen: 10},
+	}
+	defer s.Close()
+	addr, _ := testServer(t, s)
+
+	// Inline commands, pipelined
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "PING\r\nSET foo \"bar baz\"\nGET foo\r\n")
+	require.Nil(t, err)
+	expected := "+PONG\r\n+OK\r\n$7\r\nbar baz\r\n"
+	buf := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	assert.Equal(t, expected, string(buf))
+
+	// Malformed requests get an error, and then the connection is closed
+	for _, req := range []string{
+		"SET foo \"bar\r\n",
+		"*1\r\n:1\r\n",
+		"*2\r\n$3\r\nGET\r\n$11\r\n",
+	} {
+		conn, err := net.Dial("tcp", addr)
+		require.Nil(t, err)
+		_, err = io.WriteString(conn, req)
+		require.Nil(t, err)
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.Nil(t, err)
+		assert.Equal(t, "-ERR ", line[:5])
+		_, err = conn.Read(buf)
+		assert.Equal(t, io.EOF, err)
+		conn.Close()
+	}
+}
+
+func TestServerShutdown(t *T) {
+	startedCh, releaseCh := make(chan struct{}), make(chan struct{})
+	mux := testMux()
+	mux.HandleFunc("SLOW", func(w *redis.RespWriter, r *Request) {
+		close(startedCh)
+		<-releaseCh
+		w.WriteSimpleStr("SLOW")
+	})
+	s := &Server{Handler: mux}
+	addr, serveErrCh := testServer(t, s)
+
+	idle, err := redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer idle.Close()
+	require.Nil(t, idle.Cmd("PING").Err)
+
+	busy, err := redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer busy.Close()
+	busy.PipeAppend("SLOW")
+	busy.PipeAppend("PING")
+	busyCh := make(chan []*redis.Resp)
+	go func() {
+		busyCh <- []*redis.Resp{busy.PipeResp(), busy.PipeResp()}
+	}()
+	<-startedCh
+
+	shutdownCh := make(chan error)
+	go func() { shutdownCh <- s.Shutdown(context.Background()) }()
+
+	// Serve returns, and the idle connection is closed, straight away
+	assert.Equal(t, ErrServerClosed, <-serveErrCh)
+	assert.True(t, idle.Cmd("PING").IsType(redis.IOErr))
+	_, err = redis.Dial("tcp", addr)
+	assert.NotNil(t, err)
+
+	// Shutdown waits for the busy connection to reply to everything it was
+	// sent
+	select {
+	case <-shutdownCh:
+		t.Fatal("Shutdown returned before busy connection replied")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(releaseCh)
+	rr := <-busyCh
+	assert.Nil(t, rr[0].Err)
+	assert.Nil(t, rr[1].Err)
+	assert.Nil(t, <-shutdownCh)
+	assert.True(t, busy.Cmd("PING").IsType(redis.IOErr))
+}
+
+func TestServerShutdownTimeout(t *T) {
+	startedCh, releaseCh := make(chan struct{}), make(chan struct{})
+	defer close(releaseCh)
+	mux := testMux()
+	mux.HandleFunc("SLOW", func(w *redis.RespWriter, r *Request) {
+		close(startedCh)
+		<-releaseCh
+		w.WriteSimpleStr("SLOW")
+	})
+	s := &Server{Handler: mux}
+	addr, _ := testServer(t, s)
+
+	busy, err := redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer busy.Close()
+	busyCh := make(chan *redis.Resp)
+	go func() { busyCh <- busy.Cmd("SLOW") }()
+	<-startedCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+
+	// Close doesn't wait
+	require.Nil(t, s.Close())
+	assert.True(t, (<-busyCh).IsType(redis.IOErr))
+}
+
+func TestServerIdleTimeout(t *T) {
+	s := &Server{Handler: testMux(), IdleTimeout: 50 * time.Millisecond}
+	defer s.Close()
+	addr, _ := testServer(t, s)
+
+	c, err := redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+
+	// Busy connections aren't closed
+	for i := 0; i < 5; i++ {
+		require.Nil(t, c.Cmd("PING").Err)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, c.Cmd("PING").IsType(redis.IOErr))
+}
+
+func TestConnWrite(t *T) {
+	connCh := make(chan *Conn, 1)
+	mux := testMux()
+	mux.HandleFunc("SUBSCRIBE", func(w *redis.RespWriter, r *Request) {
+		connCh <- r.Conn
+		w.WriteValue([]interface{}{"subscribe", r.Args[0], 1})
+	})
+	s := &Server{Handler: mux}
+	defer s.Close()
+	addr, _ := testServer(t, s)
+
+	c, err := redis.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer c.Close()
+	require.Nil(t, c.Cmd("SUBSCRIBE", "foo").Err)
+
+	conn := <-connCh
+	for i := 0; i < 3; i++ {
+		err := conn.Write(func(w *redis.RespWriter) error {
+			return w.WriteValue([]interface{}{"message", "foo", strconv.Itoa(i)})
+		})
+		require.Nil(t, err)
+		l, err := c.ReadResp().List()
+		require.Nil(t, err)
+		assert.Equal(t, []string{"message", "foo", strconv.Itoa(i)}, l)
+	}
+}
+
+func TestConnOnClose(t *T) {
+	closedCh := make(chan int, 2)
+	mux := testMux()
+	mux.HandleFunc("WATCHME", func(w *redis.RespWriter, r *Request) {
+		r.Conn.OnClose(func() { closedCh <- 1 })
+		r.Conn.OnClose(func() { closedCh <- 2 })
+		w.WriteSimpleStr("OK")
+	})
+	s := &Server{Handler: mux}
+	cconn, sconn := net.Pipe()
+	require.Nil(t, s.ServeConn(sconn))
+
+	c := redis.NewClient(cconn)
+	require.Nil(t, c.Cmd("WATCHME").Err)
+	assert.Empty(t, closedCh)
+
+	// the callbacks are run, in order, before Shutdown returns
+	c.Close()
+	require.Nil(t, s.Shutdown(context.Background()))
+	require.Len(t, closedCh, 2)
+	assert.Equal(t, 1, <-closedCh)
+	assert.Equal(t, 2, <-closedCh)
+}
+
+func TestServeConn(t *T) {
+	s := &Server{Handler: testMux()}
+	cconn, sconn := net.Pipe()
+	require.Nil(t, s.ServeConn(sconn))
+
+	c := redis.NewClient(cconn)
+	defer c.Close()
+	require.Nil(t, c.Cmd("SET", "foo", "bar").Err)
+	str, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", str)
+
+	require.Nil(t, s.Shutdown(context.Background()))
+	assert.True(t, c.Cmd("PING").IsType(redis.IOErr))
+
+	_, sconn = net.Pipe()
+	assert.Equal(t, ErrServerClosed, s.ServeConn(sconn))
+}
+
+func TestServeConnHostile(t *T) {
+	s := &Server{Handler: testMux()}
+	defer s.Close()
+
+	cconn, sconn := net.Pipe()
+	require.Nil(t, s.ServeConn(sconn))
+	c := redis.NewClient(cconn)
+	defer c.Close()
+	require.Nil(t, c.Cmd("PING").Err)
+
+	// Requests claiming to be huge, without any limits set on the Server, get
+	// an error and only their own connection is closed
+	for _, req := range []string{
+		"*4000000000000000000\r\n",
+		"*1\r\n$4000000000000000000\r\n",
+	} {
+		hconn, sconn := net.Pipe()
+		require.Nil(t, s.ServeConn(sconn))
+		go io.WriteString(hconn, req)
+
+		br := bufio.NewReader(hconn)
+		line, err := br.ReadString('\n')
+		require.Nil(t, err)
+		assert.Equal(t, "-ERR ", line[:5], req)
+		_, err = br.ReadByte()
+		assert.Equal(t, io.EOF, err, req)
+		hconn.Close()
+
+		require.Nil(t, c.Cmd("PING").Err)
+	}
+}
@@ -0,0 +1,275 @@
+// Package testutil contains helpers for testing how code which uses radix
+// copes with a misbehaving network.
+package testutil
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Proxy is a TCP proxy which sits between clients and a redis server (real or
+// fake), and which can be told to inject faults into the connections going
+// through it at any time: adding latency, pausing, dropping or resetting
+// connections, and truncating or corrupting replies.
+//
+//	p, err := testutil.NewProxy("127.0.0.1:6379")
+//	if err != nil {
+//		// handle err
+//	}
+//	defer p.Close()
+//
+//	client, err := redis.DialTimeout("tcp", p.Addr(), 100*time.Millisecond)
+//	...
+//	p.SetLatency(time.Second)
+//	// client's commands will now time out
+//
+// All methods are safe to call from multiple go-routines, and faults apply to
+// connections which are already open as well as new ones.
+type Proxy struct {
+	l      net.Listener
+	target string
+
+	mu       sync.Mutex
+	conns    map[*proxyConn]struct{}
+	latency  time.Duration
+	resumeCh chan struct{} // non-nil while paused, closed on Resume
+	truncate int
+	corrupt  func([]byte)
+	closed   bool
+}
+
+// proxyConn is a single client connection and its connection to the target
+type proxyConn struct {
+	client, server net.Conn
+	closeOnce      sync.Once
+
+	// bytes of replies forwarded since TruncateReplies was last called,
+	// protected by the Proxy's mu
+	replied int
+}
+
+func (pc *proxyConn) close() {
+	pc.closeOnce.Do(func() {
+		pc.client.Close()
+		pc.server.Close()
+	})
+}
+
+// NewProxy returns a Proxy which is listening on a random port on the loopback
+// interface, and which forwards connections to the given address
+func NewProxy(target string) (*Proxy, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		l:        l,
+		target:   target,
+		conns:    map[*proxyConn]struct{}{},
+		truncate: -1,
+	}
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the Proxy is listening on, which clients should
+// connect to instead of the target
+func (p *Proxy) Addr() string {
+	return p.l.Addr().String()
+}
+
+// Target returns the address the Proxy forwards connections to
+func (p *Proxy) Target() string {
+	return p.target
+}
+
+func (p *Proxy) serve() {
+	for {
+		client, err := p.l.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+
+		pc := &proxyConn{client: client, server: server}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			pc.close()
+			return
+		}
+		p.conns[pc] = struct{}{}
+		p.mu.Unlock()
+
+		go p.pipe(pc, pc.server, pc.client, false)
+		go p.pipe(pc, pc.client, pc.server, true)
+	}
+}
+
+// pipe copies data read from src to dst until either is closed, applying
+// whatever faults are set. Replies are the data going from the server to the
+// client.
+func (p *Proxy) pipe(pc *proxyConn, dst, src net.Conn, replies bool) {
+	defer func() {
+		pc.close()
+		p.mu.Lock()
+		delete(p.conns, pc)
+		p.mu.Unlock()
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 && !p.forward(pc, dst, buf[:n], replies) {
+			return
+		} else if err != nil {
+			return
+		}
+	}
+}
+
+// forward writes the data to dst once any latency or pause is over, returning
+// false if the connection should be closed
+func (p *Proxy) forward(pc *proxyConn, dst net.Conn, b []byte, replies bool) bool {
+	p.mu.Lock()
+	resumeCh := p.resumeCh
+	p.mu.Unlock()
+	if resumeCh != nil {
+		<-resumeCh
+	}
+
+	// each fault is only checked once the data has got as far as it, so that
+	// they can all be changed while the data is paused
+	p.mu.Lock()
+	latency := p.latency
+	p.mu.Unlock()
+	time.Sleep(latency)
+
+	if !replies {
+		_, err := dst.Write(b)
+		return err == nil
+	}
+
+	p.mu.Lock()
+	truncate, corrupt := p.truncate, p.corrupt
+	left := truncate - pc.replied
+	pc.replied += len(b)
+	p.mu.Unlock()
+
+	if corrupt != nil {
+		corrupt(b)
+	}
+	if truncate >= 0 && left < len(b) {
+		if left > 0 {
+			dst.Write(b[:left])
+		}
+		return false
+	}
+	_, err := dst.Write(b)
+	return err == nil
+}
+
+// SetLatency sets a delay which is added before forwarding any data, in either
+// direction. A latency of zero removes it.
+func (p *Proxy) SetLatency(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latency = d
+}
+
+// Pause stops the Proxy forwarding any data, in either direction, until Resume
+// is called. Connections stay open, so clients will see their reads time out
+// (or hang, if they don't have a timeout).
+func (p *Proxy) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumeCh == nil {
+		p.resumeCh = make(chan struct{})
+	}
+}
+
+// Resume starts the Proxy forwarding data again after a Pause, including any
+// data which was held up by it
+func (p *Proxy) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumeCh != nil {
+		close(p.resumeCh)
+		p.resumeCh = nil
+	}
+}
+
+// TruncateReplies causes each connection to be closed once n more bytes of
+// replies have been forwarded on it from the target, cutting short whichever
+// reply those bytes end in. Bytes are counted per connection, from when
+// TruncateReplies is called or the connection is opened, whichever is later,
+// however the target happens to write them. A negative n stops replies being
+// truncated.
+func (p *Proxy) TruncateReplies(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.truncate = n
+	for pc := range p.conns {
+		pc.replied = 0
+	}
+}
+
+// CorruptReplies sets a function which is called on all data forwarded from
+// the target, and which may modify it in place, e.g. to flip some bits. A nil
+// function stops replies being corrupted.
+//
+//	// replace the type byte of every reply with an invalid one
+//	p.CorruptReplies(func(b []byte) { b[0] = '!' })
+func (p *Proxy) CorruptReplies(fn func(b []byte)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.corrupt = fn
+}
+
+// NumConns returns the number of connections currently open through the Proxy
+func (p *Proxy) NumConns() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// DropConns closes all open connections, both to the clients and to the
+// target, as if the server had closed them. New connections can still be made.
+func (p *Proxy) DropConns() {
+	p.closeConns(false)
+}
+
+// ResetConns is like DropConns, but the connections to the clients are reset
+// (i.e. a TCP RST is sent) rather than being closed cleanly
+func (p *Proxy) ResetConns() {
+	p.closeConns(true)
+}
+
+func (p *Proxy) closeConns(reset bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pc := range p.conns {
+		if tc, ok := pc.client.(*net.TCPConn); ok && reset {
+			tc.SetLinger(0)
+		}
+		pc.close()
+		delete(p.conns, pc)
+	}
+}
+
+// Close stops the Proxy listening and closes all open connections. Any
+// connections held up by Pause are released.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	err := p.l.Close()
+	p.Resume()
+	p.DropConns()
+	return err
+}
@@ -0,0 +1,199 @@
+package testutil
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/kevwan/radix.v2/cluster"
+	"github.com/kevwan/radix.v2/pool"
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/kevwan/radix.v2/redistest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const timeout = 100 * time.Millisecond
+
+func testProxy(t *T) (*redistest.Fake, *Proxy) {
+	f, err := redistest.Start()
+	require.Nil(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	p, err := NewProxy(f.Addr())
+	require.Nil(t, err)
+	t.Cleanup(func() { p.Close() })
+	return f, p
+}
+
+func dial(t *T, p *Proxy) *redis.Client {
+	c, err := redis.DialTimeout("tcp", p.Addr(), timeout)
+	require.Nil(t, err)
+	t.Cleanup(func() { c.Close() })
+	require.Nil(t, c.Cmd("SET", "foo", "bar").Err)
+	return c
+}
+
+func assertCritical(t *T, c *redis.Client, r *redis.Resp) {
+	assert.True(t, r.IsType(redis.IOErr), "%v", r)
+	assert.NotNil(t, c.LastCritical)
+}
+
+func TestProxy(t *T) {
+	_, p := testProxy(t)
+	c := dial(t, p)
+	v, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", v)
+	assert.Equal(t, 1, p.NumConns())
+}
+
+func TestLatency(t *T) {
+	_, p := testProxy(t)
+	c := dial(t, p)
+
+	p.SetLatency(timeout / 10)
+	require.Nil(t, c.Cmd("GET", "foo").Err)
+
+	// a timeout on Cmd is critical, and closes the connection
+	p.SetLatency(2 * timeout)
+	r := c.Cmd("GET", "foo")
+	assert.True(t, redis.IsTimeout(r))
+	assertCritical(t, c, r)
+}
+
+func TestPause(t *T) {
+	f, p := testProxy(t)
+	c := dial(t, p)
+	require.Nil(t, c.Cmd("SUBSCRIBE", "ch").Err)
+
+	// a timeout on ReadResp isn't critical, the connection can still be read
+	// from once data arrives
+	p.Pause()
+	assert.Equal(t, 1, f.Publish("ch", "hi"))
+	r := c.ReadResp()
+	assert.True(t, redis.IsTimeout(r))
+	assert.Nil(t, c.LastCritical)
+
+	// latency set while paused applies to the data which was held up
+	p.SetLatency(2 * timeout)
+	p.Resume()
+	assert.True(t, redis.IsTimeout(c.ReadResp()))
+	time.Sleep(timeout)
+	l, err := c.ReadResp().List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"message", "ch", "hi"}, l)
+}
+
+func TestDropConns(t *T) {
+	_, p := testProxy(t)
+	c := dial(t, p)
+	p.DropConns()
+	assertCritical(t, c, c.Cmd("GET", "foo"))
+
+	c = dial(t, p)
+	p.ResetConns()
+	assertCritical(t, c, c.Cmd("GET", "foo"))
+}
+
+func TestTruncateReplies(t *T) {
+	_, p := testProxy(t)
+	c := dial(t, p)
+	require.Nil(t, c.Cmd("SET", "foo", "foobarbaz").Err)
+
+	// the reply is "$9\r\nfoobarbaz\r\n", and bytes are counted across
+	// replies, so the second is cut short
+	p.TruncateReplies(20)
+	require.Nil(t, c.Cmd("GET", "foo").Err)
+	assertCritical(t, c, c.Cmd("GET", "foo"))
+
+	// as they are for pipelined replies, however they're read
+	c = dial(t, p)
+	p.TruncateReplies(8)
+	c.PipeAppend("GET", "foo")
+	c.PipeAppend("GET", "foo")
+	assertCritical(t, c, c.PipeResp())
+
+	p.TruncateReplies(-1)
+	c = dial(t, p)
+	require.Nil(t, c.Cmd("GET", "foo").Err)
+}
+
+func TestCorruptReplies(t *T) {
+	_, p := testProxy(t)
+	c := dial(t, p)
+
+	p.CorruptReplies(func(b []byte) { b[0] = '?' })
+	assertCritical(t, c, c.Cmd("GET", "foo"))
+
+	p.CorruptReplies(nil)
+	c = dial(t, p)
+	require.Nil(t, c.Cmd("GET", "foo").Err)
+}
+
+func TestPoolEviction(t *T) {
+	_, p := testProxy(t)
+	pl, err := pool.NewCustom("tcp", p.Addr(), 1, 1, func(network, addr string) (*redis.Client, error) {
+		return redis.DialTimeout(network, addr, timeout)
+	})
+	require.Nil(t, err)
+	defer pl.Empty()
+	assert.Equal(t, 1, pl.Avail())
+
+	c, err := pl.Get()
+	require.Nil(t, err)
+	p.DropConns()
+	assertCritical(t, c, c.Cmd("GET", "foo"))
+
+	// the broken client isn't put back, and a new one can be made in its
+	// place
+	pl.Put(c)
+	assert.Equal(t, 0, pl.Avail())
+	require.Nil(t, pl.Cmd("GET", "foo").Err)
+	assert.Equal(t, 1, pl.Avail())
+}
+
+func TestClusterRetry(t *T) {
+	fc, err := redistest.StartCluster(2)
+	require.Nil(t, err)
+	defer fc.Close()
+
+	proxies := map[string]*Proxy{}
+	for _, addr := range fc.Addrs() {
+		p, err := NewProxy(addr)
+		require.Nil(t, err)
+		defer p.Close()
+		proxies[addr] = p
+	}
+
+	// node addresses are routed through their proxy, but the clients keep the
+	// node's address so the cluster can find their pool again. Each pool only
+	// holds one client, so that the retry gets a fresh one rather than another
+	// of the clients which were reset.
+	c, err := cluster.NewWithOpts(cluster.Opts{
+		Addr:          fc.Addr(),
+		PoolSize:      1,
+		ResetThrottle: -1,
+		Dialer: func(network, addr string) (*redis.Client, error) {
+			p, ok := proxies[addr]
+			if !ok {
+				return redis.DialTimeout(network, addr, timeout)
+			}
+			client, err := redis.DialTimeout(network, p.Addr(), timeout)
+			if err != nil {
+				return nil, err
+			}
+			client.Addr = addr
+			return client, nil
+		},
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	require.Nil(t, c.Cmd("SET", "foo", "bar").Err)
+	for _, p := range proxies {
+		p.ResetConns()
+	}
+	v, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", v)
+}
@@ -3,7 +3,7 @@ package util
 import (
 	"crypto/sha1"
 	"encoding/hex"
-	"strings"
+	"errors"
 
 	"github.com/kevwan/radix.v2/redis"
 )
@@ -30,7 +30,7 @@ func LuaEval(c Cmder, script string, keys int, args ...interface{}) *redis.Resp
 	var r *redis.Resp
 	if err := withClientForKey(c, mainKey, func(cc Cmder) {
 		r = cc.Cmd("EVALSHA", sum, keys, args)
-		if r.Err != nil && strings.HasPrefix(r.Err.Error(), "NOSCRIPT") {
+		if errors.Is(r.Err, redis.ErrNoScript) {
 			r = cc.Cmd("EVAL", script, keys, args)
 		}
 	}); err != nil {
@@ -0,0 +1,222 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/kevwan/radix.v2/redis"
+)
+
+// Recorded command traffic is written as plain RESP: each command is written
+// as an array of bulk strings, exactly as it would be sent to redis (with the
+// keys of any maps sorted, so that recordings are deterministic), followed by
+// its reply. This makes it easy to read, and since the requests are read
+// back using ReadRequest they can also be written in the inline format (e.g.
+// "GET foo") when editing a recording by hand.
+
+// Recorder is a Cmder which passes commands through to another Cmder, such as
+// a Client, Pool or Cluster, and records each command along with its reply.
+// The recording can later be served back by a Replayer, so that tests can be
+// run without needing a redis server.
+//
+//	f, err := os.Create("testdata/session.resp")
+//	if err != nil {
+//		// handle err
+//	}
+//	defer f.Close()
+//
+//	rec := util.NewRecorder(client, f)
+//	runTheCode(rec)
+//	if err := rec.Err(); err != nil {
+//		// handle err
+//	}
+//
+// A Recorder can be used from multiple go-routines at once, the commands are
+// recorded in the order they're completed in.
+type Recorder struct {
+	c Cmder
+
+	l   sync.Mutex
+	w   *redis.RespWriter
+	err error
+}
+
+// NewRecorder returns a Recorder which passes commands through to c, and
+// records them to w
+func NewRecorder(c Cmder, w io.Writer) *Recorder {
+	return &Recorder{c: c, w: redis.NewRespWriter(w)}
+}
+
+// Cmd implements the Cmder interface, passing the command through and
+// recording it and its reply
+func (rec *Recorder) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	r := rec.c.Cmd(cmd, args...)
+
+	rec.l.Lock()
+	defer rec.l.Unlock()
+	if rec.err != nil {
+		return r
+	}
+	if err := rec.w.WriteCmd(cmd, args...); err != nil {
+		rec.err = err
+	} else if err := rec.w.WriteResp(r); err != nil {
+		rec.err = err
+	} else {
+		rec.err = rec.w.Flush()
+	}
+	return r
+}
+
+// Err returns the first error encountered while writing the recording, if any.
+// Once there's been an error no more commands are recorded.
+func (rec *Recorder) Err() error {
+	rec.l.Lock()
+	defer rec.l.Unlock()
+	return rec.err
+}
+
+// ErrUnexpectedCmd is returned by a Replayer, wrapped with the command itself,
+// for any command which isn't in the recording (or which has already been
+// replayed as many times as it was recorded)
+var ErrUnexpectedCmd = errors.New("unexpected command")
+
+type recordedCmd struct {
+	args     [][]byte
+	r        *redis.Resp
+	replayed bool
+}
+
+// Replayer is a Cmder which serves the replies from a recording made by a
+// Recorder, rather than sending commands anywhere. Each command is matched
+// with the first recorded command with the same arguments which hasn't already
+// been replayed, so commands being sent from multiple go-routines in a
+// different order than when they were recorded is fine. Commands which can't
+// be matched get an error reply wrapping ErrUnexpectedCmd.
+//
+//	f, err := os.Open("testdata/session.resp")
+//	if err != nil {
+//		// handle err
+//	}
+//	defer f.Close()
+//
+//	rp, err := util.NewReplayer(f)
+//	if err != nil {
+//		// handle err
+//	}
+//	runTheCode(rp)
+//	if err := rp.Done(); err != nil {
+//		// the code didn't send the same commands as were recorded
+//	}
+//
+// Network errors which happened while recording are replayed as application
+// errors with the same message.
+type Replayer struct {
+	l          sync.Mutex
+	cmds       []*recordedCmd
+	unexpected []string
+}
+
+// NewReplayer reads a recording made by a Recorder from r, returning a
+// Replayer which will serve it
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	rr := redis.NewRespReader(r)
+	rp := &Replayer{}
+	for {
+		args, err := rr.ReadRequest()
+		if err == io.EOF {
+			return rp, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading command %d: %w", len(rp.cmds)+1, err)
+		}
+
+		resp := rr.Read()
+		if resp.IsType(redis.IOErr) {
+			return nil, fmt.Errorf("reading reply to command %d: %w",
+				len(rp.cmds)+1, resp.Err)
+		}
+		rp.cmds = append(rp.cmds, &recordedCmd{args: args, r: resp})
+	}
+}
+
+// cmdArgs returns the arguments of a command as they'd be read back from a
+// recording
+func cmdArgs(cmd string, args []interface{}) ([][]byte, error) {
+	buf := new(bytes.Buffer)
+	rw := redis.NewRespWriter(buf)
+	if err := rw.WriteCmd(cmd, args...); err != nil {
+		return nil, err
+	} else if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+	return redis.NewRespReader(buf).ReadRequest()
+}
+
+func argsEqual(a, b [][]byte) bool {
+	if len(a) != len(b) || len(a) == 0 {
+		return false
+	} else if !bytes.EqualFold(a[0], b[0]) {
+		return false
+	}
+	for i := 1; i < len(a); i++ {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func argsString(args [][]byte) string {
+	strs := make([]string, len(args))
+	for i := range args {
+		strs[i] = fmt.Sprintf("%q", args[i])
+	}
+	return strings.Join(strs, " ")
+}
+
+// Cmd implements the Cmder interface, returning the recorded reply to the
+// command
+func (rp *Replayer) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	l, err := cmdArgs(cmd, args)
+	if err != nil {
+		return redis.NewResp(err)
+	}
+
+	rp.l.Lock()
+	defer rp.l.Unlock()
+	for _, rc := range rp.cmds {
+		if !rc.replayed && argsEqual(rc.args, l) {
+			rc.replayed = true
+			return rc.r
+		}
+	}
+
+	s := argsString(l)
+	rp.unexpected = append(rp.unexpected, s)
+	return redis.NewResp(fmt.Errorf("%w: %s", ErrUnexpectedCmd, s))
+}
+
+// Done returns an error if any unexpected commands were sent to the Replayer,
+// or if any commands in the recording were never sent, so that tests can
+// check that the code under test sent exactly the commands which were
+// recorded
+func (rp *Replayer) Done() error {
+	rp.l.Lock()
+	defer rp.l.Unlock()
+	var msgs []string
+	for _, s := range rp.unexpected {
+		msgs = append(msgs, "unexpected command: "+s)
+	}
+	for _, rc := range rp.cmds {
+		if !rc.replayed {
+			msgs = append(msgs, "command never sent: "+argsString(rc.args))
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
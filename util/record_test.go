@@ -0,0 +1,139 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	. "testing"
+
+	"github.com/kevwan/radix.v2/redis"
+	"github.com/kevwan/radix.v2/redistest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const recordGolden = "testdata/record.resp"
+
+// recordSession sends the commands which are in the golden recording,
+// returning their replies
+func recordSession(c Cmder) []*redis.Resp {
+	return []*redis.Resp{
+		c.Cmd("SET", "foo", "bar"),
+		c.Cmd("GET", "foo"),
+		c.Cmd("GET", "nope"),
+		c.Cmd("LPUSH", "foo", "x"),
+		c.Cmd("HSET", "h", map[string]int{"a": 1}),
+		c.Cmd("HGETALL", "h"),
+		c.Cmd("INCRBY", "n", 5),
+	}
+}
+
+func assertSession(t *T, rr []*redis.Resp) {
+	s, err := rr[0].Str()
+	require.Nil(t, err)
+	assert.Equal(t, "OK", s)
+
+	s, err = rr[1].Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+
+	assert.True(t, rr[2].IsType(redis.Nil))
+	assert.True(t, errors.Is(rr[3].Err, redis.ErrWrongType))
+
+	m, err := rr[5].Map()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, m)
+
+	n, err := rr[6].Int()
+	require.Nil(t, err)
+	assert.Equal(t, 5, n)
+}
+
+func TestRecorder(t *T) {
+	f := redistest.New()
+	defer f.Close()
+	c := f.Client()
+	defer c.Close()
+
+	buf := new(bytes.Buffer)
+	rec := NewRecorder(c, buf)
+	assertSession(t, recordSession(rec))
+	require.Nil(t, rec.Err())
+
+	golden, err := ioutil.ReadFile(recordGolden)
+	require.Nil(t, err)
+	assert.Equal(t, string(golden), buf.String())
+}
+
+func TestReplayer(t *T) {
+	golden, err := ioutil.ReadFile(recordGolden)
+	require.Nil(t, err)
+	rp, err := NewReplayer(bytes.NewReader(golden))
+	require.Nil(t, err)
+	assertSession(t, recordSession(rp))
+	assert.Nil(t, rp.Done())
+
+	// everything has been replayed, so another command is unexpected
+	r := rp.Cmd("GET", "foo")
+	assert.True(t, errors.Is(r.Err, ErrUnexpectedCmd), "err:%v", r.Err)
+	assert.Equal(t, `unexpected command: "GET" "foo"`, rp.Done().Error())
+}
+
+func TestReplayerOrder(t *T) {
+	// requests can be written inline, and identical commands are replayed in
+	// the order they were recorded
+	rp, err := NewReplayer(strings.NewReader(
+		"INCR n\r\n:1\r\nGET foo\r\n$3\r\nbar\r\nINCR n\r\n:2\r\n",
+	))
+	require.Nil(t, err)
+
+	s, err := rp.Cmd("get", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	n, err := rp.Cmd("INCR", "n").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, `command never sent: "INCR" "n"`, rp.Done().Error())
+	n, err = rp.Cmd("INCR", "n").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Nil(t, rp.Done())
+
+	_, err = NewReplayer(strings.NewReader("GET foo\r\n$3\r\nba"))
+	assert.NotNil(t, err)
+}
+
+func TestRecordMapsAndNils(t *T) {
+	f := redistest.New()
+	defer f.Close()
+	c := f.Client()
+	defer c.Close()
+
+	// Maps are recorded with their keys sorted, so however go iterates over
+	// them they're replayed fine
+	m := map[string]int{"c": 3, "a": 1, "d": 4, "b": 2, "e": 5}
+	buf := new(bytes.Buffer)
+	rec := NewRecorder(c, buf)
+	require.Nil(t, rec.Cmd("HSET", "h", m).Err)
+	require.Nil(t, rec.Cmd("SET", "nil", nil).Err)
+	require.Nil(t, rec.Err())
+	assert.Contains(t, buf.String(),
+		"$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n")
+
+	// nil is recorded as what the Client actually sent
+	s, err := c.Cmd("GET", "nil").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "", s)
+	assert.Contains(t, buf.String(), "$3\r\nnil\r\n$0\r\n\r\n")
+
+	recording := buf.String()
+	for i := 0; i < 50; i++ {
+		rp, err := NewReplayer(strings.NewReader(recording))
+		require.Nil(t, err)
+		require.Nil(t, rp.Cmd("HSET", "h", m).Err)
+		require.Nil(t, rp.Cmd("SET", "nil", nil).Err)
+		require.Nil(t, rp.Done())
+	}
+}